@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/mxab/tf-registry/internal/cli"
+)
+
+func main() {
+	if err := cli.NewRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
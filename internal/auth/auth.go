@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// Scope is a permission a token grants
+type Scope string
+
+const (
+	// ScopeRead allows to list, search and download modules
+	ScopeRead Scope = "read"
+	// ScopePublish allows to upload modules
+	ScopePublish Scope = "publish"
+)
+
+// ErrInvalidToken is returned by an Authenticator for tokens it does not know
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject string
+	// Groups the subject belongs to, namespaces grant roles to users and groups
+	Groups []string
+	Scopes []Scope
+	// Admin identities have every scope and may use admin only features
+	Admin bool
+	// Grants are roles in namespaces the authenticator granted besides the bindings of the namespaces.
+	// Identities with grants, even empty ones, only publish to namespaces they have a role in
+	Grants map[string]service.Role
+}
+
+func (i Identity) HasScope(scope Scope) bool {
+	if i.Admin {
+		return true
+	}
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Role returns the highest role the bindings of a namespace grant the identity, empty if it is no member
+func (i Identity) Role(namespace service.Namespace) service.Role {
+	members := map[string]bool{"user:" + i.Subject: true}
+	for _, group := range i.Groups {
+		members["group:"+group] = true
+	}
+	role := i.Grants[namespace.Name]
+	for _, binding := range namespace.Bindings {
+		if members[binding.Member] && !role.Includes(binding.Role) {
+			role = binding.Role
+		}
+	}
+	return role
+}
+
+// Authenticator resolves the identity of a bearer token
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
+
+// Authenticators tries each authenticator in turn until one knows the token
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(token string) (Identity, error) {
+	for _, authenticator := range a {
+		identity, err := authenticator.Authenticate(token)
+		if errors.Is(err, ErrInvalidToken) {
+			continue
+		}
+		return identity, err
+	}
+	return Identity{}, ErrInvalidToken
+}
+
+const identityKey = "auth.identity"
+
+// IdentityFrom returns the identity the middleware authenticated for the request
+func IdentityFrom(c echo.Context) (Identity, bool) {
+	identity, ok := c.Get(identityKey).(Identity)
+	return identity, ok
+}
+
+// IsAdmin is an IsAdmin check of the controllers that accepts admin identities
+func IsAdmin(c echo.Context) bool {
+	identity, ok := IdentityFrom(c)
+	return ok && identity.Admin
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Config of the authentication middleware
+type Config struct {
+	Authenticator Authenticator
+	// AnonymousRead lets requests without token read modules, uploads always require the publish scope
+	AnonymousRead bool
+}
+
+// Middleware authenticates the bearer token of a request, the way terraform sends the tokens of its credentials block or TF_TOKEN_<host> variables.
+// Reading requests require the read scope and all others the publish scope
+func Middleware(config Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			scope := ScopePublish
+			if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+				scope = ScopeRead
+			}
+
+			token, ok := bearerToken(c.Request())
+			if !ok {
+				if scope == ScopeRead && config.AnonymousRead {
+					return next(c)
+				}
+				return unauthorized(c, "missing bearer token")
+			}
+			identity, err := config.Authenticator.Authenticate(token)
+			if errors.Is(err, ErrInvalidToken) {
+				return unauthorized(c, "invalid bearer token")
+			}
+			if err != nil {
+				return err
+			}
+			c.Set(identityKey, identity)
+
+			if !identity.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "token lacks the "+string(scope)+" scope")
+			}
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get(echo.HeaderAuthorization)
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
+
+func unauthorized(c echo.Context, message string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="tf-registry"`)
+	return echo.NewHTTPError(http.StatusUnauthorized, message)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	tokens, err := NewStaticTokens([]TokenEntry{
+		{Subject: "reader", SHA256: HashToken("reader"), Scopes: []Scope{ScopeRead}},
+		{Subject: "ci", SHA256: HashToken("ci"), Scopes: []Scope{ScopeRead, ScopePublish}},
+	})
+	require.NoError(t, err)
+
+	table := []struct {
+		name          string
+		anonymousRead bool
+		method        string
+		authorization string
+		expectedCode  int
+		expectedUser  string
+	}{
+		{name: "anonymous read", anonymousRead: true, method: http.MethodGet, expectedCode: http.StatusOK},
+		{name: "private read", method: http.MethodGet, expectedCode: http.StatusUnauthorized},
+		{name: "read with token", method: http.MethodHead, authorization: "Bearer reader", expectedCode: http.StatusOK, expectedUser: "reader"},
+		{name: "lower case scheme", method: http.MethodGet, authorization: "bearer reader", expectedCode: http.StatusOK, expectedUser: "reader"},
+		{name: "invalid token", anonymousRead: true, method: http.MethodGet, authorization: "Bearer nope", expectedCode: http.StatusUnauthorized},
+		{name: "basic auth", method: http.MethodGet, authorization: "Basic cmVhZGVyOg==", expectedCode: http.StatusUnauthorized},
+		{name: "anonymous publish", anonymousRead: true, method: http.MethodPost, expectedCode: http.StatusUnauthorized},
+		{name: "publish without scope", method: http.MethodPost, authorization: "Bearer reader", expectedCode: http.StatusForbidden},
+		{name: "publish", method: http.MethodPost, authorization: "Bearer ci", expectedCode: http.StatusOK, expectedUser: "ci"},
+	}
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(test.method, "/", nil)
+			if test.authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, test.authorization)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			user := ""
+			err := Middleware(Config{Authenticator: tokens, AnonymousRead: test.anonymousRead})(func(c echo.Context) error {
+				identity, _ := IdentityFrom(c)
+				user = identity.Subject
+				return c.NoContent(http.StatusOK)
+			})(c)
+
+			if test.expectedCode == http.StatusOK {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedUser, user)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Equal(t, test.expectedCode, err.(*echo.HTTPError).Code)
+			}
+			if test.expectedCode == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="tf-registry"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
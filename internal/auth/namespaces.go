@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// NamespaceAuthorizer decides what the caller of a request may do in a namespace from the bindings of the namespace.
+// It keeps the settings of all namespaces in memory, they are reloaded after the refresh interval to pick up changes of other registry instances
+// and updated right away for changes made through the authorizer
+type NamespaceAuthorizer struct {
+	service.NamespaceStore
+	RefreshInterval time.Duration
+
+	mu         sync.Mutex
+	namespaces map[string]service.Namespace
+	loadedAt   time.Time
+	now        func() time.Time
+}
+
+// NewNamespaceAuthorizer creates an authorizer for the namespaces of the store, without store all namespaces are public and have no bindings
+func NewNamespaceAuthorizer(namespaces service.NamespaceStore) *NamespaceAuthorizer {
+	return &NamespaceAuthorizer{
+		NamespaceStore:  namespaces,
+		RefreshInterval: time.Minute,
+		now:             time.Now,
+	}
+}
+
+func (a *NamespaceAuthorizer) PutNamespace(namespace service.Namespace) error {
+	if err := a.NamespaceStore.PutNamespace(namespace); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.namespaces != nil {
+		// loaded maps are shared with running checks, updates copy them
+		namespaces := make(map[string]service.Namespace, len(a.namespaces)+1)
+		for name, ns := range a.namespaces {
+			namespaces[name] = ns
+		}
+		namespaces[namespace.Name] = namespace
+		a.namespaces = namespaces
+	}
+	return nil
+}
+
+// CanRead allows everyone to read public namespaces and members to read private ones
+func (a *NamespaceAuthorizer) CanRead(c echo.Context, name string) (bool, error) {
+	return a.allowed(c, name, func(identity *Identity, namespace service.Namespace) bool {
+		return !namespace.Private || (identity != nil && identity.Role(namespace).Includes(service.RoleReader))
+	})
+}
+
+// CanPublish allows publishing to namespaces without bindings, once a namespace has bindings only its owners and publishers may publish.
+// Identities with grants only publish where they have the publisher role
+func (a *NamespaceAuthorizer) CanPublish(c echo.Context, name string) (bool, error) {
+	return a.allowed(c, name, func(identity *Identity, namespace service.Namespace) bool {
+		if identity == nil {
+			return false
+		}
+		if len(namespace.Bindings) == 0 && identity.Grants == nil {
+			return true
+		}
+		return identity.Role(namespace).Includes(service.RolePublisher)
+	})
+}
+
+// CanManage allows the owners of a namespace to change its bindings and privacy
+func (a *NamespaceAuthorizer) CanManage(c echo.Context, name string) (bool, error) {
+	return a.allowed(c, name, func(identity *Identity, namespace service.Namespace) bool {
+		return identity != nil && identity.Role(namespace).Includes(service.RoleOwner)
+	})
+}
+
+// HiddenNamespaces returns the private namespaces the caller is no member of
+func (a *NamespaceAuthorizer) HiddenNamespaces(c echo.Context) ([]string, error) {
+	identity, ok := IdentityFrom(c)
+	if ok && identity.Admin {
+		return nil, nil
+	}
+	namespaces, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	hidden := []string{}
+	for _, namespace := range namespaces {
+		if namespace.Private && (!ok || identity.Role(namespace) == "") {
+			hidden = append(hidden, namespace.Name)
+		}
+	}
+	return hidden, nil
+}
+
+// allowed lets admins do everything and asks the rule for everyone else, the rule gets no identity for anonymous requests
+func (a *NamespaceAuthorizer) allowed(c echo.Context, name string, rule func(identity *Identity, namespace service.Namespace) bool) (bool, error) {
+	identity, ok := IdentityFrom(c)
+	if ok && identity.Admin {
+		return true, nil
+	}
+	namespaces, err := a.load()
+	if err != nil {
+		return false, err
+	}
+	namespace, found := namespaces[name]
+	if !found {
+		// namespaces without settings are public and have no bindings
+		namespace = service.Namespace{Name: name}
+	}
+	if !ok {
+		return rule(nil, namespace), nil
+	}
+	return rule(&identity, namespace), nil
+}
+
+// load returns the settings of all namespaces, reloading them if they are older than the refresh interval
+func (a *NamespaceAuthorizer) load() (map[string]service.Namespace, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if a.namespaces != nil && (a.RefreshInterval <= 0 || now.Sub(a.loadedAt) < a.RefreshInterval) {
+		return a.namespaces, nil
+	}
+	if a.NamespaceStore == nil {
+		// the module service keeps no namespace settings, all namespaces are public and have no bindings
+		a.namespaces, a.loadedAt = map[string]service.Namespace{}, now
+		return a.namespaces, nil
+	}
+	namespaces, err := a.NamespaceStore.Namespaces()
+	if errors.Is(err, service.ErrNotFound) {
+		// the storage does not manage namespaces, all of them are public
+		namespaces, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.namespaces = map[string]service.Namespace{}
+	for _, namespace := range namespaces {
+		a.namespaces[namespace.Name] = namespace
+	}
+	a.loadedAt = now
+	return a.namespaces, nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryNamespaces struct {
+	namespaces map[string]service.Namespace
+	loads      int
+}
+
+func (m *memoryNamespaces) Namespaces() ([]service.Namespace, error) {
+	m.loads++
+	namespaces := []service.Namespace{}
+	for _, ns := range m.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+func (m *memoryNamespaces) Namespace(name string) (service.Namespace, error) {
+	ns, ok := m.namespaces[name]
+	if !ok {
+		return ns, fmt.Errorf("namespace %s: %w", name, service.ErrNotFound)
+	}
+	return ns, nil
+}
+
+func (m *memoryNamespaces) PutNamespace(ns service.Namespace) error {
+	m.namespaces[ns.Name] = ns
+	return nil
+}
+
+func contextOf(identity *Identity) echo.Context {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	if identity != nil {
+		c.Set(identityKey, *identity)
+	}
+	return c
+}
+
+func TestNamespaceAuthorizer(t *testing.T) {
+	store := &memoryNamespaces{namespaces: map[string]service.Namespace{
+		"acme": {Name: "acme", Private: true, Bindings: []service.Binding{
+			{Role: service.RoleOwner, Member: "user:alice"},
+			{Role: service.RolePublisher, Member: "group:ci"},
+			{Role: service.RoleReader, Member: "group:developers"},
+		}},
+		"hashicorp": {Name: "hashicorp", Bindings: []service.Binding{{Role: service.RoleOwner, Member: "user:mitchell"}}},
+	}}
+	authorizer := NewNamespaceAuthorizer(store)
+
+	alice := &Identity{Subject: "alice"}
+	pipeline := &Identity{Subject: "pipeline", Groups: []string{"ci"}}
+	developer := &Identity{Subject: "dave", Groups: []string{"developers"}}
+	stranger := &Identity{Subject: "eve"}
+	admin := &Identity{Subject: "admin", Admin: true}
+
+	table := []struct {
+		identity  *Identity
+		namespace string
+		read      bool
+		publish   bool
+		manage    bool
+	}{
+		{identity: alice, namespace: "acme", read: true, publish: true, manage: true},
+		{identity: pipeline, namespace: "acme", read: true, publish: true},
+		{identity: developer, namespace: "acme", read: true},
+		{identity: stranger, namespace: "acme"},
+		{identity: nil, namespace: "acme"},
+		{identity: admin, namespace: "acme", read: true, publish: true, manage: true},
+		{identity: alice, namespace: "hashicorp", read: true},
+		{identity: nil, namespace: "hashicorp", read: true},
+		// namespaces without bindings are open to every publisher
+		{identity: stranger, namespace: "zoitech", read: true, publish: true},
+		{identity: nil, namespace: "zoitech", read: true},
+	}
+	for _, test := range table {
+		c := contextOf(test.identity)
+		name := fmt.Sprintf("%v in %s", test.identity, test.namespace)
+
+		read, err := authorizer.CanRead(c, test.namespace)
+		assert.NoError(t, err)
+		assert.Equal(t, test.read, read, name)
+		publish, err := authorizer.CanPublish(c, test.namespace)
+		assert.NoError(t, err)
+		assert.Equal(t, test.publish, publish, name)
+		manage, err := authorizer.CanManage(c, test.namespace)
+		assert.NoError(t, err)
+		assert.Equal(t, test.manage, manage, name)
+	}
+
+	for identity, expected := range map[*Identity][]string{nil: {"acme"}, stranger: {"acme"}, developer: {}, admin: nil} {
+		hidden, err := authorizer.HiddenNamespaces(contextOf(identity))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, hidden)
+	}
+	assert.Equal(t, 1, store.loads)
+}
+
+func TestNamespaceAuthorizerRefreshes(t *testing.T) {
+	store := &memoryNamespaces{namespaces: map[string]service.Namespace{}}
+	authorizer := NewNamespaceAuthorizer(store)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	authorizer.now = func() time.Time { return now }
+	stranger := contextOf(&Identity{Subject: "eve"})
+
+	read, err := authorizer.CanRead(stranger, "acme")
+	require.NoError(t, err)
+	assert.True(t, read)
+
+	// changes through the authorizer apply right away
+	require.NoError(t, authorizer.PutNamespace(service.Namespace{Name: "acme", Private: true}))
+	read, err = authorizer.CanRead(stranger, "acme")
+	require.NoError(t, err)
+	assert.False(t, read)
+
+	// changes of other instances after the refresh interval
+	store.namespaces["acme"] = service.Namespace{Name: "acme"}
+	read, err = authorizer.CanRead(stranger, "acme")
+	require.NoError(t, err)
+	assert.False(t, read)
+	now = now.Add(authorizer.RefreshInterval)
+	read, err = authorizer.CanRead(stranger, "acme")
+	require.NoError(t, err)
+	assert.True(t, read)
+	assert.Equal(t, 2, store.loads)
+}
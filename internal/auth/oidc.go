@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+type (
+	// OIDCConfig configures the issuers whose tokens are accepted for publishing and the rules granting namespaces to them
+	OIDCConfig struct {
+		Issuers []OIDCIssuer `json:"issuers"`
+		Rules   []OIDCRule   `json:"rules"`
+	}
+	OIDCIssuer struct {
+		// Issuer is the iss claim, e.g. https://token.actions.githubusercontent.com
+		Issuer string `json:"issuer"`
+		// Audience the tokens must be issued for, usually the url of the registry
+		Audience string `json:"audience"`
+		// JWKSFile reads the signing keys from a file instead of the issuer
+		JWKSFile string `json:"jwks_file"`
+		// JWKSURL overrides the jwks_uri of the openid configuration of the issuer
+		JWKSURL string `json:"jwks_url"`
+		// SubjectClaim names the identity that becomes the owner of published modules, defaults to sub
+		SubjectClaim string `json:"subject_claim"`
+	}
+	// OIDCRule grants publishing to namespaces to tokens whose claims match all patterns.
+	// Patterns match like path.Match, namespaces can refer to claims like {repository_owner}
+	OIDCRule struct {
+		Issuer     string            `json:"issuer"`
+		Claims     map[string]string `json:"claims"`
+		Namespaces []string          `json:"namespaces"`
+	}
+)
+
+// leeway tolerates clock differences between the issuer and the registry
+const leeway = time.Minute
+
+// OIDCAuthenticator authenticates short lived tokens of ci systems, they can only publish to the namespaces the rules grant
+type OIDCAuthenticator struct {
+	issuers map[string]*IDTokenVerifier
+	rules   []OIDCRule
+	now     func() time.Time
+}
+
+// IDTokenVerifier verifies the signature, audience and lifetime of the tokens of an issuer
+type IDTokenVerifier struct {
+	OIDCIssuer
+	keys *keySet
+}
+
+// LoadOIDCConfig reads an oidc config file, relative jwks files are resolved against its directory
+func LoadOIDCConfig(file string) (*OIDCAuthenticator, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := OIDCConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse oidc config %s: %w", file, err)
+	}
+	for i, issuer := range config.Issuers {
+		if issuer.JWKSFile != "" && !filepath.IsAbs(issuer.JWKSFile) {
+			config.Issuers[i].JWKSFile = filepath.Join(filepath.Dir(file), issuer.JWKSFile)
+		}
+	}
+	return NewOIDCAuthenticator(config)
+}
+
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	issuers := map[string]*IDTokenVerifier{}
+	for _, issuer := range config.Issuers {
+		verifier, err := NewIDTokenVerifier(issuer)
+		if err != nil {
+			return nil, err
+		}
+		issuers[issuer.Issuer] = verifier
+	}
+	for _, rule := range config.Rules {
+		if _, ok := issuers[rule.Issuer]; !ok {
+			return nil, fmt.Errorf("oidc rule for unknown issuer %q", rule.Issuer)
+		}
+		for claim, pattern := range rule.Claims {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("oidc rule: pattern of claim %s: %w", claim, err)
+			}
+		}
+	}
+	return &OIDCAuthenticator{issuers: issuers, rules: config.Rules, now: time.Now}, nil
+}
+
+func NewIDTokenVerifier(issuer OIDCIssuer) (*IDTokenVerifier, error) {
+	if issuer.Issuer == "" || issuer.Audience == "" {
+		return nil, fmt.Errorf("oidc issuer %q: issuer and audience are required", issuer.Issuer)
+	}
+	if issuer.SubjectClaim == "" {
+		issuer.SubjectClaim = "sub"
+	}
+	keys := remoteKeySet(issuer.Issuer, issuer.JWKSURL)
+	if issuer.JWKSFile != "" {
+		var err error
+		if keys, err = loadKeySet(issuer.JWKSFile); err != nil {
+			return nil, fmt.Errorf("oidc issuer %s: %w", issuer.Issuer, err)
+		}
+	}
+	return &IDTokenVerifier{OIDCIssuer: issuer, keys: keys}, nil
+}
+
+// Authenticate verifies tokens of the configured issuers, other tokens are left to the other authenticators
+func (a *OIDCAuthenticator) Authenticate(token string) (Identity, error) {
+	unverified := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, unverified); err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+	iss, _ := unverified["iss"].(string)
+	issuer, ok := a.issuers[iss]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	claims, err := issuer.verify(token, a.now())
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Subject: claims[issuer.SubjectClaim].(string),
+		Scopes:  []Scope{ScopePublish},
+		Grants:  a.grants(iss, claims),
+	}, nil
+}
+
+// Verify returns the claims of a valid token of the issuer, the subject claim is always a non empty string
+func (v *IDTokenVerifier) Verify(token string) (jwt.MapClaims, error) {
+	return v.verify(token, time.Now())
+}
+
+func (v *IDTokenVerifier) verify(token string, now time.Time) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{
+		ValidMethods:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		SkipClaimsValidation: true,
+	}
+	if _, err := parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidToken, v.Issuer, err)
+	}
+	if err := v.validate(claims, now); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidToken, v.Issuer, err)
+	}
+	if subject, _ := claims[v.SubjectClaim].(string); subject == "" {
+		return nil, fmt.Errorf("%w: %s: missing %s claim", ErrInvalidToken, v.Issuer, v.SubjectClaim)
+	}
+	return claims, nil
+}
+
+func (v *IDTokenVerifier) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := v.keys.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	// the key type has to fit the algorithm, a rsa key cannot verify an ecdsa signature
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("key %q does not fit algorithm %s", kid, token.Method.Alg())
+}
+
+// validate checks the issuer, the audience and that the token is valid now, expiry is required as only short lived tokens are accepted
+func (v *IDTokenVerifier) validate(claims jwt.MapClaims, now time.Time) error {
+	if !claims.VerifyIssuer(v.Issuer, true) {
+		return fmt.Errorf("token is not issued by %s", v.Issuer)
+	}
+	if !claims.VerifyAudience(v.Audience, true) {
+		return fmt.Errorf("token is not issued for %s", v.Audience)
+	}
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), true) {
+		return fmt.Errorf("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) || !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	return nil
+}
+
+// grants collects the namespaces of all rules matching the claims
+func (a *OIDCAuthenticator) grants(issuer string, claims jwt.MapClaims) map[string]service.Role {
+	grants := map[string]service.Role{}
+	for _, rule := range a.rules {
+		if rule.Issuer != issuer || !matchClaims(rule.Claims, claims) {
+			continue
+		}
+		for _, namespace := range rule.Namespaces {
+			if namespace, ok := expandClaims(namespace, claims); ok {
+				grants[namespace] = service.RolePublisher
+			}
+		}
+	}
+	return grants
+}
+
+func matchClaims(patterns map[string]string, claims jwt.MapClaims) bool {
+	for claim, pattern := range patterns {
+		value, ok := claims[claim].(string)
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// expandClaims replaces {claim} with the value of the claim, it fails for missing claims or values that are no valid namespace
+func expandClaims(template string, claims jwt.MapClaims) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			b.WriteString(template)
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return "", false
+		}
+		value, ok := claims[template[start+1:start+end]].(string)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(template[:start])
+		b.WriteString(value)
+		template = template[start+end+1:]
+	}
+	namespace := b.String()
+	return namespace, service.ValidateNamespace(namespace) == nil
+}
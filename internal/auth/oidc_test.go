@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const githubIssuer = "https://token.actions.githubusercontent.com"
+
+func rsaJWKS(kid string, key *rsa.PublicKey) string {
+	return fmt.Sprintf(`{"keys": [{"kid": %q, "kty": "RSA", "use": "sig", "alg": "RS256", "n": %q, "e": %q}]}`, kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func githubClaims(now time.Time, repository, ref string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":              githubIssuer,
+		"aud":              "https://registry.example.com",
+		"sub":              "repo:" + repository + ":ref:" + ref,
+		"repository":       repository,
+		"repository_owner": repository[:len(repository)-len(filepath.Base(repository))-1],
+		"ref":              ref,
+		"iat":              now.Unix(),
+		"exp":              now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "jwks.json"), []byte(rsaJWKS("key-1", &key.PublicKey)), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "oidc.json"), []byte(`{
+		"issuers": [{"issuer": "`+githubIssuer+`", "audience": "https://registry.example.com", "jwks_file": "jwks.json", "subject_claim": "repository"}],
+		"rules": [
+			{"issuer": "`+githubIssuer+`", "claims": {"repository": "acme/*", "ref": "refs/heads/main"}, "namespaces": ["acme", "acme-internal"]},
+			{"issuer": "`+githubIssuer+`", "claims": {"ref": "refs/tags/v*"}, "namespaces": ["{repository_owner}"]}
+		]
+	}`), 0o600))
+	authenticator, err := LoadOIDCConfig(filepath.Join(dir, "oidc.json"))
+	require.NoError(t, err)
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	identity, err := authenticator.Authenticate(signToken(t, key, "key-1", githubClaims(now, "acme/infra", "refs/heads/main")))
+	require.NoError(t, err)
+	assert.Equal(t, Identity{
+		Subject: "acme/infra",
+		Scopes:  []Scope{ScopePublish},
+		Grants:  map[string]service.Role{"acme": service.RolePublisher, "acme-internal": service.RolePublisher},
+	}, identity)
+
+	identity, err = authenticator.Authenticate(signToken(t, key, "key-1", githubClaims(now, "zoitech/network", "refs/tags/v1.0.0")))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]service.Role{"zoitech": service.RolePublisher}, identity.Grants)
+
+	// no rule matches feature branches, the token is valid but may not publish anywhere
+	identity, err = authenticator.Authenticate(signToken(t, key, "key-1", githubClaims(now, "acme/infra", "refs/heads/feature")))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]service.Role{}, identity.Grants)
+
+	invalid := map[string]string{}
+	claims := githubClaims(now, "acme/infra", "refs/heads/main")
+	claims["aud"] = "https://other.example.com"
+	invalid["wrong audience"] = signToken(t, key, "key-1", claims)
+	invalid["expired"] = signToken(t, key, "key-1", githubClaims(now.Add(-time.Hour), "acme/infra", "refs/heads/main"))
+	claims = githubClaims(now, "acme/infra", "refs/heads/main")
+	delete(claims, "exp")
+	invalid["without expiry"] = signToken(t, key, "key-1", claims)
+	invalid["not yet valid"] = signToken(t, key, "key-1", githubClaims(now.Add(time.Hour), "acme/infra", "refs/heads/main"))
+	invalid["other key"] = signToken(t, otherKey, "key-1", githubClaims(now, "acme/infra", "refs/heads/main"))
+	invalid["unknown key"] = signToken(t, key, "key-2", githubClaims(now, "acme/infra", "refs/heads/main"))
+	claims = githubClaims(now, "acme/infra", "refs/heads/main")
+	claims["iss"] = "https://gitlab.com"
+	invalid["unknown issuer"] = signToken(t, key, "key-1", claims)
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, githubClaims(now, "acme/infra", "refs/heads/main")).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	invalid["symmetric"] = hmac
+	invalid["no jwt"] = "tfr_0123"
+	for name, token := range invalid {
+		_, err := authenticator.Authenticate(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestOIDCAuthenticatorDiscoversKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var issuer *httptest.Server
+	jwksRequests := 0
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, issuer.URL, issuer.URL+"/keys")
+		case "/keys":
+			jwksRequests++
+			fmt.Fprintf(w, `{"keys": [{"kid": "ec", "kty": "EC", "crv": "P-256", "x": %q, "y": %q}]}`,
+				base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer issuer.Close()
+
+	authenticator, err := NewOIDCAuthenticator(OIDCConfig{
+		Issuers: []OIDCIssuer{{Issuer: issuer.URL, Audience: "tf-registry"}},
+		Rules:   []OIDCRule{{Issuer: issuer.URL, Claims: map[string]string{"project_path": "acme/*"}, Namespaces: []string{"acme"}}},
+	})
+	require.NoError(t, err)
+
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss":          issuer.URL,
+			"aud":          []string{"tf-registry"},
+			"sub":          "project_path:acme/infra:ref_type:branch:ref:main",
+			"project_path": "acme/infra",
+			"exp":          time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	identity, err := authenticator.Authenticate(sign("ec"))
+	require.NoError(t, err)
+	assert.Equal(t, "project_path:acme/infra:ref_type:branch:ref:main", identity.Subject)
+	assert.Equal(t, map[string]service.Role{"acme": service.RolePublisher}, identity.Grants)
+
+	// unknown keys fetch the keys again, but not more than once a minute
+	_, err = authenticator.Authenticate(sign("rotated"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = authenticator.Authenticate(sign("rotated"))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, jwksRequests)
+}
+
+func TestNewOIDCAuthenticatorValidatesConfig(t *testing.T) {
+	_, err := NewOIDCAuthenticator(OIDCConfig{Issuers: []OIDCIssuer{{Issuer: githubIssuer}}})
+	assert.EqualError(t, err, `oidc issuer "`+githubIssuer+`": issuer and audience are required`)
+
+	_, err = NewOIDCAuthenticator(OIDCConfig{Rules: []OIDCRule{{Issuer: githubIssuer}}})
+	assert.EqualError(t, err, `oidc rule for unknown issuer "`+githubIssuer+`"`)
+}
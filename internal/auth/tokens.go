@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type (
+	// TokenEntry is a token of the tokens file, only the sha256 hash of the token is stored
+	TokenEntry struct {
+		Subject string   `json:"subject"`
+		SHA256  string   `json:"sha256"`
+		Scopes  []Scope  `json:"scopes"`
+		Groups  []string `json:"groups,omitempty"`
+	}
+	TokensFile struct {
+		Tokens []TokenEntry `json:"tokens"`
+	}
+)
+
+// StaticTokens authenticates a fixed set of tokens
+type StaticTokens struct {
+	identities map[string]Identity
+}
+
+func NewStaticTokens(entries []TokenEntry) (*StaticTokens, error) {
+	identities := map[string]Identity{}
+	for _, entry := range entries {
+		hash, err := hex.DecodeString(entry.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token of %q: sha256 must be a hex encoded sha256 hash", entry.Subject)
+		}
+		if entry.Subject == "" {
+			return nil, fmt.Errorf("token %s: missing subject", entry.SHA256)
+		}
+		for _, scope := range entry.Scopes {
+			if scope != ScopeRead && scope != ScopePublish {
+				return nil, fmt.Errorf("token of %q: unknown scope %q, use read or publish", entry.Subject, scope)
+			}
+		}
+		identities[string(hash)] = Identity{Subject: entry.Subject, Groups: entry.Groups, Scopes: entry.Scopes}
+	}
+	return &StaticTokens{identities: identities}, nil
+}
+
+// LoadTokens reads a tokens file
+func LoadTokens(path string) (*StaticTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := TokensFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file %s: %w", path, err)
+	}
+	return NewStaticTokens(file.Tokens)
+}
+
+func (s *StaticTokens) Authenticate(token string) (Identity, error) {
+	hash := sha256.Sum256([]byte(token))
+	identity, ok := s.identities[string(hash[:])]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	return identity, nil
+}
+
+// AdminToken authenticates a single token as admin, an empty token authenticates nothing
+type AdminToken string
+
+func (a AdminToken) Authenticate(token string) (Identity, error) {
+	if a == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a)) != 1 {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{Subject: "admin", Admin: true}, nil
+}
+
+// HashToken returns the hex encoded sha256 hash of a token as it is stored in the tokens file
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// GenerateToken returns a new random token
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "tfr_" + hex.EncodeToString(b), nil
+}
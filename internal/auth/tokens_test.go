@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens": [
+		{"subject": "ci", "sha256": "`+HashToken("secret")+`", "scopes": ["read", "publish"]}
+	]}`), 0o600))
+
+	tokens, err := LoadTokens(path)
+	require.NoError(t, err)
+
+	identity, err := tokens.Authenticate("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "ci", Scopes: []Scope{ScopeRead, ScopePublish}}, identity)
+	assert.True(t, identity.HasScope(ScopePublish))
+
+	_, err = tokens.Authenticate("Secret")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestNewStaticTokensValidatesEntries(t *testing.T) {
+	table := []struct {
+		entry    TokenEntry
+		expected string
+	}{
+		{entry: TokenEntry{Subject: "ci", SHA256: "secret"}, expected: `token of "ci": sha256 must be a hex encoded sha256 hash`},
+		{entry: TokenEntry{SHA256: HashToken("secret")}, expected: "token " + HashToken("secret") + ": missing subject"},
+		{entry: TokenEntry{Subject: "ci", SHA256: HashToken("secret"), Scopes: []Scope{"admin"}}, expected: `token of "ci": unknown scope "admin", use read or publish`},
+	}
+	for _, test := range table {
+		_, err := NewStaticTokens([]TokenEntry{test.entry})
+		assert.EqualError(t, err, test.expected)
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	tokens, err := NewStaticTokens([]TokenEntry{{Subject: "ci", SHA256: HashToken("secret"), Scopes: []Scope{ScopeRead}}})
+	require.NoError(t, err)
+	authenticators := Authenticators{AdminToken(""), AdminToken("admin"), tokens}
+
+	identity, err := authenticators.Authenticate("admin")
+	assert.NoError(t, err)
+	assert.True(t, identity.Admin)
+	assert.True(t, identity.HasScope(ScopePublish))
+
+	identity, err = authenticators.Authenticate("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", identity.Subject)
+	assert.False(t, identity.HasScope(ScopePublish))
+
+	_, err = authenticators.Authenticate("")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
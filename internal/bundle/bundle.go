@@ -0,0 +1,327 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/samber/lo"
+)
+
+// ManifestSchemaVersion is the version of the manifest written by this registry
+const ManifestSchemaVersion = 1
+
+const (
+	manifestName  = "manifest.json"
+	signatureName = "manifest.json.sig"
+	modulesDir    = "modules/"
+)
+
+type (
+	// Manifest is the first file of a bundle, it lists the archives with their checksums and is signed by the exporting registry
+	Manifest struct {
+		SchemaVersion int       `json:"schema_version"`
+		CreatedAt     time.Time `json:"created_at"`
+		// Namespaces are the settings of the exported namespaces that have any
+		Namespaces []service.Namespace `json:"namespaces,omitempty"`
+		Modules    []Module            `json:"modules"`
+	}
+	// Module is an exported version, File is the path of its archive in the bundle
+	Module struct {
+		Namespace   string `json:"namespace"`
+		Name        string `json:"name"`
+		System      string `json:"system"`
+		Version     string `json:"version"`
+		Owner       string `json:"owner"`
+		Description string `json:"description,omitempty"`
+		PublishedAt string `json:"published_at,omitempty"`
+		SHA256      string `json:"sha256"`
+		Size        int64  `json:"size"`
+		File        string `json:"file"`
+	}
+	// Selector selects a namespace, a module or a version of a module, the more specific fields are empty for broader selections
+	Selector struct {
+		Namespace string
+		Name      string
+		System    string
+		Version   string
+	}
+	// Exporter writes the selected versions of the modules to a bundle signed by the signer
+	Exporter struct {
+		Modules service.ModuleService
+		Signer  *providerservice.Signer
+		// Client downloads the archives of module services that are not an archive store
+		Client *http.Client
+	}
+)
+
+func (m Module) Descriptor() service.ModuleDescriptor {
+	return service.ModuleDescriptor{Namespace: m.Namespace, Name: m.Name, System: m.System}
+}
+
+func (m Module) String() string {
+	return fmt.Sprintf("%s/%s/%s %s", m.Namespace, m.Name, m.System, m.Version)
+}
+
+// ParseSelector accepts <namespace>, <namespace>/<name>/<system> or <namespace>/<name>/<system>/<version>
+func ParseSelector(s string) (Selector, error) {
+	parts := strings.Split(s, "/")
+	if lo.Contains(parts, "") || (len(parts) != 1 && len(parts) != 3 && len(parts) != 4) {
+		return Selector{}, fmt.Errorf("invalid selection %q, use namespace, namespace/name/system or namespace/name/system/version", s)
+	}
+	selector := Selector{Namespace: parts[0]}
+	if len(parts) >= 3 {
+		selector.Name, selector.System = parts[1], parts[2]
+	}
+	if len(parts) == 4 {
+		version, err := service.NormalizeVersion(parts[3])
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid selection %q: %w", s, err)
+		}
+		selector.Version = version
+	}
+	return selector, nil
+}
+
+// Export writes a gzipped tarball with the manifest, its signature and the archives of the selected versions.
+// The archives are spooled to a temporary directory, so each of them is read once
+func (e *Exporter) Export(w io.Writer, selectors []Selector) (Manifest, error) {
+	if e.Signer == nil {
+		return Manifest{}, errors.New("bundles are signed, a gpg key is required")
+	}
+	manifest, err := e.resolve(selectors)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(manifest.Modules) == 0 {
+		return Manifest{}, fmt.Errorf("no module versions selected: %w", service.ErrNotFound)
+	}
+
+	spool, err := os.MkdirTemp("", "tfr-bundle-")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(spool)
+	for i := range manifest.Modules {
+		if err := e.spoolArchive(spool, &manifest.Modules[i]); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	signature, err := e.Signer.Sign(encoded)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{manifestName, encoded}, {signatureName, signature}} {
+		if err := writeTarHeader(tw, file.name, int64(len(file.data)), manifest.CreatedAt); err != nil {
+			return Manifest{}, err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return Manifest{}, err
+		}
+	}
+	for _, modul := range manifest.Modules {
+		if err := copyToTar(tw, filepath.Join(spool, filepath.FromSlash(modul.File)), modul, manifest.CreatedAt); err != nil {
+			return Manifest{}, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, gz.Close()
+}
+
+// resolve lists the selected versions sorted by module and version and the settings of the selected namespaces
+func (e *Exporter) resolve(selectors []Selector) (Manifest, error) {
+	manifest := Manifest{SchemaVersion: ManifestSchemaVersion, CreatedAt: time.Now().UTC(), Modules: []Module{}}
+	selected := map[string]bool{}
+	namespaces := map[string]bool{}
+	for _, selector := range selectors {
+		modules := []service.ModuleDescriptor{{Namespace: selector.Namespace, Name: selector.Name, System: selector.System}}
+		if selector.Name == "" {
+			var err error
+			if modules, err = e.namespaceModules(selector.Namespace); err != nil {
+				return Manifest{}, err
+			}
+		}
+		namespaces[selector.Namespace] = true
+		for _, modul := range modules {
+			versions, err := e.Modules.Versions(modul)
+			if err != nil {
+				return Manifest{}, err
+			}
+			if selector.Version != "" {
+				if !lo.ContainsBy(versions, func(v service.ModuleVersion) bool { return v.Version == selector.Version }) {
+					return Manifest{}, fmt.Errorf("module %s/%s/%s %s: %w", modul.Namespace, modul.Name, modul.System, selector.Version, service.ErrNotFound)
+				}
+				versions = []service.ModuleVersion{{Version: selector.Version}}
+			}
+			for _, version := range versions {
+				key := fmt.Sprintf("%s/%s/%s/%s", modul.Namespace, modul.Name, modul.System, version.Version)
+				if selected[key] {
+					continue
+				}
+				selected[key] = true
+				details, err := e.Modules.Get(modul, version.Version)
+				if err != nil {
+					return Manifest{}, err
+				}
+				manifest.Modules = append(manifest.Modules, Module{
+					Namespace:   modul.Namespace,
+					Name:        modul.Name,
+					System:      modul.System,
+					Version:     version.Version,
+					Owner:       details.Module.Owner,
+					Description: details.Module.Description,
+					PublishedAt: details.Module.PublishedAt,
+					File:        modulesDir + key + ".zip",
+				})
+			}
+		}
+	}
+	sort.SliceStable(manifest.Modules, func(i, j int) bool {
+		a, b := manifest.Modules[i], manifest.Modules[j]
+		if a.Descriptor() != b.Descriptor() {
+			return a.File < b.File
+		}
+		return service.CompareVersions(a.Version, b.Version) < 0
+	})
+
+	if store, ok := e.Modules.(service.NamespaceStore); ok {
+		for _, name := range lo.Keys(namespaces) {
+			settings, err := store.Namespace(name)
+			if errors.Is(err, service.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return Manifest{}, err
+			}
+			if settings.HasSettings() {
+				manifest.Namespaces = append(manifest.Namespaces, settings)
+			}
+		}
+		sort.Slice(manifest.Namespaces, func(i, j int) bool { return manifest.Namespaces[i].Name < manifest.Namespaces[j].Name })
+	}
+	return manifest, nil
+}
+
+func (e *Exporter) namespaceModules(namespace string) ([]service.ModuleDescriptor, error) {
+	modules := []service.ModuleDescriptor{}
+	for offset := 0; ; {
+		result, err := e.Modules.List(service.ListParams{Limit: service.MaxLimit, Offset: offset, Namespace: namespace})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range result.Modules {
+			modules = append(modules, service.ModuleDescriptor{Namespace: m.Namespace, Name: m.Name, System: m.Provider})
+		}
+		if len(result.Modules) == 0 || result.Meta.NextOffset <= offset {
+			break
+		}
+		offset = result.Meta.NextOffset
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("namespace %s has no modules: %w", namespace, service.ErrNotFound)
+	}
+	return lo.Uniq(modules), nil
+}
+
+// spoolArchive copies the archive of the version to the spool directory and records its checksum and size
+func (e *Exporter) spoolArchive(spool string, modul *Module) error {
+	archive, err := OpenArchive(e.Modules, e.Client, modul.Descriptor(), modul.Version)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	path := filepath.Join(spool, filepath.FromSlash(modul.File))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if modul.Size, err = io.Copy(io.MultiWriter(f, hash), archive); err != nil {
+		return fmt.Errorf("module %s: %w", modul, err)
+	}
+	modul.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return f.Close()
+}
+
+func copyToTar(tw *tar.Writer, path string, modul Module, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeTarHeader(tw, modul.File, modul.Size, modTime); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func writeTarHeader(tw *tar.Writer, name string, size int64, modTime time.Time) error {
+	return tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg})
+}
+
+// OpenArchive reads the archive of a version from an archive store or downloads it from the absolute url of the module service.
+// Catalogs are archive stores whose blob store may not serve archives, their archives are downloaded without counting the download
+func OpenArchive(modules service.ModuleService, client *http.Client, modul service.ModuleDescriptor, version string) (io.ReadCloser, error) {
+	locator, counted := modules.(service.ArchiveLocator)
+	if store, ok := modules.(service.ArchiveStore); ok {
+		archive, _, err := store.OpenArchive(modul, version)
+		if err == nil || !counted || !errors.Is(err, service.ErrNotFound) {
+			return archive, err
+		}
+	}
+	locate := modules.DownloadUrl
+	if counted {
+		locate = locator.ArchiveUrl
+	}
+	location, err := locate(modul, version)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(location); err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("module %s/%s/%s %s: archive url %q cannot be downloaded", modul.Namespace, modul.Name, modul.System, version, location)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("module %s/%s/%s %s: archive download answered %s: %w", modul.Namespace, modul.Name, modul.System, version, res.Status, service.ErrBackendUnavailable)
+	}
+	return res.Body, nil
+}
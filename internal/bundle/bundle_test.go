@@ -0,0 +1,267 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/catalog"
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	sqlitecatalog "github.com/mxab/tf-registry/internal/sqlite_catalog"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSigner(t *testing.T) *providerservice.Signer {
+	t.Helper()
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	signer, err := providerservice.NewSigner(key, "")
+	require.NoError(t, err)
+	return signer
+}
+
+func newModules(t *testing.T) *filesystemmoduleservice.FilesystemModuleService {
+	t.Helper()
+	modules, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	require.NoError(t, err)
+	return modules
+}
+
+var (
+	consul = service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	vpc    = service.ModuleDescriptor{Namespace: "hashicorp", Name: "vpc", System: "aws"}
+	dns    = service.ModuleDescriptor{Namespace: "acme", Name: "dns", System: "aws"}
+)
+
+// newSource has two versions of consul, vpc in the verified hashicorp namespace and two versions of acme/dns
+func newSource(t *testing.T) *filesystemmoduleservice.FilesystemModuleService {
+	source := newModules(t)
+	for _, published := range []struct {
+		modul   service.ModuleDescriptor
+		version string
+	}{{consul, "0.1.0"}, {consul, "0.2.0"}, {vpc, "1.0.0"}, {dns, "1.0.0"}, {dns, "2.0.0"}} {
+		upload(t, source, published.modul, published.version)
+	}
+	require.NoError(t, source.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+	return source
+}
+
+func upload(t *testing.T, modules service.ModuleService, modul service.ModuleDescriptor, version string) {
+	t.Helper()
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": `variable "version" { default = "` + version + `" }`})
+	require.NoError(t, modules.UploadModule(modul, version, bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+}
+
+func export(t *testing.T, source service.ModuleService, signer *providerservice.Signer, selections ...string) []byte {
+	t.Helper()
+	selectors := []Selector{}
+	for _, selection := range selections {
+		selector, err := ParseSelector(selection)
+		require.NoError(t, err)
+		selectors = append(selectors, selector)
+	}
+	buf := new(bytes.Buffer)
+	_, err := (&Exporter{Modules: source, Signer: signer}).Export(buf, selectors)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestExportAndImport(t *testing.T) {
+	signer := newSigner(t)
+	source := newSource(t)
+	data := export(t, source, signer, "hashicorp", "hashicorp/consul/aws", "acme/dns/aws/2.0.0")
+
+	destination := newModules(t)
+	importer := &Importer{Modules: destination, TrustedKeys: []providerservice.SigningKey{signer.Key()}}
+	result, err := importer.Import(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme/dns/aws 2.0.0", "hashicorp/consul/aws 0.1.0", "hashicorp/consul/aws 0.2.0", "hashicorp/vpc/aws 1.0.0"}, moduleNames(result.Imported))
+	assert.Empty(t, result.Skipped)
+	assert.Equal(t, []string{"hashicorp"}, result.Namespaces)
+
+	// the owner and publish date are kept
+	expected, err := source.Get(consul, "0.2.0")
+	require.NoError(t, err)
+	details, err := destination.Get(consul, "0.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", details.Module.Owner)
+	assert.Equal(t, expected.Module.PublishedAt, details.Module.PublishedAt)
+	assert.True(t, details.Module.Verified)
+	_, err = destination.Get(dns, "1.0.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	// importing the bundle again skips the existing versions
+	result, err = importer.Import(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Empty(t, result.Imported)
+	assert.Len(t, result.Skipped, 4)
+	assert.Empty(t, result.Namespaces)
+}
+
+// urlBlobStore serves the archives with urls like s3 does, it is no archive store
+type urlBlobStore struct {
+	*httptest.Server
+	archives map[string][]byte
+}
+
+func newURLBlobStore(t *testing.T) *urlBlobStore {
+	blobs := &urlBlobStore{archives: map[string][]byte{}}
+	blobs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := blobs.archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(blobs.Close)
+	return blobs
+}
+
+func (b *urlBlobStore) key(modul service.ModuleDescriptor, version string) string {
+	return "/" + modul.Namespace + "/" + modul.Name + "/" + modul.System + "/" + version + ".zip"
+}
+
+func (b *urlBlobStore) PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	if _, ok := b.archives[b.key(modul, version)]; ok && !overwrite {
+		return service.ErrAlreadyExists
+	}
+	b.archives[b.key(modul, version)] = data
+	return nil
+}
+
+func (b *urlBlobStore) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	if _, ok := b.archives[b.key(modul, version)]; !ok {
+		return "", service.ErrNotFound
+	}
+	return b.URL + b.key(modul, version), nil
+}
+
+func TestExportAndImportWithCatalogOverURLBlobStore(t *testing.T) {
+	db, err := sqlitecatalog.Open(context.Background(), filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	modules := catalog.NewCatalogModuleService(db, newURLBlobStore(t))
+	upload(t, modules, consul, "0.1.0")
+
+	signer := newSigner(t)
+	data := export(t, modules, signer, "hashicorp")
+	importer := &Importer{Modules: modules, TrustedKeys: []providerservice.SigningKey{signer.Key()}}
+	result, err := importer.Import(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws 0.1.0"}, moduleNames(result.Skipped))
+
+	// copying archives is no download
+	details, err := modules.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Zero(t, details.Module.Downloads)
+}
+
+func TestImportReportsConflicts(t *testing.T) {
+	signer := newSigner(t)
+	data := export(t, newSource(t), signer, "hashicorp/consul/aws")
+
+	destination := newModules(t)
+	require.NoError(t, destination.UploadModule(consul, "0.1.0", bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": "# other"})), service.UploadOptions{Publisher: "local"}))
+
+	result, err := (&Importer{Modules: destination, TrustedKeys: []providerservice.SigningKey{signer.Key()}}).Import(bytes.NewReader(data))
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+	assert.Equal(t, []string{"hashicorp/consul/aws 0.1.0"}, moduleNames(result.Conflicts))
+	assert.Equal(t, []string{"hashicorp/consul/aws 0.2.0"}, moduleNames(result.Imported))
+
+	details, err := destination.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "local", details.Module.Owner)
+}
+
+func TestImportRejectsUntrustedBundles(t *testing.T) {
+	data := export(t, newSource(t), newSigner(t), "acme")
+	destination := newModules(t)
+
+	_, err := (&Importer{Modules: destination, TrustedKeys: []providerservice.SigningKey{newSigner(t).Key()}}).Import(bytes.NewReader(data))
+	assert.ErrorContains(t, err, "signature is not made by a trusted key")
+	_, err = destination.Versions(dns)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestImportRejectsModifiedArchives(t *testing.T) {
+	signer := newSigner(t)
+	data := export(t, newSource(t), signer, "acme/dns/aws/1.0.0")
+
+	// replace the archive behind the signed manifest
+	modified := new(bytes.Buffer)
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gzr)
+	gzw := gzip.NewWriter(modified)
+	tw := tar.NewWriter(gzw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if header.Name == "modules/acme/dns/aws/1.0.0.zip" {
+			content = tft.ModuleZip(t, map[string]string{"main.tf": "# modified"})
+			header.Size = int64(len(content))
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	destination := newModules(t)
+	_, err = (&Importer{Modules: destination, TrustedKeys: []providerservice.SigningKey{signer.Key()}}).Import(modified)
+	assert.ErrorContains(t, err, "does not match the signed checksum")
+	_, err = destination.Versions(dns)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestExportRequiresSigner(t *testing.T) {
+	_, err := (&Exporter{Modules: newSource(t)}).Export(io.Discard, []Selector{{Namespace: "acme"}})
+	assert.ErrorContains(t, err, "gpg key is required")
+
+	_, err = (&Exporter{Modules: newSource(t), Signer: newSigner(t)}).Export(io.Discard, []Selector{{Namespace: "unknown"}})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestParseSelector(t *testing.T) {
+	table := []struct {
+		selection string
+		expected  Selector
+	}{
+		{"hashicorp", Selector{Namespace: "hashicorp"}},
+		{"hashicorp/consul/aws", Selector{Namespace: "hashicorp", Name: "consul", System: "aws"}},
+		{"hashicorp/consul/aws/v0.1.0", Selector{Namespace: "hashicorp", Name: "consul", System: "aws", Version: "0.1.0"}},
+	}
+	for _, test := range table {
+		selector, err := ParseSelector(test.selection)
+		require.NoError(t, err, test.selection)
+		assert.Equal(t, test.expected, selector)
+	}
+	for _, invalid := range []string{"", "hashicorp/consul", "hashicorp//aws", "hashicorp/consul/aws/latest", "a/b/c/1.0.0/d"} {
+		_, err := ParseSelector(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func moduleNames(modules []Module) []string {
+	names := []string{}
+	for _, m := range modules {
+		names = append(names, m.String())
+	}
+	return names
+}
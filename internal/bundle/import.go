@@ -0,0 +1,193 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/samber/lo"
+)
+
+// maxManifestSize limits the manifest and its signature
+const maxManifestSize = 64 << 20
+
+type (
+	// Importer loads bundles signed by one of the trusted keys into the module service
+	Importer struct {
+		Modules     service.ModuleService
+		TrustedKeys []providerservice.SigningKey
+		// Client downloads the archives of module services that are not an archive store to compare their checksums
+		Client *http.Client
+	}
+	// ImportResult lists what happened to the versions of a bundle
+	ImportResult struct {
+		Manifest Manifest
+		Imported []Module
+		// Skipped versions already exist with the checksum of the bundle
+		Skipped []Module
+		// Conflicts exist with another checksum and are left untouched
+		Conflicts []Module
+		// Namespaces whose settings were taken from the bundle, namespaces with settings keep them
+		Namespaces []string
+	}
+)
+
+// Import verifies the signature of the manifest before anything is loaded and the checksum of every archive before it is uploaded.
+// Versions that already exist are skipped if their checksum matches, otherwise they are reported as conflict
+func (i *Importer) Import(r io.Reader) (ImportResult, error) {
+	result := ImportResult{}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return result, fmt.Errorf("invalid bundle: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	manifestData, err := readEntry(tr, manifestName)
+	if err != nil {
+		return result, err
+	}
+	signature, err := readEntry(tr, signatureName)
+	if err != nil {
+		return result, err
+	}
+	if _, err := providerservice.CheckSignature(i.TrustedKeys, manifestData, signature); err != nil {
+		return result, fmt.Errorf("bundle manifest: %w", err)
+	}
+	if err := json.Unmarshal(manifestData, &result.Manifest); err != nil {
+		return result, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if result.Manifest.SchemaVersion != ManifestSchemaVersion {
+		return result, fmt.Errorf("bundle manifest schema version %d is not supported", result.Manifest.SchemaVersion)
+	}
+
+	if result.Namespaces, err = i.importNamespaces(result.Manifest.Namespaces); err != nil {
+		return result, err
+	}
+
+	pending := lo.KeyBy(result.Manifest.Modules, func(m Module) string { return m.File })
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("invalid bundle: %w", err)
+		}
+		modul, ok := pending[header.Name]
+		if !ok {
+			return result, fmt.Errorf("invalid bundle: %s is not in the manifest", header.Name)
+		}
+		delete(pending, header.Name)
+		data, err := io.ReadAll(io.LimitReader(tr, modul.Size+1))
+		if err != nil {
+			return result, fmt.Errorf("invalid bundle: %w", err)
+		}
+		if checksum(data) != modul.SHA256 || int64(len(data)) != modul.Size {
+			return result, fmt.Errorf("invalid bundle: archive of %s does not match the signed checksum", modul)
+		}
+		switch existing, err := i.existingChecksum(modul); {
+		case err != nil:
+			return result, err
+		case existing == modul.SHA256:
+			result.Skipped = append(result.Skipped, modul)
+		case existing != "":
+			result.Conflicts = append(result.Conflicts, modul)
+		default:
+			options := service.UploadOptions{Publisher: modul.Owner}
+			if publishedAt, err := time.Parse(time.RFC3339Nano, modul.PublishedAt); err == nil {
+				options.PublishedAt = publishedAt
+			}
+			if err := i.Modules.UploadModule(modul.Descriptor(), modul.Version, bytes.NewReader(data), options); err != nil {
+				return result, fmt.Errorf("module %s: %w", modul, err)
+			}
+			result.Imported = append(result.Imported, modul)
+		}
+	}
+	if len(pending) > 0 {
+		return result, fmt.Errorf("invalid bundle: %d archives of the manifest are missing", len(pending))
+	}
+	if len(result.Conflicts) > 0 {
+		return result, fmt.Errorf("%d versions exist with other checksums: %w", len(result.Conflicts), service.ErrAlreadyExists)
+	}
+	return result, nil
+}
+
+// importNamespaces puts the settings of namespaces that have none yet
+func (i *Importer) importNamespaces(namespaces []service.Namespace) ([]string, error) {
+	imported := []string{}
+	store, ok := i.Modules.(service.NamespaceStore)
+	if !ok {
+		return imported, nil
+	}
+	for _, namespace := range namespaces {
+		settings, err := store.Namespace(namespace.Name)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return imported, err
+		}
+		if settings.HasSettings() {
+			continue
+		}
+		if err := store.PutNamespace(namespace); err != nil {
+			return imported, err
+		}
+		imported = append(imported, namespace.Name)
+	}
+	return imported, nil
+}
+
+// existingChecksum is the checksum of the archive of the version in the module service, empty if it does not exist
+func (i *Importer) existingChecksum(modul Module) (string, error) {
+	versions, err := i.Modules.Versions(modul.Descriptor())
+	if errors.Is(err, service.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !lo.ContainsBy(versions, func(v service.ModuleVersion) bool { return v.Version == modul.Version }) {
+		return "", nil
+	}
+	archive, err := OpenArchive(i.Modules, i.Client, modul.Descriptor(), modul.Version)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, archive); err != nil {
+		return "", fmt.Errorf("module %s: %w", modul, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readEntry reads the next file of the bundle, which must have the name
+func readEntry(tr *tar.Reader, name string) ([]byte, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %s: %w", name, err)
+	}
+	if header.Name != name {
+		return nil, fmt.Errorf("invalid bundle: expected %s, found %s", name, header.Name)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %s: %w", name, err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("invalid bundle: %s exceeds %d bytes", name, maxManifestSize)
+	}
+	return data, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+package catalog
+
+import (
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// Catalog holds the metadata of all published module versions in a queryable form,
+// so listing and searching does not have to scan the blob store
+type Catalog interface {
+	List(params service.ListParams) (service.ModuleResult, error)
+	Search(params service.SearchParams) (service.ModuleResult, error)
+	Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error)
+	Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error)
+	// Metadata returns the full metadata document of a version
+	Metadata(modul service.ModuleDescriptor, version string) (service.ModuleMetadata, error)
+	// Publish adds a version, it fails with service.ErrAlreadyExists if the version exists and replace is not set
+	Publish(metadata service.ModuleMetadata, replace bool) error
+	// Namespaces, Namespace and PutNamespace manage the settings of namespaces, see service.NamespaceStore
+	Namespaces() ([]service.Namespace, error)
+	Namespace(name string) (service.Namespace, error)
+	PutNamespace(namespace service.Namespace) error
+	// RecordDownload counts a download of a version, it fails with service.ErrNotFound for unknown versions
+	RecordDownload(modul service.ModuleDescriptor, version string) error
+}
+
+// BlobStore holds the module archives
+type BlobStore interface {
+	// PutArchive stores the archive of a version, unless overwrite is set only if the version has no archive yet
+	PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error
+	DownloadUrl(modul service.ModuleDescriptor, version string) (string, error)
+}
@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/archive"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+var (
+	_ service.ModuleService  = (*CatalogModuleService)(nil)
+	_ service.ArchiveStore   = (*CatalogModuleService)(nil)
+	_ service.NamespaceStore = (*CatalogModuleService)(nil)
+	_ service.ArchiveLocator = (*CatalogModuleService)(nil)
+)
+
+// CatalogModuleService answers all queries from the catalog and keeps only the archives in the blob store
+type CatalogModuleService struct {
+	catalog Catalog
+	blobs   BlobStore
+}
+
+func NewCatalogModuleService(catalog Catalog, blobs BlobStore) *CatalogModuleService {
+	return &CatalogModuleService{catalog: catalog, blobs: blobs}
+}
+
+func (s *CatalogModuleService) List(params service.ListParams) (service.ModuleResult, error) {
+	return s.catalog.List(params)
+}
+
+func (s *CatalogModuleService) Search(params service.SearchParams) (service.ModuleResult, error) {
+	return s.catalog.Search(params)
+}
+
+func (s *CatalogModuleService) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	return s.catalog.Versions(modul)
+}
+
+func (s *CatalogModuleService) Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+	return s.catalog.Get(modul, version)
+}
+
+func (s *CatalogModuleService) Namespaces() ([]service.Namespace, error) {
+	return s.catalog.Namespaces()
+}
+
+func (s *CatalogModuleService) Namespace(name string) (service.Namespace, error) {
+	return s.catalog.Namespace(name)
+}
+
+func (s *CatalogModuleService) PutNamespace(namespace service.Namespace) error {
+	if err := service.ValidateNamespace(namespace.Name); err != nil {
+		return err
+	}
+	return s.catalog.PutNamespace(namespace)
+}
+
+// DownloadUrl only hands out archives of versions known to the catalog and counts the download
+func (s *CatalogModuleService) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	url, err := s.blobs.DownloadUrl(modul, version)
+	if err != nil {
+		return "", err
+	}
+	if err := s.catalog.RecordDownload(modul, version); err != nil {
+		return "", err
+	}
+	return url, nil
+}
+
+// ArchiveUrl is where the blob store keeps the archive, for tools copying archives without counting downloads
+func (s *CatalogModuleService) ArchiveUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	return s.blobs.DownloadUrl(modul, version)
+}
+
+func (s *CatalogModuleService) OpenArchive(modul service.ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error) {
+	store, ok := s.blobs.(service.ArchiveStore)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("module %s/%s/%s %s: archive is not served by the registry: %w", modul.Namespace, modul.Name, modul.System, version, service.ErrNotFound)
+	}
+	return store.OpenArchive(modul, version)
+}
+
+// UploadModule stores the archive before publishing it in the catalog so the catalog never points to a missing archive.
+// The archive is only overwritten on forced uploads, an archive left behind by a failed publish needs a forced upload
+func (s *CatalogModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader, options service.UploadOptions) error {
+	version, err := service.NormalizeVersion(version)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	inspection, err := archive.Inspect(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+	}
+	metadata := inspection.Metadata(modul, version, options.Publisher, time.Now().UTC())
+	if !options.PublishedAt.IsZero() {
+		metadata.PublishedAt = options.PublishedAt.UTC()
+	}
+
+	previous, err := s.catalog.Metadata(modul, version)
+	switch {
+	case err == nil && !options.Force:
+		return fmt.Errorf("module %s/%s/%s %s: %w", modul.Namespace, modul.Name, modul.System, version, service.ErrAlreadyExists)
+	case err == nil:
+		metadata.Replaces(previous, options.Publisher)
+	case !errors.Is(err, service.ErrNotFound):
+		return err
+	}
+
+	if err := s.blobs.PutArchive(modul, version, data, options.Force); err != nil {
+		return err
+	}
+	return s.catalog.Publish(metadata, options.Force)
+}
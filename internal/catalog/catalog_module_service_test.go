@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryCatalog keeps published versions in memory, queries are not needed by these tests
+type memoryCatalog struct {
+	Catalog
+	versions  map[string]service.ModuleMetadata
+	downloads map[string]int
+}
+
+func newMemoryCatalog() *memoryCatalog {
+	return &memoryCatalog{versions: map[string]service.ModuleMetadata{}, downloads: map[string]int{}}
+}
+
+func key(modul service.ModuleDescriptor, version string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", modul.Namespace, modul.Name, modul.System, version)
+}
+
+func (c *memoryCatalog) Metadata(modul service.ModuleDescriptor, version string) (service.ModuleMetadata, error) {
+	metadata, ok := c.versions[key(modul, version)]
+	if !ok {
+		return metadata, service.ErrNotFound
+	}
+	return metadata, nil
+}
+
+func (c *memoryCatalog) Publish(metadata service.ModuleMetadata, replace bool) error {
+	k := key(metadata.Descriptor(), metadata.Version)
+	if _, ok := c.versions[k]; ok && !replace {
+		return service.ErrAlreadyExists
+	}
+	c.versions[k] = metadata
+	return nil
+}
+
+func (c *memoryCatalog) RecordDownload(modul service.ModuleDescriptor, version string) error {
+	if _, ok := c.versions[key(modul, version)]; !ok {
+		return service.ErrNotFound
+	}
+	c.downloads[key(modul, version)]++
+	return nil
+}
+
+type memoryBlobStore struct {
+	archives map[string][]byte
+}
+
+func (b *memoryBlobStore) PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	if _, ok := b.archives[key(modul, version)]; ok && !overwrite {
+		return service.ErrAlreadyExists
+	}
+	b.archives[key(modul, version)] = data
+	return nil
+}
+
+func (b *memoryBlobStore) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	if _, ok := b.archives[key(modul, version)]; !ok {
+		return "", service.ErrNotFound
+	}
+	return "https://blobs.example.com/" + key(modul, version), nil
+}
+
+func TestUploadPublishesToCatalogAndBlobStore(t *testing.T) {
+	catalog := newMemoryCatalog()
+	blobs := &memoryBlobStore{archives: map[string][]byte{}}
+	s := NewCatalogModuleService(catalog, blobs)
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	first := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	assert.NoError(t, s.UploadModule(consul, "v1.0.0", bytes.NewReader(first), service.UploadOptions{Publisher: "alice"}))
+	assert.Equal(t, first, blobs.archives["hashicorp/consul/aws/1.0.0"])
+	assert.Equal(t, "alice", catalog.versions["hashicorp/consul/aws/1.0.0"].Owner)
+
+	second := tft.ModuleZip(t, map[string]string{"main.tf": "", "outputs.tf": ""})
+	err := s.UploadModule(consul, "1.0.0", bytes.NewReader(second), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+	assert.Equal(t, first, blobs.archives["hashicorp/consul/aws/1.0.0"])
+
+	assert.NoError(t, s.UploadModule(consul, "1.0.0", bytes.NewReader(second), service.UploadOptions{Force: true, Publisher: "admin"}))
+	assert.Equal(t, second, blobs.archives["hashicorp/consul/aws/1.0.0"])
+	replaced := catalog.versions["hashicorp/consul/aws/1.0.0"]
+	assert.Equal(t, "alice", replaced.Owner)
+	if assert.Len(t, replaced.Overwrites, 1) {
+		assert.Equal(t, "admin", replaced.Overwrites[0].By)
+	}
+
+	err = s.UploadModule(consul, "1.1.0", bytes.NewReader([]byte("not a zip")), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrInvalidArchive)
+	assert.NotContains(t, blobs.archives, "hashicorp/consul/aws/1.1.0")
+}
+
+func TestDownloadUrlCountsDownloadsOfCatalogVersions(t *testing.T) {
+	catalog := newMemoryCatalog()
+	blobs := &memoryBlobStore{archives: map[string][]byte{}}
+	s := NewCatalogModuleService(catalog, blobs)
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	assert.NoError(t, s.UploadModule(consul, "1.0.0", bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{}))
+
+	url, err := s.DownloadUrl(consul, "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://blobs.example.com/hashicorp/consul/aws/1.0.0", url)
+	assert.Equal(t, 1, catalog.downloads["hashicorp/consul/aws/1.0.0"])
+
+	// archives the catalog does not know are not handed out
+	blobs.archives["hashicorp/consul/aws/2.0.0"] = tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	_, err = s.DownloadUrl(consul, "2.0.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	_, _, err = s.OpenArchive(consul, "1.0.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mxab/tf-registry/internal/bundle"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/spf13/cobra"
+)
+
+type bundleConfig struct {
+	serverConfig
+	Output string
+}
+
+func newBundleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move modules to air-gapped registries with signed bundles",
+	}
+	cmd.AddCommand(newBundleExportCommand(), newBundleImportCommand())
+	return cmd
+}
+
+func newBundleExportCommand() *cobra.Command {
+	cfg := bundleConfig{}
+	cmd := &cobra.Command{
+		Use:   "export <namespace|namespace/name/system|namespace/name/system/version>...",
+		Short: "Write the selected module versions with their checksums to a tarball signed with the gpg key",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selectors := []bundle.Selector{}
+			for _, arg := range args {
+				selector, err := bundle.ParseSelector(arg)
+				if err != nil {
+					return err
+				}
+				selectors = append(selectors, selector)
+			}
+			if cfg.GPGKey == "" {
+				return errors.New("bundles are signed, set the gpg key with --gpg-key")
+			}
+			signer, err := providerservice.LoadSigner(cfg.GPGKey, cfg.GPGPassphrase)
+			if err != nil {
+				return err
+			}
+			modules, err := openModules(cmd.Context(), cfg.serverConfig)
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(cfg.Output)
+			if err != nil {
+				return err
+			}
+			manifest, err := (&bundle.Exporter{Modules: modules, Signer: signer}).Export(f, selectors)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(cfg.Output)
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "exported %d versions to %s, signed with key %s\n", len(manifest.Modules), cfg.Output, signer.Key().KeyID)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	addStorageFlags(flags, &cfg.serverConfig)
+	flags.StringVarP(&cfg.Output, "output", "o", "bundle.tar.gz", "file the bundle is written to")
+	flags.StringVar(&cfg.GPGKey, "gpg-key", envOrDefault("TFR_GPG_KEY", ""), "ascii armored private gpg key signing the bundle [TFR_GPG_KEY]")
+	flags.StringVar(&cfg.GPGPassphrase, "gpg-passphrase", envOrDefault("TFR_GPG_PASSPHRASE", ""), "passphrase of the gpg key [TFR_GPG_PASSPHRASE]")
+	return cmd
+}
+
+func newBundleImportCommand() *cobra.Command {
+	cfg := bundleConfig{}
+	cmd := &cobra.Command{
+		Use:   "import <bundle>",
+		Short: "Load a bundle signed by a trusted key, versions that exist with the same checksum are skipped",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.TrustedKeys == "" {
+				return errors.New("the signature of the bundle is verified, set the public keys with --trusted-keys")
+			}
+			keys, err := providerservice.LoadSigningKeys(cfg.TrustedKeys)
+			if err != nil {
+				return err
+			}
+			modules, err := openModules(cmd.Context(), cfg.serverConfig)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			result, err := (&bundle.Importer{Modules: modules, TrustedKeys: keys}).Import(f)
+			out := cmd.OutOrStdout()
+			for _, namespace := range result.Namespaces {
+				fmt.Fprintf(out, "namespace %s: settings imported\n", namespace)
+			}
+			for _, modul := range result.Imported {
+				fmt.Fprintf(out, "%s: imported\n", modul)
+			}
+			for _, modul := range result.Skipped {
+				fmt.Fprintf(out, "%s: skipped, exists with the same checksum\n", modul)
+			}
+			for _, modul := range result.Conflicts {
+				fmt.Fprintf(out, "%s: conflict, exists with another checksum\n", modul)
+			}
+			fmt.Fprintf(out, "%d imported, %d skipped, %d conflicts\n", len(result.Imported), len(result.Skipped), len(result.Conflicts))
+			return err
+		},
+	}
+	flags := cmd.Flags()
+	addStorageFlags(flags, &cfg.serverConfig)
+	flags.StringVar(&cfg.TrustedKeys, "trusted-keys", envOrDefault("TFR_TRUSTED_KEYS", ""), "ascii armored public gpg keys that may sign bundles [TFR_TRUSTED_KEYS]")
+	return cmd
+}
+
+// openModules opens the storage and catalog of the configuration, like the server does
+func openModules(ctx context.Context, cfg serverConfig) (service.ModuleService, error) {
+	storage, err := newStorage(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newModuleService(ctx, cfg, storage)
+}
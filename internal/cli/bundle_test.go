@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleExportAndImport(t *testing.T) {
+	dir := t.TempDir()
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	signer, err := providerservice.NewSigner(key, "")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.asc"), key, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trusted.asc"), []byte(signer.Key().ASCIIArmor), 0o644))
+
+	source, err := filesystemmoduleservice.NewFilesystemModuleService(filepath.Join(dir, "source"))
+	require.NoError(t, err)
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	require.NoError(t, source.UploadModule(consul, "0.1.0", bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	run := func(args ...string) string {
+		out := new(bytes.Buffer)
+		root := NewRootCommand()
+		root.SetOut(out)
+		root.SetArgs(args)
+		require.NoError(t, root.Execute(), out.String())
+		return out.String()
+	}
+	output := run("bundle", "export", "hashicorp", "--storage", "filesystem", "--data-dir", filepath.Join(dir, "source"), "--gpg-key", filepath.Join(dir, "key.asc"), "--output", bundle)
+	assert.Contains(t, output, "exported 1 versions")
+
+	importArgs := []string{"bundle", "import", bundle, "--storage", "filesystem", "--data-dir", filepath.Join(dir, "destination"), "--trusted-keys", filepath.Join(dir, "trusted.asc")}
+	assert.Contains(t, run(importArgs...), "hashicorp/consul/aws 0.1.0: imported")
+	assert.Contains(t, run(importArgs...), "0 imported, 1 skipped, 0 conflicts")
+
+	destination, err := filesystemmoduleservice.NewFilesystemModuleService(filepath.Join(dir, "destination"))
+	require.NoError(t, err)
+	details, err := destination.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", details.Module.Owner)
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/mxab/tf-registry/internal/migrate"
+	"github.com/spf13/cobra"
+)
+
+type migrateConfig struct {
+	From  string
+	To    string
+	State string
+}
+
+func newMigrateCommand() *cobra.Command {
+	cfg := migrateConfig{}
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy the namespaces and modules of a backend to another one and verify their checksums, running it again resumes the migration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := parseBackendConfig(cfg.From)
+			if err != nil {
+				return fmt.Errorf("--from: %w", err)
+			}
+			to, err := parseBackendConfig(cfg.To)
+			if err != nil {
+				return fmt.Errorf("--to: %w", err)
+			}
+			migrator := &migrate.Migrator{}
+			if migrator.From, err = openModules(cmd.Context(), from); err != nil {
+				return err
+			}
+			if migrator.To, err = openModules(cmd.Context(), to); err != nil {
+				return err
+			}
+			if cfg.State != "" {
+				if migrator.State, err = migrate.OpenState(cfg.State); err != nil {
+					return err
+				}
+				defer migrator.State.Close()
+			}
+			out := cmd.OutOrStdout()
+			migrator.Progress = func(v migrate.Version) {
+				if v.Err != nil {
+					fmt.Fprintf(out, "%s: %s: %v\n", v, v.Outcome, v.Err)
+					return
+				}
+				fmt.Fprintf(out, "%s: %s\n", v, v.Outcome)
+			}
+			summary, err := migrator.Migrate()
+			fmt.Fprintf(out, "%d namespaces updated, %d versions copied, %d skipped, %d conflicts, %d failed\n",
+				summary.Namespaces, summary.Copied, summary.Skipped, len(summary.Conflicts), len(summary.Failed))
+			if summary.ProvidersSkipped {
+				fmt.Fprintln(out, "provider releases were not migrated")
+			}
+			return err
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.From, "from", "", "backend the modules are copied from, file:///<directory> or s3://<bucket>?region=<region>&endpoint=<url>, the parameters catalog, database_url and catalog_path add a catalog")
+	flags.StringVar(&cfg.To, "to", "", "backend the modules are copied to, in the format of --from")
+	flags.StringVar(&cfg.State, "state", "", "file recording the migrated versions, later runs skip them without comparing checksums")
+	for _, name := range []string{"from", "to"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+	return cmd
+}
+
+// parseBackendConfig reads the storage and catalog of a backend url like file:///data or s3://bucket?region=eu-west-1
+func parseBackendConfig(backend string) (serverConfig, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return serverConfig{}, err
+	}
+	query := u.Query()
+	cfg := serverConfig{
+		Region:      "us-east-1",
+		Catalog:     query.Get("catalog"),
+		DatabaseUrl: query.Get("database_url"),
+		CatalogPath: query.Get("catalog_path"),
+	}
+	switch u.Scheme {
+	case "file":
+		cfg.Storage = "filesystem"
+		// file://data is the relative directory data
+		cfg.DataDir = u.Host + u.Path
+		if cfg.DataDir == "" {
+			return serverConfig{}, errors.New("file backend without directory")
+		}
+	case "s3":
+		cfg.Storage = "s3"
+		cfg.Bucket = u.Host
+		if cfg.Bucket == "" {
+			return serverConfig{}, errors.New("s3 backend without bucket")
+		}
+		if region := query.Get("region"); region != "" {
+			cfg.Region = region
+		}
+		cfg.Endpoint = query.Get("endpoint")
+	default:
+		return serverConfig{}, fmt.Errorf("unknown backend %q, use file:///<directory> or s3://<bucket>", backend)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackendConfig(t *testing.T) {
+	table := []struct {
+		backend  string
+		expected serverConfig
+	}{
+		{"file:///var/lib/tfr", serverConfig{Storage: "filesystem", DataDir: "/var/lib/tfr", Region: "us-east-1"}},
+		{"file://data", serverConfig{Storage: "filesystem", DataDir: "data", Region: "us-east-1"}},
+		{"file:///data?catalog=sqlite&catalog_path=/data/catalog.db", serverConfig{Storage: "filesystem", DataDir: "/data", Region: "us-east-1", Catalog: "sqlite", CatalogPath: "/data/catalog.db"}},
+		{"s3://tf-registry", serverConfig{Storage: "s3", Bucket: "tf-registry", Region: "us-east-1"}},
+		{"s3://modules?region=eu-west-1&endpoint=http://localhost:9000", serverConfig{Storage: "s3", Bucket: "modules", Region: "eu-west-1", Endpoint: "http://localhost:9000"}},
+	}
+	for _, test := range table {
+		cfg, err := parseBackendConfig(test.backend)
+		require.NoError(t, err, test.backend)
+		assert.Equal(t, test.expected, cfg, test.backend)
+	}
+	for _, invalid := range []string{"data", "gcs://bucket", "s3://", "file://"} {
+		_, err := parseBackendConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	dir := t.TempDir()
+	source, err := filesystemmoduleservice.NewFilesystemModuleService(filepath.Join(dir, "source"))
+	require.NoError(t, err)
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	require.NoError(t, source.UploadModule(consul, "0.1.0", bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+
+	run := func() string {
+		out := new(bytes.Buffer)
+		root := NewRootCommand()
+		root.SetOut(out)
+		root.SetArgs([]string{"migrate", "--from", "file://" + filepath.Join(dir, "source"), "--to", "file://" + filepath.Join(dir, "destination"), "--state", filepath.Join(dir, "state")})
+		require.NoError(t, root.Execute(), out.String())
+		return out.String()
+	}
+	output := run()
+	assert.Contains(t, output, "hashicorp/consul/aws 0.1.0: copied")
+	assert.Contains(t, output, "0 namespaces updated, 1 versions copied, 0 skipped, 0 conflicts, 0 failed")
+	assert.Contains(t, run(), "0 versions copied, 1 skipped")
+}
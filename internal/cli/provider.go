@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/mxab/tf-registry/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+type providerUploadConfig struct {
+	Host      string
+	Token     string
+	Namespace string
+	Type      string
+	Version   string
+	Protocols []string
+	Platforms []string
+}
+
+func newProviderCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "provider",
+		Short: "Publish providers to the registry",
+	}
+	cmd.AddCommand(newProviderUploadCommand())
+	return cmd
+}
+
+func newProviderUploadCommand() *cobra.Command {
+	cfg := providerUploadConfig{}
+	cmd := &cobra.Command{
+		Use:   "upload [dist-dir]",
+		Short: "Upload the provider zips goreleaser built, the registry signs the checksums unless the release is signed",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "dist"
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			token := cfg.Token
+			if token == "" {
+				var err error
+				if token, err = upload.Token(cfg.Host); err != nil {
+					return err
+				}
+			}
+			if err := upload.UploadProviderDir(dir, cfg.Host, token, cfg.Namespace, cfg.Type, cfg.Version, cfg.Protocols, cfg.Platforms); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "uploaded %s/%s %s\n", cfg.Namespace, cfg.Type, cfg.Version)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.Host, "host", envOrDefault("TFR_HOST", "http://localhost:1323"), "url of the registry [TFR_HOST]")
+	flags.StringVar(&cfg.Token, "token", envOrDefault("TFR_TOKEN", ""), "token with the publish scope, defaults to the TF_TOKEN_<host> variable or terraform login credentials of the registry host [TFR_TOKEN]")
+	flags.StringVar(&cfg.Namespace, "namespace", "", "namespace of the provider")
+	flags.StringVar(&cfg.Type, "type", "", "type of the provider, e.g. aws for terraform-provider-aws")
+	flags.StringVar(&cfg.Version, "version", "", "version of the provider")
+	flags.StringSliceVar(&cfg.Protocols, "protocols", nil, "plugin protocol versions of the provider, e.g. 5.0, defaults to the protocol versions of the manifest")
+	flags.StringSliceVar(&cfg.Platforms, "platforms", nil, "os_arch platforms the release must contain, e.g. linux_amd64")
+	for _, name := range []string{"namespace", "type", "version"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+	return cmd
+}
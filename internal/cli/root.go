@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// NewRootCommand builds the tfr command with all its subcommands
+func NewRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "tfr",
+		Short:         "A private terraform module registry",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+	}
+	root.AddCommand(
+		newBundleCommand(),
+		newMigrateCommand(),
+		newProviderCommand(),
+		newServerCommand(),
+		newTokenCommand(),
+		newUploadCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+// envOrDefault returns the value of the environment variable key or fallback if it is not set
+func envOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// envBoolOrDefault returns the boolean value of the environment variable key or fallback if it is not set or no boolean
+func envBoolOrDefault(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// envListOrDefault returns the comma separated values of the environment variable key or fallback if it is not set
+func envListOrDefault(key string, fallback []string) []string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return strings.Split(value, ",")
+	}
+	return fallback
+}
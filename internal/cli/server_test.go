@@ -0,0 +1,740 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kinbiko/jsonassert"
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerhandler "github.com/mxab/tf-registry/internal/provider/handler"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/mxab/tf-registry/internal/proxy"
+	"github.com/mxab/tf-registry/internal/search"
+	"github.com/mxab/tf-registry/internal/upload"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerDiscovery(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newServer(serverConfig{BaseUrl: "https://registry.example.com/", AnonymousRead: true}, backends{modules: tft.NewMockModuleService()})
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/terraform.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"modules.v1": "https://registry.example.com/v1/modules/"}`)
+}
+
+func TestServerDiscoversLogin(t *testing.T) {
+	ja := jsonassert.New(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), []byte(`{"users": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "login.json"), []byte(`{"users_file": "users.json", "ports": [10000, 10005]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{BaseUrl: "https://registry.example.com/", LoginConfig: filepath.Join(dir, "login.json"), LoginSecret: "secret"}
+	loginController, err := newLogin(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(cfg, backends{modules: tft.NewMockModuleService(), login: loginController})
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/terraform.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{
+		"modules.v1": "https://registry.example.com/v1/modules/",
+		"login.v1": {
+			"client": "terraform-cli",
+			"grant_types": ["authz_code"],
+			"authz": "https://registry.example.com/oauth/authorization",
+			"token": "https://registry.example.com/oauth/token",
+			"ports": [10000, 10005]
+		}
+	}`)
+
+	req = httptest.NewRequest(http.MethodGet, "/oauth/authorization?client_id=terraform-cli&redirect_uri=http://localhost:10001/login&response_type=code&code_challenge=abc&code_challenge_method=S256", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<form")
+}
+
+func TestServerRoutesModules(t *testing.T) {
+	e := newServer(serverConfig{AnonymousRead: true}, backends{modules: tft.NewMockModuleService()})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/Azure/network/azurerm/1.1.1/download", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://github.com/Azure/terraform-azurerm-network", rec.Header().Get("X-Terraform-Get"))
+}
+
+func TestServerRendersRegistryErrors(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newServer(serverConfig{AnonymousRead: true}, backends{modules: tft.NewMockModuleService()})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/Azure/network/azurerm/9.9.9/download", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["module Azure/network/azurerm 9.9.9: not found"]}`)
+}
+
+func TestServerRoutesModuleVersions(t *testing.T) {
+	e := newServer(serverConfig{AnonymousRead: true}, backends{modules: tft.NewMockModuleService()})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/Azure/network/azurerm/versions", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"version":"1.1.1"`)
+}
+
+func TestServerRoutesLatestModule(t *testing.T) {
+	e := newServer(serverConfig{AnonymousRead: true}, backends{modules: tft.NewMockModuleService()})
+
+	for path, code := range map[string]int{
+		"/v1/modules/Azure/network":                  http.StatusOK,
+		"/v1/modules/Azure/network/azurerm":          http.StatusOK,
+		"/v1/modules/Azure/network/azurerm/download": http.StatusFound,
+		"/v1/modules/Azure/network/azurerm/versions": http.StatusOK,
+		"/v1/modules/Azure/network/azurerm/1.1.1":    http.StatusOK,
+		"/v1/modules/Azure/network/azurerm/9.9.9":    http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, code, rec.Code, path)
+	}
+}
+
+func TestServerWithFilesystemStorage(t *testing.T) {
+	b, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewStaticTokens([]auth.TokenEntry{
+		{Subject: "ci", SHA256: auth.HashToken("publisher"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{}, backends{modules: b.modules, authenticator: tokens})
+
+	data := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/modules/hashicorp/consul/aws/1.0.0/upload", bytes.NewReader(data))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer publisher")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	downloadUrl, _ := url.Parse("http://registry.example.com/v1/modules/hashicorp/consul/aws/1.0.0/download")
+	req = httptest.NewRequest(http.MethodGet, downloadUrl.String(), nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer publisher")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// terraform resolves a relative X-Terraform-Get against the download url
+	archiveUrl, err := downloadUrl.Parse(rec.Header().Get("X-Terraform-Get"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, archiveUrl.String(), nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer publisher")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/zip", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, data, rec.Body.Bytes())
+}
+
+func TestServerRejectsUnknownStorage(t *testing.T) {
+	_, err := newBackends(context.Background(), serverConfig{Storage: "ftp"})
+	assert.EqualError(t, err, `unknown storage "ftp", use s3 or filesystem`)
+}
+
+func TestServerWithSQLiteCatalog(t *testing.T) {
+	b, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", Catalog: "sqlite", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{AdminToken: "secret", AnonymousRead: true}, backends{modules: b.modules})
+
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": "", "README.md": "# Consul\n\nDeploys a Consul cluster on EC2 instances\n"})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/modules/hashicorp/consul/aws/1.0.0/upload", bytes.NewReader(archive))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/modules/search?q=instances", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"hashicorp/consul/aws/1.0.0"`)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/modules/hashicorp/consul/aws/1.0.0/download", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/modules/hashicorp/consul/aws", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"downloads":1`)
+}
+
+func TestServerSearchesCatalogsWithoutIndex(t *testing.T) {
+	b, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", Catalog: "sqlite", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Same(t, b.modules, withSearchIndex(serverConfig{Catalog: "sqlite"}, b.modules))
+
+	b, err = newBackends(context.Background(), serverConfig{Storage: "filesystem", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &search.IndexedModuleService{}, withSearchIndex(serverConfig{Storage: "filesystem"}, b.modules))
+}
+
+func TestServerRejectsUnknownCatalog(t *testing.T) {
+	_, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", Catalog: "mongo", DataDir: t.TempDir()})
+	assert.EqualError(t, err, `unknown catalog "mongo", use postgres or sqlite`)
+}
+
+func TestServerFiltersVerifiedNamespaces(t *testing.T) {
+	b, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{AdminToken: "secret", AnonymousRead: true}, backends{modules: search.NewIndexedModuleService(b.modules)})
+
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	for _, path := range []string{"/v1/modules/hashicorp/consul/aws/1.0.0/upload", "/v1/modules/someone/consul/aws/1.0.0/upload"} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(archive))
+		req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/v1/admin/namespaces/hashicorp", strings.NewReader(`{"verified": true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	for _, path := range []string{"/v1/modules?verified=true", "/v1/modules/search?q=consul&verified=true"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"id":"hashicorp/consul/aws/1.0.0"`, path)
+		assert.Contains(t, rec.Body.String(), `"verified":true`, path)
+		assert.NotContains(t, rec.Body.String(), `someone`, path)
+	}
+}
+
+func TestServerAuthenticatesTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	tokensFile := fmt.Sprintf(`{"tokens": [
+		{"subject": "reader", "sha256": %q, "scopes": ["read"]},
+		{"subject": "ci", "sha256": %q, "scopes": ["publish"]}
+	]}`, auth.HashToken("reader-token"), auth.HashToken("ci-token"))
+	if err := os.WriteFile(path, []byte(tokensFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := newAuthenticator(serverConfig{TokensFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{AdminToken: "admin-token"}, backends{modules: tft.NewMockModuleService(), authenticator: authenticator})
+
+	table := []struct {
+		method       string
+		path         string
+		token        string
+		expectedCode int
+	}{
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", expectedCode: http.StatusUnauthorized},
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", token: "wrong", expectedCode: http.StatusUnauthorized},
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", token: "reader-token", expectedCode: http.StatusOK},
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", token: "ci-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", token: "admin-token", expectedCode: http.StatusOK},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", expectedCode: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", token: "reader-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", token: "ci-token", expectedCode: http.StatusNoContent},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/1.1.1/upload?force=true", token: "ci-token", expectedCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/1.1.1/upload?force=true", token: "admin-token", expectedCode: http.StatusNoContent},
+		{method: http.MethodGet, path: "/.well-known/terraform.json", expectedCode: http.StatusOK},
+	}
+	for _, test := range table {
+		var body io.Reader
+		if test.method == http.MethodPost {
+			body = strings.NewReader("archive")
+		}
+		req := httptest.NewRequest(test.method, test.path, body)
+		if test.token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, test.expectedCode, rec.Code, test.method+" "+test.path+" "+test.token)
+		if rec.Code == http.StatusUnauthorized {
+			assert.Equal(t, `Bearer realm="tf-registry"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+		}
+	}
+}
+
+func TestServerRejectsInvalidTokensFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	if err := os.WriteFile(path, []byte(`{"tokens": [{"subject": "ci", "sha256": "abc", "scopes": ["publish"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newAuthenticator(serverConfig{TokensFile: path})
+	assert.EqualError(t, err, `failed to load tokens: token of "ci": sha256 must be a hex encoded sha256 hash`)
+}
+
+func TestServerAuthorizesNamespaces(t *testing.T) {
+	b, err := newBackends(context.Background(), serverConfig{Storage: "filesystem", DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewStaticTokens([]auth.TokenEntry{
+		{Subject: "alice", SHA256: auth.HashToken("alice"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}},
+		{Subject: "eve", SHA256: auth.HashToken("eve"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{AdminToken: "secret", AnonymousRead: true}, backends{modules: search.NewIndexedModuleService(b.modules), authenticator: tokens})
+
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPut, "/v1/admin/namespaces/acme/bindings", "secret", `{"bindings": [{"role": "owner", "member": "user:alice"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(http.MethodPatch, "/v1/admin/namespaces/acme", "alice", `{"private": true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	for _, path := range []string{"/v1/modules/acme/network/aws/1.0.0/upload", "/v1/modules/hashicorp/consul/aws/1.0.0/upload"} {
+		rec = serve(http.MethodPost, path, "alice", string(archive))
+		assert.Equal(t, http.StatusNoContent, rec.Code, path)
+	}
+	rec = serve(http.MethodPost, "/v1/modules/acme/network/aws/2.0.0/upload", "eve", string(archive))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the owner of a module version is its publisher
+	rec = serve(http.MethodGet, "/v1/modules/acme/network/aws", "alice", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"owner":"alice"`)
+
+	for _, token := range []string{"", "eve"} {
+		rec = serve(http.MethodGet, "/v1/modules/acme/network/aws", token, "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		for _, path := range []string{"/v1/modules", "/v1/modules/search?q=aws"} {
+			rec = serve(http.MethodGet, path, token, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"id":"hashicorp/consul/aws/1.0.0"`, path)
+			assert.NotContains(t, rec.Body.String(), "acme", path)
+		}
+	}
+	rec = serve(http.MethodGet, "/v1/modules/search?q=aws", "alice", "")
+	assert.Contains(t, rec.Body.String(), `"id":"acme/network/aws/1.0.0"`)
+}
+
+func TestServerAcceptsOIDCTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	jwks := fmt.Sprintf(`{"keys": [{"kid": "ci", "kty": "RSA", "n": %q, "e": "AQAB"}]}`, base64.RawURLEncoding.EncodeToString(key.N.Bytes()))
+	oidcConfig := `{
+		"issuers": [{"issuer": "https://token.actions.githubusercontent.com", "audience": "tf-registry", "jwks_file": "jwks.json"}],
+		"rules": [{"issuer": "https://token.actions.githubusercontent.com", "claims": {"repository": "Azure/*", "ref": "refs/heads/main"}, "namespaces": ["{repository_owner}"]}]
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "jwks.json"), []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oidc.json"), []byte(oidcConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := newAuthenticator(serverConfig{OIDCConfig: filepath.Join(dir, "oidc.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(serverConfig{}, backends{modules: tft.NewMockModuleService(), authenticator: authenticator})
+
+	sign := func(ref string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":              "https://token.actions.githubusercontent.com",
+			"aud":              "tf-registry",
+			"sub":              "repo:Azure/terraform-azurerm-network:ref:" + ref,
+			"repository":       "Azure/terraform-azurerm-network",
+			"repository_owner": "Azure",
+			"ref":              ref,
+			"exp":              time.Now().Add(5 * time.Minute).Unix(),
+		})
+		token.Header["kid"] = "ci"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	main, feature := sign("refs/heads/main"), sign("refs/heads/feature")
+
+	table := []struct {
+		method       string
+		path         string
+		token        string
+		expectedCode int
+	}{
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", token: main, expectedCode: http.StatusNoContent},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", token: feature, expectedCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/v1/modules/hashicorp/consul/aws/1.0.0/upload", token: main, expectedCode: http.StatusForbidden},
+		{method: http.MethodPost, path: "/v1/modules/Azure/network/azurerm/2.0.0/upload", token: main + "x", expectedCode: http.StatusUnauthorized},
+		{method: http.MethodGet, path: "/v1/modules/Azure/network/azurerm/versions", token: main, expectedCode: http.StatusForbidden},
+	}
+	for _, test := range table {
+		var body io.Reader
+		if test.method == http.MethodPost {
+			body = strings.NewReader("archive")
+		}
+		req := httptest.NewRequest(test.method, test.path, body)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, test.expectedCode, rec.Code, test.method+" "+test.path)
+	}
+}
+
+func TestServerServesProviders(t *testing.T) {
+	ja := jsonassert.New(t)
+	cfg := serverConfig{Storage: "filesystem", DataDir: t.TempDir(), BaseUrl: "https://registry.example.com", AnonymousRead: true}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.0.0", "linux_amd64")
+	if err := b.providers.PutProviderRelease(release, files); err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(cfg, b)
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/terraform.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	ja.Assertf(rec.Body.String(), `{
+		"modules.v1": "https://registry.example.com/v1/modules/",
+		"providers.v1": "https://registry.example.com/v1/providers/"
+	}`)
+
+	for _, path := range []string{
+		"/v1/providers/acme/cloud/versions",
+		"/v1/providers/acme/cloud/1.0.0/download/linux/amd64",
+		"/v1/providers/acme/cloud/1.0.0/files/terraform-provider-cloud_1.0.0_linux_amd64.zip",
+	} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+}
+
+func TestServerPublishesProviders(t *testing.T) {
+	key, err := tft.NewGPGKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.asc")
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := serverConfig{Storage: "filesystem", DataDir: t.TempDir(), AdminToken: "admin-token", GPGKey: keyFile, AnonymousRead: true}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := httptest.NewServer(newServer(cfg, b))
+	defer svr.Close()
+
+	dist := t.TempDir()
+	for name, data := range tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64", "darwin_arm64") {
+		if err := os.WriteFile(filepath.Join(dist, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = upload.UploadProviderDir(dist, svr.URL, "", "acme", "cloud", "v1.0.0", nil, []string{"linux_amd64", "darwin_arm64"})
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	err = upload.UploadProviderDir(dist, svr.URL, "admin-token", "acme", "cloud", "v1.0.0", nil, []string{"linux_amd64", "darwin_arm64"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// terraform checks the signature of the checksums with the key of the download response
+	endpoint, _ := url.Parse(svr.URL + "/v1/providers/acme/cloud/1.0.0/download/darwin/arm64")
+	res, err := http.Get(endpoint.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	download := providerhandler.ProviderDownload{}
+	if err := json.NewDecoder(res.Body).Decode(&download); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"5.0"}, download.Protocols)
+	fetch := func(ref string) []byte {
+		u, _ := url.Parse(ref)
+		res, err := http.Get(endpoint.ResolveReference(u).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, ref)
+		data, _ := io.ReadAll(res.Body)
+		return data
+	}
+	shasums := fetch(download.SHASumsURL)
+	assert.Contains(t, string(shasums), download.SHASum+"  "+download.Filename)
+	keys := []providerservice.SigningKey{{KeyID: download.SigningKeys.GPGPublicKeys[0].KeyID, ASCIIArmor: download.SigningKeys.GPGPublicKeys[0].ASCIIArmor}}
+	_, err = providerservice.CheckSignature(keys, shasums, fetch(download.SHASumsSignatureURL))
+	assert.NoError(t, err)
+}
+
+func TestServerServesProviderMirror(t *testing.T) {
+	ja := jsonassert.New(t)
+	cfg := serverConfig{Storage: "filesystem", DataDir: t.TempDir(), BaseUrl: "https://registry.example.com/", MirrorPath: "/mirror/", AnonymousRead: false, AdminToken: "admin-token"}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.0.0", "linux_amd64")
+	if err := b.providers.PutProviderRelease(release, files); err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(cfg, b)
+
+	req := httptest.NewRequest(http.MethodGet, "/mirror/registry.example.com/acme/cloud/index.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	for path, expected := range map[string]string{
+		"/mirror/registry.example.com/acme/cloud/index.json": `{"versions": {"1.0.0": {}}}`,
+		"/mirror/registry.example.com/acme/cloud/1.0.0.json": `{"archives": {"linux_amd64": {"url": "1.0.0/terraform-provider-cloud_1.0.0_linux_amd64.zip", "hashes": ["<<PRESENCE>>"]}}}`,
+	} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin-token")
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		ja.Assertf(rec.Body.String(), expected)
+	}
+}
+
+func TestServerProxiesUpstreamModules(t *testing.T) {
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"modules.v1": "/v1/modules/"}`))
+	})
+	mux.HandleFunc("/v1/modules/hashicorp/consul/aws/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"modules": [{"versions": [{"version": "0.1.0"}]}]}`))
+	})
+	mux.HandleFunc("/v1/modules/hashicorp/consul/aws/0.1.0/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Terraform-Get", "/archives/consul.zip")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/archives/consul.zip", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+
+	cfg := serverConfig{Storage: "filesystem", DataDir: t.TempDir(), Upstream: upstream.URL, AnonymousRead: true}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.modules.(service.NamespaceStore).PutNamespace(service.Namespace{Name: "hashicorp", Proxied: true}); err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(cfg, b)
+
+	for path, expectedCode := range map[string]int{
+		"/v1/modules/hashicorp/consul/aws/versions":          http.StatusOK,
+		"/v1/modules/hashicorp/consul/aws/0.1.0/download":    http.StatusNoContent,
+		"/v1/modules/hashicorp/consul/aws/0.1.0/archive.zip": http.StatusOK,
+		"/v1/modules/hashicorp/consul/aws/0.2.0/download":    http.StatusNotFound,
+		"/v1/modules/hashicorp/unknown/aws/versions":         http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, expectedCode, rec.Code, path)
+	}
+
+	// the cached module is served by the local storage
+	_, err = b.modules.(*proxy.ProxyModuleService).ModuleService.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}, "0.1.0")
+	assert.NoError(t, err)
+}
+
+func TestServerProxiesUpstreamProviders(t *testing.T) {
+	ja := jsonassert.New(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"providers.v1": "/v1/providers/"}`))
+	})
+	mux.HandleFunc("/v1/providers/hashicorp/random/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"versions": [{"version": "3.4.0", "protocols": ["5.0"], "platforms": [{"os": "linux", "arch": "amd64"}]}]}`))
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+
+	cfg := serverConfig{Storage: "filesystem", DataDir: t.TempDir(), BaseUrl: "https://registry.example.com", MirrorPath: "/v1/mirror", Upstream: upstream.URL, AnonymousRead: true}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.modules.(service.NamespaceStore).PutNamespace(service.Namespace{Name: "hashicorp", Proxied: true}); err != nil {
+		t.Fatal(err)
+	}
+	e := newServer(cfg, b)
+
+	// the mirror serves the providers of the upstream hostname besides the ones of the registry
+	for path, expected := range map[string]string{
+		"/v1/providers/hashicorp/random/versions":                                          `{"versions": [{"version": "3.4.0", "protocols": ["5.0"], "platforms": [{"os": "linux", "arch": "amd64"}]}]}`,
+		"/v1/mirror/" + upstream.Listener.Addr().String() + "/hashicorp/random/index.json": `{"versions": {"3.4.0": {}}}`,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		ja.Assertf(rec.Body.String(), expected)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/mirror/other.example.com/hashicorp/random/index.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServerProxiesProviderNamespacesOfTheCatalog(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"providers.v1": "/v1/providers/"}`))
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+	key, err := tft.NewGPGKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.asc")
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := serverConfig{Storage: "filesystem", Catalog: "sqlite", DataDir: t.TempDir(), AdminToken: "admin-token", GPGKey: keyFile, Upstream: upstream.URL, AnonymousRead: true}
+	b, err := newBackends(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := httptest.NewServer(newServer(cfg, b))
+	defer svr.Close()
+	dist := t.TempDir()
+	for name, data := range tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64") {
+		if err := os.WriteFile(filepath.Join(dist, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the settings are kept in the catalog, not in the storage of the providers
+	setProxied := func(proxied bool) {
+		req, _ := http.NewRequest(http.MethodPatch, svr.URL+"/v1/admin/namespaces/acme", strings.NewReader(fmt.Sprintf(`{"proxied": %t}`, proxied)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin-token")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	setProxied(true)
+	err = upload.UploadProviderDir(dist, svr.URL, "admin-token", "acme", "cloud", "v1.0.0", nil, []string{"linux_amd64"})
+	assert.ErrorContains(t, err, "403")
+
+	setProxied(false)
+	err = upload.UploadProviderDir(dist, svr.URL, "admin-token", "acme", "cloud", "v1.0.0", nil, []string{"linux_amd64"})
+	assert.NoError(t, err)
+	res, err := http.Get(svr.URL + "/v1/providers/acme/cloud/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), `"version":"1.0.0"`)
+}
+
+func TestServerLimitsUploads(t *testing.T) {
+	e := newServer(serverConfig{MaxUploadSize: "1K"}, backends{modules: tft.NewMockModuleService()})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/modules/hashicorp/consul/aws/1.0.0/upload", bytes.NewReader(make([]byte, 2048)))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	err := runServer(context.Background(), serverConfig{MaxUploadSize: "a lot"})
+	assert.ErrorContains(t, err, "--max-upload-size")
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+type tokenConfig struct {
+	Subject string
+	Scopes  []string
+	Groups  []string
+}
+
+func newTokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage the bearer tokens of the registry",
+	}
+	cmd.AddCommand(newTokenCreateCommand())
+	return cmd
+}
+
+func newTokenCreateCommand() *cobra.Command {
+	cfg := tokenConfig{}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Generate a token and print it with the entry to add to the tokens file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scopes := make([]auth.Scope, 0, len(cfg.Scopes))
+			for _, scope := range cfg.Scopes {
+				scopes = append(scopes, auth.Scope(scope))
+			}
+			token, err := auth.GenerateToken()
+			if err != nil {
+				return err
+			}
+			entry := auth.TokenEntry{Subject: cfg.Subject, SHA256: auth.HashToken(token), Scopes: scopes, Groups: cfg.Groups}
+			// validates the subject and scopes the way the server will
+			if _, err := auth.NewStaticTokens([]auth.TokenEntry{entry}); err != nil {
+				return err
+			}
+			data, err := json.MarshalIndent(entry, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "token: %s\n\nadd to the tokens of the tokens file:\n%s\n", token, data)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.Subject, "subject", "", "who uses the token, e.g. a user or pipeline")
+	flags.StringSliceVar(&cfg.Scopes, "scope", []string{string(auth.ScopeRead)}, "scopes of the token, read or publish")
+	flags.StringSliceVar(&cfg.Groups, "group", nil, "groups of the subject, namespaces grant roles to users and groups")
+	_ = cmd.MarkFlagRequired("subject")
+	return cmd
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/mxab/tf-registry/internal/upload"
+	"github.com/spf13/cobra"
+)
+
+type uploadConfig struct {
+	Host      string
+	Token     string
+	Namespace string
+	Name      string
+	System    string
+	Version   string
+}
+
+func newUploadCommand() *cobra.Command {
+	cfg := uploadConfig{}
+	cmd := &cobra.Command{
+		Use:   "upload [dir]",
+		Short: "Package a module directory and upload it to the registry",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			token := cfg.Token
+			if token == "" {
+				// the token terraform itself would use for the registry
+				var err error
+				if token, err = upload.Token(cfg.Host); err != nil {
+					return err
+				}
+			}
+			if err := upload.UploadDir(dir, cfg.Host, token, cfg.Namespace, cfg.Name, cfg.System, cfg.Version); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "uploaded %s/%s/%s %s\n", cfg.Namespace, cfg.Name, cfg.System, cfg.Version)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.Host, "host", envOrDefault("TFR_HOST", "http://localhost:1323"), "url of the registry [TFR_HOST]")
+	flags.StringVar(&cfg.Token, "token", envOrDefault("TFR_TOKEN", ""), "token with the publish scope, defaults to the TF_TOKEN_<host> variable or terraform login credentials of the registry host [TFR_TOKEN]")
+	flags.StringVar(&cfg.Namespace, "namespace", "", "namespace of the module")
+	flags.StringVar(&cfg.Name, "name", "", "name of the module")
+	flags.StringVar(&cfg.System, "system", "", "target system of the module, e.g. aws")
+	flags.StringVar(&cfg.Version, "version", "", "version of the module")
+	for _, name := range []string{"namespace", "name", "system", "version"} {
+		_ = cmd.MarkFlagRequired(name)
+	}
+	return cmd
+}
@@ -11,7 +11,17 @@ type (
 		discovery DiscoveryResponse
 	}
 	DiscoveryResponse struct {
-		ModulesV1 string `json:"modules.v1"`
+		ModulesV1   string   `json:"modules.v1"`
+		ProvidersV1 string   `json:"providers.v1,omitempty"`
+		LoginV1     *LoginV1 `json:"login.v1,omitempty"`
+	}
+	// LoginV1 tells terraform login how to obtain a token
+	LoginV1 struct {
+		Client     string   `json:"client"`
+		GrantTypes []string `json:"grant_types"`
+		Authz      string   `json:"authz"`
+		Token      string   `json:"token"`
+		Ports      [2]int   `json:"ports"`
 	}
 )
 
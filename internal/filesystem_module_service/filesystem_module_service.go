@@ -0,0 +1,431 @@
+package filesystemmoduleservice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/archive"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+const (
+	modulesDir   = "modules/namespaces"
+	archiveName  = "module.zip"
+	metadataName = "metadata.json"
+	lockName     = ".lock"
+
+	// archiveDownloadUrl is where terraform fetches the archive from, relative to the download endpoint of the version
+	archiveDownloadUrl = "./archive.zip"
+)
+
+var (
+	_ service.ModuleService = (*FilesystemModuleService)(nil)
+	_ service.ArchiveStore  = (*FilesystemModuleService)(nil)
+)
+
+// FilesystemModuleService stores archives and metadata documents below a local directory using the same layout as the s3 bucket,
+// the registry serves the archives itself
+type FilesystemModuleService struct {
+	root string
+}
+
+// versionFiles are the files found for one version of a module
+type versionFiles struct {
+	archive     fs.FileInfo
+	hasMetadata bool
+}
+
+func NewFilesystemModuleService(root string) (*FilesystemModuleService, error) {
+	if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(modulesDir)), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create module directory: %w", err)
+	}
+	return &FilesystemModuleService{root: root}, nil
+}
+
+// validatePath makes sure the parts of a module address cannot escape the module directory
+func validatePath(parts ...string) error {
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return fmt.Errorf("%w: %q", service.ErrInvalidModule, part)
+		}
+	}
+	return nil
+}
+
+func (s *FilesystemModuleService) moduleDir(modul service.ModuleDescriptor) string {
+	return filepath.Join(s.root, filepath.FromSlash(modulesDir), modul.Namespace, modul.Name, modul.System)
+}
+
+func (s *FilesystemModuleService) filePath(modul service.ModuleDescriptor, version, file string) string {
+	return filepath.Join(s.moduleDir(modul), version, file)
+}
+
+// parsePath is the reverse of filePath for paths relative to the module directory,
+// it returns false for files that are not an archive or metadata document
+func parsePath(rel string) (service.ModuleDescriptor, string, string, bool) {
+	parts := strings.Split(rel, "/")
+	if len(parts) != 5 || (parts[4] != archiveName && parts[4] != metadataName) {
+		return service.ModuleDescriptor{}, "", "", false
+	}
+	return service.ModuleDescriptor{
+		Namespace: parts[0],
+		Name:      parts[1],
+		System:    parts[2],
+	}, parts[3], parts[4], true
+}
+
+func (s *FilesystemModuleService) List(req service.ListParams) (service.ModuleResult, error) {
+	modules, err := s.latestModules(req.Namespace)
+	if err != nil {
+		return service.ModuleResult{}, err
+	}
+	return service.Paginate(service.FilterModules(modules, req), req.Limit, req.Offset), nil
+}
+
+func (s *FilesystemModuleService) Search(req service.SearchParams) (service.ModuleResult, error) {
+	modules, err := s.latestModules(req.Namespace)
+	if err != nil {
+		return service.ModuleResult{}, err
+	}
+	return service.Paginate(service.SearchModules(modules, req), req.Limit, req.Offset), nil
+}
+
+// scan collects the archives and metadata documents below dir grouped by module and version
+func (s *FilesystemModuleService) scan(dir string) (map[service.ModuleDescriptor]map[string]*versionFiles, error) {
+	found := map[service.ModuleDescriptor]map[string]*versionFiles{}
+	base := filepath.Join(s.root, filepath.FromSlash(modulesDir))
+	err := filepath.WalkDir(filepath.Join(base, dir), func(name string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(base, name)
+		if err != nil {
+			return err
+		}
+		descriptor, version, file, ok := parsePath(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		if _, ok := found[descriptor]; !ok {
+			found[descriptor] = map[string]*versionFiles{}
+		}
+		if _, ok := found[descriptor][version]; !ok {
+			found[descriptor][version] = &versionFiles{}
+		}
+		if file == archiveName {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			found[descriptor][version].archive = info
+		} else {
+			found[descriptor][version].hasMetadata = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan modules: %w", err)
+	}
+	return found, nil
+}
+
+// publishedVersions returns the versions that have an archive, sorted ascending by semver precedence
+func publishedVersions(versions map[string]*versionFiles) []string {
+	published := lo.Filter(lo.Keys(versions), func(version string, _ int) bool {
+		return versions[version].archive != nil
+	})
+	service.SortVersions(published)
+	return published
+}
+
+// loadMetadata reads the metadata document of a version, archives copied into the directory without one
+// get their metadata derived from the archive file
+func (s *FilesystemModuleService) loadMetadata(descriptor service.ModuleDescriptor, version string, files *versionFiles) (service.ModuleMetadata, error) {
+	if !files.hasMetadata {
+		modTime := files.archive.ModTime().UTC()
+		return service.ModuleMetadata{
+			SchemaVersion: service.MetadataSchemaVersion,
+			Namespace:     descriptor.Namespace,
+			Name:          descriptor.Name,
+			System:        descriptor.System,
+			Version:       version,
+			Size:          files.archive.Size(),
+			PublishedAt:   modTime,
+			UpdatedAt:     modTime,
+		}, nil
+	}
+	return s.getMetadata(descriptor, version)
+}
+
+func (s *FilesystemModuleService) getMetadata(descriptor service.ModuleDescriptor, version string) (service.ModuleMetadata, error) {
+	f, err := os.Open(s.filePath(descriptor, version, metadataName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return service.ModuleMetadata{}, fmt.Errorf("%s: %w", versionSubject(descriptor, version), service.ErrNotFound)
+	}
+	if err != nil {
+		return service.ModuleMetadata{}, err
+	}
+	defer f.Close()
+	return service.DecodeMetadata(f)
+}
+
+// latestModules lists every module, optionally limited to one namespace, with its latest version
+func (s *FilesystemModuleService) latestModules(namespace string) ([]service.Module, error) {
+	// like an unknown namespace a namespace that is not a valid directory name has no modules
+	if namespace != "" && validatePath(namespace) != nil {
+		return []service.Module{}, nil
+	}
+	found, err := s.scan(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.namespaceSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := []service.Module{}
+	for descriptor, versions := range found {
+		latest, ok := service.LatestVersion(publishedVersions(versions))
+		if !ok {
+			continue
+		}
+		metadata, err := s.loadMetadata(descriptor, latest, versions[latest])
+		if err != nil {
+			return nil, err
+		}
+		module := metadata.Module()
+		module.Verified = settings[descriptor.Namespace].Verified
+		modules = append(modules, module)
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Id < modules[j].Id
+	})
+	return modules, nil
+}
+
+// moduleVersions returns the metadata of all published versions of a module
+func (s *FilesystemModuleService) moduleVersions(modul service.ModuleDescriptor) ([]service.ModuleMetadata, error) {
+	if err := validatePath(modul.Namespace, modul.Name, modul.System); err != nil {
+		return nil, err
+	}
+	found, err := s.scan(path.Join(modul.Namespace, modul.Name, modul.System))
+	if err != nil {
+		return nil, err
+	}
+	versions := found[modul]
+	published := publishedVersions(versions)
+	if len(published) == 0 {
+		return nil, fmt.Errorf("%s: %w", moduleSubject(modul), service.ErrNotFound)
+	}
+
+	result := []service.ModuleMetadata{}
+	for _, version := range published {
+		metadata, err := s.loadMetadata(modul, version, versions[version])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, metadata)
+	}
+	return result, nil
+}
+
+func (s *FilesystemModuleService) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	versions, err := s.moduleVersions(modul)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(versions, func(m service.ModuleMetadata, _ int) service.ModuleVersion {
+		return m.ModuleVersion()
+	}), nil
+}
+
+func (s *FilesystemModuleService) Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+	versions, err := s.moduleVersions(modul)
+	if err != nil {
+		return service.ModuleDetails{}, err
+	}
+	metadata, found := lo.Find(versions, func(m service.ModuleMetadata) bool {
+		return m.Version == version
+	})
+	if !found {
+		return service.ModuleDetails{}, fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrNotFound)
+	}
+	namespace, err := s.getNamespace(modul.Namespace)
+	if err != nil && !errors.Is(err, service.ErrNotFound) {
+		return service.ModuleDetails{}, err
+	}
+	details := metadata.ModuleDetails()
+	details.Module.Verified = namespace.Verified
+	return details, nil
+}
+
+// DownloadUrl points terraform to the archive endpoint next to the download endpoint, the registry serves it with OpenArchive
+func (s *FilesystemModuleService) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	if err := validatePath(modul.Namespace, modul.Name, modul.System, version); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(s.filePath(modul, version, archiveName)); err != nil {
+		return "", fileError(err, modul, version)
+	}
+	return archiveDownloadUrl, nil
+}
+
+func (s *FilesystemModuleService) OpenArchive(modul service.ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error) {
+	if err := validatePath(modul.Namespace, modul.Name, modul.System, version); err != nil {
+		return nil, time.Time{}, err
+	}
+	f, err := os.Open(s.filePath(modul, version, archiveName))
+	if err != nil {
+		return nil, time.Time{}, fileError(err, modul, version)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// UploadModule writes the archive first so a metadata document always points to an existing archive.
+// Uploads of the same module are serialized with a lock file, published versions are immutable unless the upload is forced
+func (s *FilesystemModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader, options service.UploadOptions) error {
+	version, err := service.NormalizeVersion(version)
+	if err != nil {
+		return err
+	}
+	if err := validatePath(modul.Namespace, modul.Name, modul.System, version); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	inspection, err := archive.Inspect(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+	}
+	metadata := inspection.Metadata(modul, version, options.Publisher, time.Now().UTC())
+	if !options.PublishedAt.IsZero() {
+		metadata.PublishedAt = options.PublishedAt.UTC()
+	}
+
+	unlock, err := s.lock(modul)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.writeArchive(modul, version, data, options.Force); err != nil {
+		return err
+	}
+	// the metadata document is written after the archive, until then it is the one of the replaced archive
+	if options.Force {
+		previous, err := s.getMetadata(modul, version)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			metadata.Replaces(previous, options.Publisher)
+		}
+	}
+	encoded, err := service.EncodeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filePath(modul, version, metadataName), encoded)
+}
+
+// PutArchive stores the archive of a version, unless overwrite is set only if the version has no archive yet
+func (s *FilesystemModuleService) PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	if err := validatePath(modul.Namespace, modul.Name, modul.System, version); err != nil {
+		return err
+	}
+	unlock, err := s.lock(modul)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.writeArchive(modul, version, data, overwrite)
+}
+
+// lock serializes writes to a module, also across processes sharing the directory
+func (s *FilesystemModuleService) lock(modul service.ModuleDescriptor) (func(), error) {
+	if err := os.MkdirAll(s.moduleDir(modul), 0o755); err != nil {
+		return nil, err
+	}
+	return lock(filepath.Join(s.moduleDir(modul), lockName))
+}
+
+// writeArchive must be called with the module locked
+func (s *FilesystemModuleService) writeArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	_, err := os.Stat(s.filePath(modul, version, archiveName))
+	switch {
+	case err == nil && !overwrite:
+		return fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrAlreadyExists)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.moduleDir(modul), version), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filePath(modul, version, archiveName), data)
+}
+
+// writeFileAtomic writes to a temporary file next to name and renames it, readers either see the old or the new content
+func writeFileAtomic(name string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// fileError reports missing files as a missing version
+func fileError(err error, modul service.ModuleDescriptor, version string) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrNotFound)
+	}
+	return err
+}
+
+func moduleSubject(module service.ModuleDescriptor) string {
+	return fmt.Sprintf("module %s/%s/%s", module.Namespace, module.Name, module.System)
+}
+
+func versionSubject(module service.ModuleDescriptor, version string) string {
+	return fmt.Sprintf("%s %s", moduleSubject(module), version)
+}
@@ -0,0 +1,248 @@
+package filesystemmoduleservice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func newService(t *testing.T) *FilesystemModuleService {
+	t.Helper()
+	s, err := NewFilesystemModuleService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func upload(t *testing.T, s *FilesystemModuleService, namespace, name, system, version string) {
+	t.Helper()
+	err := s.UploadModule(service.ModuleDescriptor{Namespace: namespace, Name: name, System: system}, version,
+		bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	s := newService(t)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"}
+
+	data := tft.ModuleZip(t, map[string]string{
+		"main.tf":                "resource \"aws_s3_bucket\" \"this\" {}",
+		"README.md":              "# AWS\n\nCreates things on AWS.\n",
+		"modules/policy/main.tf": "",
+		"examples/basic/main.tf": "module \"aws\" {\n  source = \"../..\"\n}",
+	})
+	err := s.UploadModule(descriptor, "v3.0.0", bytes.NewReader(data), service.UploadOptions{Publisher: "alice"})
+	assert.NoError(t, err)
+
+	stored, err := os.ReadFile(filepath.Join(s.root, "modules", "namespaces", "hashicorp", "aws", "aws", "3.0.0", "module.zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, stored)
+
+	metadata, err := s.getMetadata(descriptor, "3.0.0")
+	assert.NoError(t, err)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), metadata.SHA256)
+	assert.Equal(t, "alice", metadata.Owner)
+	assert.Equal(t, "Creates things on AWS.", metadata.Description)
+	assert.Len(t, metadata.Submodules, 1)
+	assert.Len(t, metadata.Examples, 1)
+
+	details, err := s.Get(descriptor, "3.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "hashicorp/aws/aws/3.0.0", details.Module.Id)
+	assert.Equal(t, metadata.Root, details.Root)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(s.root, "modules", "namespaces", "hashicorp", "aws", "aws", "3.0.0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"metadata.json", "module.zip"}, lo.Map(entries, func(e os.DirEntry, _ int) string { return e.Name() }))
+}
+
+func TestUploadRejectsInvalidArchive(t *testing.T) {
+	s := newService(t)
+
+	err := s.UploadModule(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"}, "1.0.0", bytes.NewReader([]byte("not a zip")), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrInvalidArchive)
+}
+
+func TestRejectsPathsOutsideOfTheModuleDirectory(t *testing.T) {
+	s := newService(t)
+
+	err := s.UploadModule(service.ModuleDescriptor{Namespace: "..", Name: "aws", System: "aws"}, "1.0.0",
+		bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrInvalidModule)
+
+	_, err = s.DownloadUrl(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: `..\..`}, "1.0.0")
+	assert.ErrorIs(t, err, service.ErrInvalidModule)
+
+	_, _, err = s.OpenArchive(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"}, "..")
+	assert.ErrorIs(t, err, service.ErrInvalidModule)
+}
+
+func TestList(t *testing.T) {
+	s := newService(t)
+
+	upload(t, s, "hashicorp", "consul", "aws", "0.1.0")
+	upload(t, s, "hashicorp", "consul", "aws", "0.2.0")
+	upload(t, s, "hashicorp", "consul", "azurerm", "0.1.0")
+	upload(t, s, "Azure", "network", "azurerm", "1.1.1")
+
+	result, err := s.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Azure/network/azurerm/1.1.1",
+		"hashicorp/consul/aws/0.2.0",
+		"hashicorp/consul/azurerm/0.1.0",
+	}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+	assert.Equal(t, service.ModuleResultMeta{Limit: 10, CurrentOffset: 0, NextOffset: 3, PrevOffset: 0}, result.Meta)
+
+	result, err = s.List(service.ListParams{Limit: 10, Namespace: "hashicorp", Provider: "azurerm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/azurerm/0.1.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s.List(service.ListParams{Limit: 10, Namespace: "unknown"})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+}
+
+func TestSearch(t *testing.T) {
+	s := newService(t)
+
+	upload(t, s, "hashicorp", "consul", "aws", "0.1.0")
+	upload(t, s, "Azure", "network", "azurerm", "1.1.1")
+	upload(t, s, "zoitech", "network", "aws", "0.0.3")
+
+	result, err := s.Search(service.SearchParams{Query: "network", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Azure/network/azurerm/1.1.1", "zoitech/network/aws/0.0.3"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s.Search(service.SearchParams{Query: "network", Provider: "aws", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zoitech/network/aws/0.0.3"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+}
+
+func TestVersionsAreSortedBySemver(t *testing.T) {
+	s := newService(t)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	for _, version := range []string{"1.10.0", "v1.2.0", "1.9.0", "2.0.0-beta.1"} {
+		upload(t, s, descriptor.Namespace, descriptor.Name, descriptor.System, version)
+	}
+
+	versions, err := s.Versions(descriptor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.9.0", "1.10.0", "2.0.0-beta.1"}, lo.Map(versions, func(v service.ModuleVersion, _ int) string { return v.Version }))
+
+	_, err = s.Versions(service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestUploadPublishedVersionIsImmutable(t *testing.T) {
+	s := newService(t)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	first := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	err := s.UploadModule(descriptor, "1.2.0", bytes.NewReader(first), service.UploadOptions{Publisher: "alice"})
+	assert.NoError(t, err)
+
+	second := tft.ModuleZip(t, map[string]string{"main.tf": "", "outputs.tf": ""})
+	err = s.UploadModule(descriptor, "1.2.0", bytes.NewReader(second), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+
+	original, err := s.getMetadata(descriptor, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(first)
+	assert.Equal(t, hex.EncodeToString(sum[:]), original.SHA256)
+
+	err = s.UploadModule(descriptor, "1.2.0", bytes.NewReader(second), service.UploadOptions{Force: true, Publisher: "admin"})
+	assert.NoError(t, err)
+
+	replaced, err := s.getMetadata(descriptor, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256(second)
+	assert.Equal(t, hex.EncodeToString(sum[:]), replaced.SHA256)
+	assert.Equal(t, "alice", replaced.Owner)
+	assert.True(t, original.PublishedAt.Equal(replaced.PublishedAt))
+	if assert.Len(t, replaced.Overwrites, 1) {
+		assert.Equal(t, "admin", replaced.Overwrites[0].By)
+		assert.Equal(t, original.SHA256, replaced.Overwrites[0].PreviousSHA256)
+	}
+}
+
+func TestConcurrentUploadsPublishOnce(t *testing.T) {
+	s := newService(t)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	errs := make([]error, 8)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.UploadModule(descriptor, "1.0.0", bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, lo.CountBy(errs, func(err error) bool { return err == nil }))
+	assert.Equal(t, len(errs)-1, lo.CountBy(errs, func(err error) bool { return errors.Is(err, service.ErrAlreadyExists) }))
+}
+
+func TestDownload(t *testing.T) {
+	s := newService(t)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	data := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	assert.NoError(t, s.UploadModule(descriptor, "1.0.0", bytes.NewReader(data), service.UploadOptions{}))
+
+	url, err := s.DownloadUrl(descriptor, "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "./archive.zip", url)
+
+	archive, modTime, err := s.OpenArchive(descriptor, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	content, err := io.ReadAll(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+	assert.False(t, modTime.IsZero())
+
+	_, err = s.DownloadUrl(descriptor, "9.9.9")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	_, _, err = s.OpenArchive(descriptor, "9.9.9")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestArchivesWithoutMetadata(t *testing.T) {
+	s := newService(t)
+	dir := filepath.Join(s.root, "modules", "namespaces", "hashicorp", "consul", "aws", "0.1.0")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	if err := os.WriteFile(filepath.Join(dir, "module.zip"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.1.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+}
@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || windows)
+
+package filesystemmoduleservice
+
+import "sync"
+
+// without file locks uploads are only serialized within this process
+var uploadMutex sync.Mutex
+
+func lock(name string) (func(), error) {
+	uploadMutex.Lock()
+	return uploadMutex.Unlock, nil
+}
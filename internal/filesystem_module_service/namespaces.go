@@ -0,0 +1,112 @@
+package filesystemmoduleservice
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+const (
+	namespacesDir = "namespaces"
+	settingsExt   = ".json"
+)
+
+var _ service.NamespaceStore = (*FilesystemModuleService)(nil)
+
+func (s *FilesystemModuleService) namespacePath(name string) string {
+	return filepath.Join(s.root, namespacesDir, name+settingsExt)
+}
+
+// Namespaces lists the namespace directories of the modules together with the namespaces that have settings
+func (s *FilesystemModuleService) Namespaces() ([]service.Namespace, error) {
+	settings, err := s.namespaceSettings()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(s.root, filepath.FromSlash(modulesDir)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if _, ok := settings[entry.Name()]; entry.IsDir() && !ok {
+			settings[entry.Name()] = service.Namespace{Name: entry.Name()}
+		}
+	}
+	namespaces := lo.Values(settings)
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+func (s *FilesystemModuleService) Namespace(name string) (service.Namespace, error) {
+	if err := validatePath(name); err != nil {
+		return service.Namespace{}, err
+	}
+	namespace, err := s.getNamespace(name)
+	if !errors.Is(err, service.ErrNotFound) {
+		return namespace, err
+	}
+	// a namespace with modules but without settings
+	if _, statErr := os.Stat(filepath.Join(s.root, filepath.FromSlash(modulesDir), name)); statErr == nil {
+		return service.Namespace{Name: name}, nil
+	}
+	return namespace, err
+}
+
+func (s *FilesystemModuleService) PutNamespace(namespace service.Namespace) error {
+	if err := validatePath(namespace.Name); err != nil {
+		return err
+	}
+	encoded, err := service.EncodeNamespace(namespace)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.root, namespacesDir), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.namespacePath(namespace.Name), encoded)
+}
+
+func (s *FilesystemModuleService) getNamespace(name string) (service.Namespace, error) {
+	f, err := os.Open(s.namespacePath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return service.Namespace{}, fmt.Errorf("namespace %s: %w", name, service.ErrNotFound)
+	}
+	if err != nil {
+		return service.Namespace{}, err
+	}
+	defer f.Close()
+	return service.DecodeNamespace(f)
+}
+
+// namespaceSettings reads the settings of all namespaces that have some
+func (s *FilesystemModuleService) namespaceSettings() (map[string]service.Namespace, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, namespacesDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]service.Namespace{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]service.Namespace{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), settingsExt)
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), settingsExt) || validatePath(name) != nil {
+			continue
+		}
+		namespace, err := s.getNamespace(name)
+		if err != nil {
+			return nil, err
+		}
+		settings[name] = namespace
+	}
+	return settings, nil
+}
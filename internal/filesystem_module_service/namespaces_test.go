@@ -0,0 +1,78 @@
+package filesystemmoduleservice
+
+import (
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	s := newService(t)
+	upload(t, s, "hashicorp", "consul", "aws", "1.0.0")
+	upload(t, s, "zoitech", "network", "aws", "1.0.0")
+
+	namespaces, err := s.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "hashicorp"}, {Name: "zoitech"}}, namespaces)
+
+	namespace, err := s.Namespace("hashicorp")
+	assert.NoError(t, err)
+	assert.Equal(t, service.Namespace{Name: "hashicorp"}, namespace)
+
+	_, err = s.Namespace("acme")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	// namespaces can be verified before they have modules
+	assert.NoError(t, s.PutNamespace(service.Namespace{Name: "acme", Verified: true}))
+	assert.NoError(t, s.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+	namespaces, err = s.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Verified: true}, {Name: "hashicorp", Verified: true}, {Name: "zoitech"}}, namespaces)
+
+	assert.ErrorIs(t, s.PutNamespace(service.Namespace{Name: "../modules"}), service.ErrInvalidModule)
+}
+
+func TestVerifiedModules(t *testing.T) {
+	s := newService(t)
+	upload(t, s, "hashicorp", "consul", "aws", "1.0.0")
+	upload(t, s, "zoitech", "network", "aws", "1.0.0")
+	assert.NoError(t, s.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+
+	result, err := s.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, lo.Map(result.Modules, func(m service.Module, _ int) bool { return m.Verified }))
+
+	result, err = s.List(service.ListParams{Limit: 10, Verified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s.Search(service.SearchParams{Query: "aws", Limit: 10, Verified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	details, err := s.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}, "1.0.0")
+	assert.NoError(t, err)
+	assert.True(t, details.Module.Verified)
+}
+
+func TestPrivateNamespaces(t *testing.T) {
+	s := newService(t)
+	upload(t, s, "hashicorp", "consul", "aws", "1.0.0")
+	upload(t, s, "acme", "network", "aws", "1.0.0")
+
+	private := service.Namespace{Name: "acme", Private: true, Bindings: []service.Binding{{Role: service.RoleOwner, Member: "user:alice"}}}
+	assert.NoError(t, s.PutNamespace(private))
+	namespace, err := s.Namespace("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, private, namespace)
+
+	result, err := s.List(service.ListParams{Limit: 10, ExcludedNamespaces: []string{"acme"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s.Search(service.SearchParams{Query: "network", Limit: 10, ExcludedNamespaces: []string{"acme"}})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+}
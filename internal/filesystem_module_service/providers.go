@@ -0,0 +1,156 @@
+package filesystemmoduleservice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+)
+
+const (
+	providersDir = "providers/namespaces"
+	releaseName  = "release.json"
+
+	// providerFileUrl is where terraform fetches the files of a release from, relative to the download endpoint of a platform
+	providerFileUrl = "../../files/"
+)
+
+var (
+	_ providerservice.ProviderStore     = (*FilesystemModuleService)(nil)
+	_ providerservice.ProviderFileStore = (*FilesystemModuleService)(nil)
+)
+
+func (s *FilesystemModuleService) providerDir(provider providerservice.ProviderDescriptor) string {
+	return filepath.Join(s.root, filepath.FromSlash(providersDir), provider.Namespace, provider.Type)
+}
+
+func (s *FilesystemModuleService) providerFilePath(provider providerservice.ProviderDescriptor, version, file string) string {
+	return filepath.Join(s.providerDir(provider), version, file)
+}
+
+// ProviderReleases reads the release documents of all versions, versions without document are still being uploaded
+func (s *FilesystemModuleService) ProviderReleases(provider providerservice.ProviderDescriptor) ([]providerservice.Release, error) {
+	if err := validatePath(provider.Namespace, provider.Type); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.providerDir(provider))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	releases := []providerservice.Release{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		release, err := s.ProviderRelease(provider, entry.Name())
+		if errors.Is(err, service.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%s: %w", providerservice.ProviderSubject(provider), service.ErrNotFound)
+	}
+	providerservice.SortReleases(releases)
+	return releases, nil
+}
+
+func (s *FilesystemModuleService) ProviderRelease(provider providerservice.ProviderDescriptor, version string) (providerservice.Release, error) {
+	if err := validatePath(provider.Namespace, provider.Type, version); err != nil {
+		return providerservice.Release{}, err
+	}
+	f, err := os.Open(s.providerFilePath(provider, version, releaseName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return providerservice.Release{}, fmt.Errorf("%s: %w", providerservice.ReleaseSubject(provider, version), service.ErrNotFound)
+	}
+	if err != nil {
+		return providerservice.Release{}, err
+	}
+	defer f.Close()
+	return providerservice.DecodeRelease(f)
+}
+
+// ProviderFileUrl points terraform to the file endpoint of the release, the registry serves the files with OpenProviderFile
+func (s *FilesystemModuleService) ProviderFileUrl(provider providerservice.ProviderDescriptor, version string, file string) (string, error) {
+	if err := validatePath(provider.Namespace, provider.Type, version, file); err != nil {
+		return "", err
+	}
+	return providerFileUrl + file, nil
+}
+
+// OpenProviderFile opens a file of a published release
+func (s *FilesystemModuleService) OpenProviderFile(provider providerservice.ProviderDescriptor, version string, file string) (io.ReadSeekCloser, time.Time, error) {
+	release, err := s.ProviderRelease(provider, version)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !release.HasFile(file) {
+		return nil, time.Time{}, fmt.Errorf("%s: file %s: %w", providerservice.ReleaseSubject(provider, version), file, service.ErrNotFound)
+	}
+	f, err := os.Open(s.providerFilePath(provider, version, file))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, fmt.Errorf("%s: file %s: %w", providerservice.ReleaseSubject(provider, version), file, service.ErrNotFound)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// PutProviderRelease writes the files first so a release document always points to existing files.
+// Uploads of the same provider are serialized with a lock file
+func (s *FilesystemModuleService) PutProviderRelease(release providerservice.Release, files map[string][]byte) error {
+	provider := release.Descriptor()
+	if err := validatePath(provider.Namespace, provider.Type, release.Version); err != nil {
+		return err
+	}
+	for _, file := range release.Files() {
+		if err := validatePath(file); err != nil {
+			return err
+		}
+		if _, ok := files[file]; !ok {
+			return fmt.Errorf("%s: file %s is missing", providerservice.ReleaseSubject(provider, release.Version), file)
+		}
+	}
+	encoded, err := providerservice.EncodeRelease(release)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.providerDir(provider), release.Version), 0o755); err != nil {
+		return err
+	}
+	unlock, err := lock(filepath.Join(s.providerDir(provider), lockName))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = os.Stat(s.providerFilePath(provider, release.Version, releaseName))
+	switch {
+	case err == nil:
+		return fmt.Errorf("%s: %w", providerservice.ReleaseSubject(provider, release.Version), service.ErrAlreadyExists)
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	for _, file := range release.Files() {
+		if err := writeFileAtomic(s.providerFilePath(provider, release.Version, file), files[file]); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(s.providerFilePath(provider, release.Version, releaseName), encoded)
+}
@@ -0,0 +1,55 @@
+package filesystemmoduleservice
+
+import (
+	"io"
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderReleases(t *testing.T) {
+	s := newService(t)
+	provider := providerservice.ProviderDescriptor{Namespace: "acme", Type: "cloud"}
+
+	_, err := s.ProviderReleases(provider)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	for _, version := range []string{"1.10.0", "1.2.0"} {
+		release, files := tft.NewProviderRelease("acme", "cloud", version, "linux_amd64", "darwin_arm64")
+		require.NoError(t, s.PutProviderRelease(release, files))
+	}
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.2.0", "linux_amd64")
+	assert.ErrorIs(t, s.PutProviderRelease(release, files), service.ErrAlreadyExists)
+	release, files = tft.NewProviderRelease("acme", "cloud", "2.0.0", "linux_amd64")
+	delete(files, release.SignatureFilename())
+	assert.EqualError(t, s.PutProviderRelease(release, files), "provider acme/cloud 2.0.0: file terraform-provider-cloud_2.0.0_SHA256SUMS.sig is missing")
+
+	releases, err := s.ProviderReleases(provider)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.10.0"}, lo.Map(releases, func(r providerservice.Release, _ int) string { return r.Version }))
+	assert.Len(t, releases[0].Packages, 2)
+
+	url, err := s.ProviderFileUrl(provider, "1.2.0", "terraform-provider-cloud_1.2.0_linux_amd64.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, "../../files/terraform-provider-cloud_1.2.0_linux_amd64.zip", url)
+
+	f, _, err := s.OpenProviderFile(provider, "1.2.0", "terraform-provider-cloud_1.2.0_linux_amd64.zip")
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "package terraform-provider-cloud_1.2.0_linux_amd64.zip", string(content))
+
+	// only files of the release are served
+	_, _, err = s.OpenProviderFile(provider, "1.2.0", releaseName)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	_, _, err = s.OpenProviderFile(provider, "2.0.0", "terraform-provider-cloud_2.0.0_linux_amd64.zip")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	_, err = s.ProviderRelease(providerservice.ProviderDescriptor{Namespace: "..", Type: "cloud"}, "1.2.0")
+	assert.ErrorIs(t, err, service.ErrInvalidModule)
+}
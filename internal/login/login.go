@@ -0,0 +1,316 @@
+package login
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/mxab/tf-registry/internal/auth"
+)
+
+type (
+	// Config configures terraform login, users log in with the users file or at an oidc provider
+	Config struct {
+		// ClientID terraform sends, defaults to terraform-cli
+		ClientID string `json:"client_id"`
+		// Ports is the range of local ports terraform may listen on for the redirect, defaults to 10000 to 10010
+		Ports [2]int `json:"ports"`
+		// TokenTTL is how long issued tokens are valid, defaults to 720h
+		TokenTTL string `json:"token_ttl"`
+		// Scopes of the issued tokens, defaults to read
+		Scopes    []auth.Scope        `json:"scopes"`
+		UsersFile string              `json:"users_file"`
+		OIDC      *OIDCProviderConfig `json:"oidc"`
+	}
+	AuthorizationRequest struct {
+		ResponseType        string `query:"response_type"`
+		ClientID            string `query:"client_id"`
+		RedirectURI         string `query:"redirect_uri"`
+		State               string `query:"state"`
+		CodeChallenge       string `query:"code_challenge"`
+		CodeChallengeMethod string `query:"code_challenge_method"`
+	}
+	TokenRequest struct {
+		GrantType    string `form:"grant_type"`
+		Code         string `form:"code"`
+		RedirectURI  string `form:"redirect_uri"`
+		ClientID     string `form:"client_id"`
+		CodeVerifier string `form:"code_verifier"`
+	}
+	TokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	TokenError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+)
+
+const (
+	// a user has this long to log in at the identity provider
+	stateTTL = 10 * time.Minute
+	// terraform redeems the code right after the redirect
+	codeTTL = time.Minute
+)
+
+// Controller implements the login.v1 service terraform login uses, an authorization code flow with PKCE.
+// Users authenticate at the identity provider, terraform exchanges the code it receives for a registry token
+type Controller struct {
+	Provider IdentityProvider
+	Tokens   *Tokens
+	ClientID string
+	Ports    [2]int
+
+	mu sync.Mutex
+	// redeemed codes by their expiry, codes are only used once on each instance
+	redeemed map[string]time.Time
+}
+
+// LoadConfig reads a login config file, a relative users file is resolved against its directory
+func LoadConfig(file string) (Config, error) {
+	config := Config{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse login config %s: %w", file, err)
+	}
+	if config.UsersFile != "" && !filepath.IsAbs(config.UsersFile) {
+		config.UsersFile = filepath.Join(filepath.Dir(file), config.UsersFile)
+	}
+	return config, nil
+}
+
+// New creates the login of a registry, the identity provider redirects back to the callback below the base url
+func New(config Config, baseURL string, secret []byte) (*Controller, error) {
+	if config.ClientID == "" {
+		config.ClientID = "terraform-cli"
+	}
+	if config.Ports == [2]int{} {
+		config.Ports = [2]int{10000, 10010}
+	}
+	if config.Ports[0] <= 0 || config.Ports[0] > config.Ports[1] || config.Ports[1] > 65535 {
+		return nil, fmt.Errorf("invalid port range %d-%d", config.Ports[0], config.Ports[1])
+	}
+	ttl := 30 * 24 * time.Hour
+	if config.TokenTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(config.TokenTTL); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid token_ttl %q", config.TokenTTL)
+		}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []auth.Scope{auth.ScopeRead}
+	}
+	for _, scope := range config.Scopes {
+		if scope != auth.ScopeRead && scope != auth.ScopePublish {
+			return nil, fmt.Errorf("unknown scope %q, use read or publish", scope)
+		}
+	}
+
+	var provider IdentityProvider
+	var err error
+	switch {
+	case config.UsersFile != "" && config.OIDC != nil:
+		return nil, fmt.Errorf("configure either users_file or oidc")
+	case config.UsersFile != "":
+		provider, err = LoadUsers(config.UsersFile)
+	case config.OIDC != nil:
+		if baseURL == "" {
+			return nil, fmt.Errorf("login at an oidc provider requires the base url of the registry")
+		}
+		provider, err = NewOIDCProvider(*config.OIDC, baseURL+"/oauth/callback")
+	default:
+		return nil, fmt.Errorf("configure users_file or oidc")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{
+		Provider: provider,
+		Tokens:   NewTokens(secret, ttl, config.Scopes),
+		ClientID: config.ClientID,
+		Ports:    config.Ports,
+	}, nil
+}
+
+// Authorize starts a login, the request is kept in the signed state until the user is authenticated
+func (ctrl *Controller) Authorize(c echo.Context) (err error) {
+	request := new(AuthorizationRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// errors are only redirected to known clients and redirect uris
+	if request.ClientID != ctrl.ClientID {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown client %q", request.ClientID))
+	}
+	if !ctrl.validRedirect(request.RedirectURI) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("redirect uri %q is no local port of terraform", request.RedirectURI))
+	}
+	if request.ResponseType != "code" {
+		return redirect(c, request.RedirectURI, url.Values{"error": {"unsupported_response_type"}, "state": {request.State}})
+	}
+	if request.CodeChallengeMethod != "S256" || request.CodeChallenge == "" {
+		return redirect(c, request.RedirectURI, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"a S256 code challenge is required"},
+			"state":             {request.State},
+		})
+	}
+	state, err := ctrl.Tokens.sign(audienceState, stateTTL, jwt.MapClaims{
+		"redirect_uri":   request.RedirectURI,
+		"state":          request.State,
+		"code_challenge": request.CodeChallenge,
+	})
+	if err != nil {
+		return err
+	}
+	return ctrl.Provider.Login(c, state)
+}
+
+// Callback receives the users from the identity provider and redirects them to terraform with a code
+func (ctrl *Controller) Callback(c echo.Context) error {
+	identity, state, err := ctrl.Provider.Callback(c)
+	if identity == nil && err == nil {
+		return nil
+	}
+	pending, verifyErr := ctrl.Tokens.verify(audienceState, state)
+	if verifyErr != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the login expired, please run terraform login again")
+	}
+	redirectURI, _ := pending["redirect_uri"].(string)
+	clientState, _ := pending["state"].(string)
+	if err != nil {
+		c.Logger().Warnj(log.JSON{"message": "login failed", "error": err.Error(), "remote_ip": c.RealIP()})
+		reason := "server_error"
+		if errors.Is(err, ErrAccessDenied) {
+			reason = "access_denied"
+		}
+		return redirect(c, redirectURI, url.Values{"error": {reason}, "state": {clientState}})
+	}
+	code, err := ctrl.Tokens.sign(audienceCode, codeTTL, jwt.MapClaims{
+		"sub":            identity.Subject,
+		"groups":         identity.Groups,
+		"redirect_uri":   redirectURI,
+		"code_challenge": pending["code_challenge"],
+	})
+	if err != nil {
+		return err
+	}
+	return redirect(c, redirectURI, url.Values{"code": {code}, "state": {clientState}})
+}
+
+// Token exchanges a code for an access token, terraform proves with the code verifier that it started the login
+func (ctrl *Controller) Token(c echo.Context) (err error) {
+	request := new(TokenRequest)
+	if err = c.Bind(request); err != nil {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "invalid_request", Description: err.Error()})
+	}
+	if request.GrantType != "authorization_code" {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "unsupported_grant_type"})
+	}
+	if request.ClientID != ctrl.ClientID {
+		return c.JSON(http.StatusUnauthorized, TokenError{Error: "invalid_client"})
+	}
+	claims, err := ctrl.Tokens.verify(audienceCode, request.Code)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "invalid_grant", Description: "invalid or expired code"})
+	}
+	if claims["redirect_uri"] != request.RedirectURI {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "invalid_grant", Description: "redirect uri does not match"})
+	}
+	challenge, _ := claims["code_challenge"].(string)
+	sum := sha256.Sum256([]byte(request.CodeVerifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) != 1 {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "invalid_grant", Description: "code verifier does not match"})
+	}
+	if !ctrl.redeem(claims) {
+		return c.JSON(http.StatusBadRequest, TokenError{Error: "invalid_grant", Description: "code was already used"})
+	}
+
+	identity := auth.Identity{Subject: claims["sub"].(string), Groups: stringList(claims["groups"])}
+	token, err := ctrl.Tokens.issue(identity)
+	if err != nil {
+		return err
+	}
+	c.Logger().Infoj(log.JSON{
+		"audit":     "login",
+		"subject":   identity.Subject,
+		"groups":    identity.Groups,
+		"remote_ip": c.RealIP(),
+	})
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, TokenResponse{
+		AccessToken: token,
+		TokenType:   "bearer",
+		ExpiresIn:   int64(ctrl.Tokens.TTL.Seconds()),
+	})
+}
+
+// validRedirect accepts the local redirect uris terraform listens on
+func (ctrl *Controller) validRedirect(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme != "http" || (u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1") {
+		return false
+	}
+	port, err := strconv.Atoi(u.Port())
+	return err == nil && port >= ctrl.Ports[0] && port <= ctrl.Ports[1]
+}
+
+// redeem marks a code as used, it returns false if it was used before
+func (ctrl *Controller) redeem(claims jwt.MapClaims) bool {
+	id, _ := claims["jti"].(string)
+	expiry := time.Unix(int64(claims["exp"].(float64)), 0)
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	if ctrl.redeemed == nil {
+		ctrl.redeemed = map[string]time.Time{}
+	}
+	if _, ok := ctrl.redeemed[id]; ok {
+		return false
+	}
+	now := ctrl.Tokens.now()
+	for code, expiresAt := range ctrl.redeemed {
+		if expiresAt.Before(now) {
+			delete(ctrl.redeemed, code)
+		}
+	}
+	ctrl.redeemed[id] = expiry
+	return true
+}
+
+func redirect(c echo.Context, redirectURI string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+func RegisterLoginControllerGroup(g *echo.Group, ctrl *Controller) {
+	g.GET("/authorization", ctrl.Authorize)
+	g.GET("/callback", ctrl.Callback)
+	g.POST("/callback", ctrl.Callback)
+	g.POST("/token", ctrl.Token)
+}
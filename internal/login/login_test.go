@@ -0,0 +1,256 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	redirectURI = "http://localhost:10003/login"
+	verifier    = "a-code-verifier-of-terraform-that-is-long-enough-for-pkce"
+)
+
+func challenge() string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newLoginServer(provider IdentityProvider) (*echo.Echo, *Controller) {
+	ctrl := &Controller{
+		Provider: provider,
+		Tokens:   NewTokens([]byte("secret"), time.Hour, []auth.Scope{auth.ScopeRead}),
+		ClientID: "terraform-cli",
+		Ports:    [2]int{10000, 10010},
+	}
+	e := echo.New()
+	RegisterLoginControllerGroup(e.Group("/oauth"), ctrl)
+	return e, ctrl
+}
+
+func serve(e *echo.Echo, method, target string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func authorizeURL(params url.Values) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"terraform-cli"},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"terraform-state"},
+		"code_challenge":        {challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range params {
+		query[key] = values
+	}
+	return "/oauth/authorization?" + query.Encode()
+}
+
+func redirectParams(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	assert.Equal(t, redirectURI, location.Scheme+"://"+location.Host+location.Path)
+	return location.Query()
+}
+
+func redeem(e *echo.Echo, code, codeVerifier string) *httptest.ResponseRecorder {
+	return serve(e, http.MethodPost, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {"terraform-cli"},
+		"code_verifier": {codeVerifier},
+	})
+}
+
+func TestLoginWithUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	provider, err := NewUsersProvider([]UserEntry{{Username: "alice", Bcrypt: string(hash), Groups: []string{"platform"}}})
+	require.NoError(t, err)
+	e, ctrl := newLoginServer(provider)
+
+	rec := serve(e, http.MethodGet, authorizeURL(nil), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	match := regexp.MustCompile(`name="state" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	require.Len(t, match, 2)
+	state := match[1]
+
+	rec = serve(e, http.MethodPost, "/oauth/callback", url.Values{"state": {state}, "username": {"alice"}, "password": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Wrong username or password")
+
+	rec = serve(e, http.MethodPost, "/oauth/callback", url.Values{"state": {state}, "username": {"alice"}, "password": {"password"}})
+	params := redirectParams(t, rec)
+	assert.Equal(t, "terraform-state", params.Get("state"))
+	code := params.Get("code")
+
+	// the code is no access token
+	_, err = ctrl.Tokens.Authenticate(code)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	rec = redeem(e, code, "another-verifier")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error": "invalid_grant", "error_description": "code verifier does not match"}`, rec.Body.String())
+
+	rec = redeem(e, code, verifier)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	response := TokenResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "bearer", response.TokenType)
+	assert.Equal(t, int64(3600), response.ExpiresIn)
+
+	identity, err := ctrl.Tokens.Authenticate(response.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, auth.Identity{Subject: "alice", Groups: []string{"platform"}, Scopes: []auth.Scope{auth.ScopeRead}}, identity)
+
+	rec = redeem(e, code, verifier)
+	assert.JSONEq(t, `{"error": "invalid_grant", "error_description": "code was already used"}`, rec.Body.String())
+}
+
+func TestAuthorizeRejectsInvalidRequests(t *testing.T) {
+	e, _ := newLoginServer(&UsersProvider{})
+
+	for _, params := range []url.Values{
+		{"client_id": {"other"}},
+		{"redirect_uri": {"http://localhost:9999/login"}},
+		{"redirect_uri": {"https://attacker.example.com:10003/login"}},
+	} {
+		rec := serve(e, http.MethodGet, authorizeURL(params), nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, params)
+	}
+
+	rec := serve(e, http.MethodGet, authorizeURL(url.Values{"code_challenge_method": {"plain"}}), nil)
+	params := redirectParams(t, rec)
+	assert.Equal(t, "invalid_request", params.Get("error"))
+	assert.Equal(t, "terraform-state", params.Get("state"))
+
+	rec = serve(e, http.MethodPost, "/oauth/callback", url.Values{"state": {"forged"}, "username": {"alice"}})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = redeem(e, "forged", verifier)
+	assert.JSONEq(t, `{"error": "invalid_grant", "error_description": "invalid or expired code"}`, rec.Body.String())
+}
+
+func TestLoginWithOIDCProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q, "authorization_endpoint": %q, "token_endpoint": %q, "jwks_uri": %q}`,
+				idp.URL, idp.URL+"/authorize", idp.URL+"/token", idp.URL+"/keys")
+		case "/keys":
+			fmt.Fprintf(w, `{"keys": [{"kid": "idp", "kty": "RSA", "n": %q, "e": %q}]}`,
+				base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+		case "/token":
+			if r.FormValue("code") != "idp-code" || r.FormValue("client_secret") != "client-secret" ||
+				r.FormValue("redirect_uri") != "https://registry.example.com/oauth/callback" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			// the test passes the nonce as part of the code request to keep the fake provider stateless
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss":                idp.URL,
+				"aud":                "registry",
+				"sub":                "f81d4fae",
+				"preferred_username": "bob",
+				"groups":             []string{"developers"},
+				"nonce":              r.URL.Query().Get("nonce"),
+				"exp":                time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = "idp"
+			signed, err := token.SignedString(key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": "idp-access", "token_type": "Bearer", "id_token": %q}`, signed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+
+	provider, err := NewOIDCProvider(OIDCProviderConfig{
+		Issuer:       idp.URL,
+		ClientID:     "registry",
+		ClientSecret: "client-secret",
+		Scopes:       []string{"profile", "groups"},
+		SubjectClaim: "preferred_username",
+		GroupsClaim:  "groups",
+	}, "https://registry.example.com/oauth/callback")
+	require.NoError(t, err)
+	e, ctrl := newLoginServer(provider)
+
+	rec := serve(e, http.MethodGet, authorizeURL(nil), nil)
+	require.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	assert.Equal(t, idp.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "openid profile groups", location.Query().Get("scope"))
+	assert.Equal(t, "https://registry.example.com/oauth/callback", location.Query().Get("redirect_uri"))
+	state, nonce := location.Query().Get("state"), location.Query().Get("nonce")
+
+	rec = serve(e, http.MethodGet, "/oauth/callback?"+url.Values{"error": {"access_denied"}, "state": {state}}.Encode(), nil)
+	assert.Equal(t, "access_denied", redirectParams(t, rec).Get("error"))
+
+	// an id token with the nonce of another login is rejected
+	provider.token = idp.URL + "/token?nonce=other"
+	rec = serve(e, http.MethodGet, "/oauth/callback?"+url.Values{"code": {"idp-code"}, "state": {state}}.Encode(), nil)
+	assert.Equal(t, "access_denied", redirectParams(t, rec).Get("error"))
+
+	provider.token = idp.URL + "/token?nonce=" + nonce
+	rec = serve(e, http.MethodGet, "/oauth/callback?"+url.Values{"code": {"idp-code"}, "state": {state}}.Encode(), nil)
+	params := redirectParams(t, rec)
+	assert.Equal(t, "terraform-state", params.Get("state"))
+
+	rec = redeem(e, params.Get("code"), verifier)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	response := TokenResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	identity, err := ctrl.Tokens.Authenticate(response.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, auth.Identity{Subject: "bob", Groups: []string{"developers"}, Scopes: []auth.Scope{auth.ScopeRead}}, identity)
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	_, err := New(Config{}, "", []byte("secret"))
+	assert.EqualError(t, err, "configure users_file or oidc")
+	_, err = New(Config{OIDC: &OIDCProviderConfig{Issuer: "https://idp.example.com", ClientID: "registry"}}, "", []byte("secret"))
+	assert.EqualError(t, err, "login at an oidc provider requires the base url of the registry")
+	_, err = New(Config{UsersFile: "users.json", Scopes: []auth.Scope{"admin"}}, "", []byte("secret"))
+	assert.EqualError(t, err, `unknown scope "admin", use read or publish`)
+}
@@ -0,0 +1,187 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+)
+
+// OIDCProviderConfig configures an openid connect provider users log in at, like keycloak or azure ad
+type OIDCProviderConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// Scopes requested besides openid
+	Scopes []string `json:"scopes"`
+	// SubjectClaim names the user, defaults to sub
+	SubjectClaim string `json:"subject_claim"`
+	// GroupsClaim is a list of the groups of the user, namespaces grant roles to them
+	GroupsClaim string `json:"groups_claim"`
+	// AuthorizationEndpoint and TokenEndpoint override the endpoints of the openid configuration of the issuer
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURL               string `json:"jwks_url"`
+}
+
+// OIDCProvider logs users in at an openid connect provider with the authorization code flow
+type OIDCProvider struct {
+	config      OIDCProviderConfig
+	callbackURL string
+	verifier    *auth.IDTokenVerifier
+	client      *http.Client
+
+	mu            sync.Mutex
+	authorization string
+	token         string
+}
+
+// NewOIDCProvider creates a provider that redirects users back to the callback url of the registry
+func NewOIDCProvider(config OIDCProviderConfig, callbackURL string) (*OIDCProvider, error) {
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("oidc provider %q: client_id is required", config.Issuer)
+	}
+	verifier, err := auth.NewIDTokenVerifier(auth.OIDCIssuer{
+		Issuer:       config.Issuer,
+		Audience:     config.ClientID,
+		JWKSURL:      config.JWKSURL,
+		SubjectClaim: config.SubjectClaim,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		config:        config,
+		callbackURL:   callbackURL,
+		verifier:      verifier,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		authorization: config.AuthorizationEndpoint,
+		token:         config.TokenEndpoint,
+	}, nil
+}
+
+func (p *OIDCProvider) Login(c echo.Context, state string) error {
+	authorization, _, err := p.endpoints()
+	if err != nil {
+		return err
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.callbackURL},
+		"scope":         {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":         {state},
+		"nonce":         {nonce(state)},
+	}
+	separator := "?"
+	if strings.Contains(authorization, "?") {
+		separator = "&"
+	}
+	return c.Redirect(http.StatusFound, authorization+separator+query.Encode())
+}
+
+func (p *OIDCProvider) Callback(c echo.Context) (*auth.Identity, string, error) {
+	state := c.QueryParam("state")
+	if reason := c.QueryParam("error"); reason != "" {
+		return nil, state, fmt.Errorf("%w: %s %s", ErrAccessDenied, reason, c.QueryParam("error_description"))
+	}
+	idToken, err := p.exchange(c.QueryParam("code"))
+	if err != nil {
+		return nil, state, err
+	}
+	claims, err := p.verifier.Verify(idToken)
+	if err != nil {
+		return nil, state, fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	}
+	// the nonce binds the id token to the login it was requested for
+	if claims["nonce"] != nonce(state) {
+		return nil, state, fmt.Errorf("%w: id token of another login", ErrAccessDenied)
+	}
+	identity := &auth.Identity{Subject: claims[p.verifier.SubjectClaim].(string)}
+	if p.config.GroupsClaim != "" {
+		identity.Groups = stringList(claims[p.config.GroupsClaim])
+	}
+	return identity, state, nil
+}
+
+// exchange redeems the code at the token endpoint and returns the id token
+func (p *OIDCProvider) exchange(code string) (string, error) {
+	_, tokenEndpoint, err := p.endpoints()
+	if err != nil {
+		return "", err
+	}
+	res, err := p.client.PostForm(tokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.callbackURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint of %s: %s %s", ErrAccessDenied, p.config.Issuer, res.Status, data)
+	}
+	response := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(data, &response); err != nil || response.IDToken == "" {
+		return "", fmt.Errorf("token endpoint of %s returned no id token", p.config.Issuer)
+	}
+	return response.IDToken, nil
+}
+
+// endpoints returns the authorization and token endpoint, discovering them from the openid configuration of the issuer if not configured
+func (p *OIDCProvider) endpoints() (string, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.authorization != "" && p.token != "" {
+		return p.authorization, p.token, nil
+	}
+	configurationURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	res, err := p.client.Get(configurationURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to fetch %s: %s", configurationURL, res.Status)
+	}
+	configuration := struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&configuration); err != nil {
+		return "", "", fmt.Errorf("failed to parse %s: %w", configurationURL, err)
+	}
+	if p.authorization == "" {
+		p.authorization = configuration.AuthorizationEndpoint
+	}
+	if p.token == "" {
+		p.token = configuration.TokenEndpoint
+	}
+	if p.authorization == "" || p.token == "" {
+		return "", "", fmt.Errorf("issuer %s: openid configuration without authorization or token endpoint", p.config.Issuer)
+	}
+	return p.authorization, p.token, nil
+}
+
+func nonce(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,108 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IdentityProvider authenticates the users of terraform login
+type IdentityProvider interface {
+	// Login lets the user authenticate, e.g. with a login form or a redirect to an external provider.
+	// The state has to be passed back to the callback
+	Login(c echo.Context, state string) error
+	// Callback authenticates the user coming back from Login and returns the state given to Login.
+	// A nil identity means the provider answered the request itself, like showing the login form again after a wrong password
+	Callback(c echo.Context) (identity *auth.Identity, state string, err error)
+}
+
+// ErrAccessDenied is returned by identity providers for users that could not be authenticated
+var ErrAccessDenied = errors.New("access denied")
+
+type (
+	// UserEntry is a user of the users file, the password is a bcrypt hash like htpasswd -nB creates
+	UserEntry struct {
+		Username string   `json:"username"`
+		Bcrypt   string   `json:"bcrypt"`
+		Groups   []string `json:"groups,omitempty"`
+	}
+	UsersFile struct {
+		Users []UserEntry `json:"users"`
+	}
+)
+
+// UsersProvider authenticates the users of a users file with a login form
+type UsersProvider struct {
+	users map[string]UserEntry
+}
+
+func NewUsersProvider(entries []UserEntry) (*UsersProvider, error) {
+	users := map[string]UserEntry{}
+	for _, entry := range entries {
+		if entry.Username == "" {
+			return nil, fmt.Errorf("user without username")
+		}
+		if _, err := bcrypt.Cost([]byte(entry.Bcrypt)); err != nil {
+			return nil, fmt.Errorf("user %q: bcrypt must be a bcrypt hash: %w", entry.Username, err)
+		}
+		users[entry.Username] = entry
+	}
+	return &UsersProvider{users: users}, nil
+}
+
+func LoadUsers(path string) (*UsersProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := UsersFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %w", path, err)
+	}
+	return NewUsersProvider(file.Users)
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Terraform Registry Login</title></head>
+<body>
+<h1>Log in to the Terraform Registry</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post" action="callback">
+<input type="hidden" name="state" value="{{.State}}">
+<p><label>Username <input name="username" autocomplete="username" autofocus></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+func (p *UsersProvider) Login(c echo.Context, state string) error {
+	return p.form(c, http.StatusOK, state, "")
+}
+
+func (p *UsersProvider) Callback(c echo.Context) (*auth.Identity, string, error) {
+	state := c.FormValue("state")
+	if c.Request().Method != http.MethodPost {
+		return nil, state, p.form(c, http.StatusMethodNotAllowed, state, "")
+	}
+	user, ok := p.users[c.FormValue("username")]
+	if !ok || bcrypt.CompareHashAndPassword([]byte(user.Bcrypt), []byte(c.FormValue("password"))) != nil {
+		return nil, state, p.form(c, http.StatusUnauthorized, state, "Wrong username or password")
+	}
+	return &auth.Identity{Subject: user.Username, Groups: user.Groups}, state, nil
+}
+
+func (p *UsersProvider) form(c echo.Context, code int, state string, message string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(code)
+	return loginForm.Execute(c.Response(), struct{ State, Error string }{state, message})
+}
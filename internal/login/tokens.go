@@ -0,0 +1,112 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/samber/lo"
+)
+
+const (
+	issuer = "tf-registry"
+	// the audiences keep the signed values of the login apart, a code or state is no access token
+	audienceToken = "tf-registry"
+	audienceCode  = "tf-registry-login-code"
+	audienceState = "tf-registry-login-state"
+)
+
+// Tokens signs the access tokens issued by terraform login and the pending logins and codes of the flow.
+// Nothing is stored, every registry instance sharing the secret accepts them
+type Tokens struct {
+	secret []byte
+	// TTL is how long issued access tokens are valid
+	TTL    time.Duration
+	Scopes []auth.Scope
+	now    func() time.Time
+}
+
+func NewTokens(secret []byte, ttl time.Duration, scopes []auth.Scope) *Tokens {
+	return &Tokens{secret: secret, TTL: ttl, Scopes: scopes, now: time.Now}
+}
+
+// RandomSecret creates a secret for registries without configured secret, tokens signed with it are lost on restart
+func RandomSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Authenticate accepts the access tokens issued by terraform login
+func (t *Tokens) Authenticate(token string) (auth.Identity, error) {
+	claims, err := t.verify(audienceToken, token)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	return auth.Identity{
+		Subject: claims["sub"].(string),
+		Groups:  stringList(claims["groups"]),
+		Scopes: lo.Map(stringList(claims["scopes"]), func(scope string, _ int) auth.Scope {
+			return auth.Scope(scope)
+		}),
+	}, nil
+}
+
+// issue creates an access token for a user that logged in
+func (t *Tokens) issue(identity auth.Identity) (string, error) {
+	return t.sign(audienceToken, t.TTL, jwt.MapClaims{
+		"sub":    identity.Subject,
+		"groups": identity.Groups,
+		"scopes": t.Scopes,
+	})
+}
+
+func (t *Tokens) sign(audience string, ttl time.Duration, claims jwt.MapClaims) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := t.now()
+	claims["iss"] = issuer
+	claims["aud"] = audience
+	claims["jti"] = hex.EncodeToString(id)
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+// verify returns the claims of a valid value signed for the audience, everything else is an invalid token
+func (t *Tokens) verify(audience string, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"HS256"}, SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return t.secret, nil }); err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	if !claims.VerifyIssuer(issuer, true) || !claims.VerifyAudience(audience, true) {
+		return nil, auth.ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(t.now().Unix(), true) {
+		return nil, fmt.Errorf("%w: expired", auth.ErrInvalidToken)
+	}
+	if subject, ok := claims["sub"].(string); audience != audienceState && (!ok || subject == "") {
+		return nil, auth.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// stringList returns the strings of a json array claim
+func stringList(value interface{}) []string {
+	values, _ := value.([]interface{})
+	var list []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
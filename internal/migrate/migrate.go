@@ -0,0 +1,257 @@
+package migrate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/bundle"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/samber/lo"
+)
+
+type (
+	// Migrator copies the namespace settings and module versions of one module service to another.
+	// Runs are idempotent, versions that reached the destination are skipped, so an interrupted run is resumed by running it again
+	Migrator struct {
+		From service.ModuleService
+		To   service.ModuleService
+		// State skips the versions a previous run migrated without comparing their checksums, nil compares all of them
+		State *State
+		// Client downloads the archives of module services that are not an archive store
+		Client *http.Client
+		// Progress is told the outcome of every version
+		Progress func(Version)
+	}
+	// Version is a migrated module version and what happened to it
+	Version struct {
+		service.ModuleDescriptor
+		Version string
+		SHA256  string
+		Outcome Outcome
+		// Err is why a version failed
+		Err error
+	}
+	Outcome string
+	// Summary counts the outcomes of a run
+	Summary struct {
+		Namespaces int
+		Copied     int
+		Skipped    int
+		Conflicts  []Version
+		Failed     []Version
+		// ProvidersSkipped is set if the source keeps provider releases, which cannot be listed and are not migrated
+		ProvidersSkipped bool
+	}
+)
+
+const (
+	// Copied versions were uploaded and their checksum verified at the destination
+	Copied Outcome = "copied"
+	// Skipped versions exist at the destination with the same checksum
+	Skipped Outcome = "skipped"
+	// Conflict versions exist at the destination with another checksum, they are left untouched
+	Conflict Outcome = "conflict"
+	Failed   Outcome = "failed"
+)
+
+func (v Version) String() string {
+	return fmt.Sprintf("%s/%s/%s %s", v.Namespace, v.Name, v.System, v.Version)
+}
+
+// key identifies the version in the state
+func (v Version) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", v.Namespace, v.Name, v.System, v.Version)
+}
+
+// Migrate copies everything and carries on after versions that fail, they are listed in the summary.
+// The error is only set if the source cannot be listed or the run did not migrate every version
+func (m *Migrator) Migrate() (Summary, error) {
+	summary := Summary{}
+	_, summary.ProvidersSkipped = m.From.(providerservice.ProviderStore)
+	var err error
+	if summary.Namespaces, err = m.migrateNamespaces(); err != nil {
+		return summary, err
+	}
+	modules, err := m.modules()
+	if err != nil {
+		return summary, err
+	}
+	for _, modul := range modules {
+		versions, err := m.From.Versions(modul)
+		if err != nil {
+			return summary, err
+		}
+		for _, v := range versions {
+			version := m.migrateVersion(Version{ModuleDescriptor: modul, Version: v.Version})
+			switch version.Outcome {
+			case Copied:
+				summary.Copied++
+			case Skipped:
+				summary.Skipped++
+			case Conflict:
+				summary.Conflicts = append(summary.Conflicts, version)
+			default:
+				summary.Failed = append(summary.Failed, version)
+			}
+			if m.Progress != nil {
+				m.Progress(version)
+			}
+		}
+	}
+	if len(summary.Conflicts) > 0 || len(summary.Failed) > 0 {
+		return summary, fmt.Errorf("%d versions failed and %d exist with other checksums, run the migration again to retry the failed ones", len(summary.Failed), len(summary.Conflicts))
+	}
+	return summary, nil
+}
+
+// migrateNamespaces puts the settings of the source namespaces that differ at the destination
+func (m *Migrator) migrateNamespaces() (int, error) {
+	from, ok := m.From.(service.NamespaceStore)
+	if !ok {
+		return 0, nil
+	}
+	to, ok := m.To.(service.NamespaceStore)
+	if !ok {
+		return 0, errors.New("the destination cannot keep the settings of namespaces")
+	}
+	namespaces, err := from.Namespaces()
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, namespace := range namespaces {
+		if !namespace.HasSettings() {
+			continue
+		}
+		existing, err := to.Namespace(namespace.Name)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return migrated, err
+		}
+		if reflect.DeepEqual(existing, namespace) {
+			continue
+		}
+		if err := to.PutNamespace(namespace); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// modules lists all modules of the source
+func (m *Migrator) modules() ([]service.ModuleDescriptor, error) {
+	modules := []service.ModuleDescriptor{}
+	for offset := 0; ; {
+		result, err := m.From.List(service.ListParams{Limit: service.MaxLimit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		for _, modul := range result.Modules {
+			modules = append(modules, service.ModuleDescriptor{Namespace: modul.Namespace, Name: modul.Name, System: modul.Provider})
+		}
+		if len(result.Modules) == 0 || result.Meta.NextOffset <= offset {
+			return lo.Uniq(modules), nil
+		}
+		offset = result.Meta.NextOffset
+	}
+}
+
+// migrateVersion copies a version unless it exists at the destination and verifies the checksum of the copy
+func (m *Migrator) migrateVersion(version Version) Version {
+	fail := func(err error) Version {
+		version.Outcome, version.Err = Failed, err
+		return version
+	}
+	if sum, ok := m.State.done(version.key()); ok {
+		version.SHA256, version.Outcome = sum, Skipped
+		return version
+	}
+	data, err := m.read(m.From, version)
+	if err != nil {
+		return fail(err)
+	}
+	version.SHA256 = checksum(data)
+
+	existing, err := m.existingChecksum(version)
+	switch {
+	case err != nil:
+		return fail(err)
+	case existing == version.SHA256:
+		version.Outcome = Skipped
+	case existing != "":
+		version.Outcome = Conflict
+		return version
+	default:
+		details, err := m.From.Get(version.ModuleDescriptor, version.Version)
+		if err != nil {
+			return fail(err)
+		}
+		options := service.UploadOptions{Publisher: details.Module.Owner}
+		if publishedAt, err := time.Parse(time.RFC3339Nano, details.Module.PublishedAt); err == nil {
+			options.PublishedAt = publishedAt
+		}
+		err = m.To.UploadModule(version.ModuleDescriptor, version.Version, bytes.NewReader(data), options)
+		// a concurrent upload to the destination, the checksum tells if it is the same archive
+		if err != nil && !errors.Is(err, service.ErrAlreadyExists) {
+			return fail(fmt.Errorf("%s: %w", version, err))
+		}
+		copied, err := m.existingChecksum(version)
+		if err != nil {
+			return fail(err)
+		}
+		if copied != version.SHA256 {
+			version.Outcome = Conflict
+			return version
+		}
+		version.Outcome = Copied
+	}
+	if err := m.State.record(version.key(), version.SHA256); err != nil {
+		return fail(err)
+	}
+	return version
+}
+
+// existingChecksum is the checksum of the version at the destination, empty if it does not exist there
+func (m *Migrator) existingChecksum(version Version) (string, error) {
+	versions, err := m.To.Versions(version.ModuleDescriptor)
+	if errors.Is(err, service.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !lo.ContainsBy(versions, func(v service.ModuleVersion) bool { return v.Version == version.Version }) {
+		return "", nil
+	}
+	data, err := m.read(m.To, version)
+	if err != nil {
+		return "", err
+	}
+	return checksum(data), nil
+}
+
+func (m *Migrator) read(modules service.ModuleService, version Version) ([]byte, error) {
+	archive, err := bundle.OpenArchive(modules, m.Client, version.ModuleDescriptor, version.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", version, err)
+	}
+	return data, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
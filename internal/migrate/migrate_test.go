@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/catalog"
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	sqlitecatalog "github.com/mxab/tf-registry/internal/sqlite_catalog"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingModules counts the archives read from the module service
+type countingModules struct {
+	*filesystemmoduleservice.FilesystemModuleService
+	opens int
+}
+
+func (m *countingModules) OpenArchive(modul service.ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error) {
+	m.opens++
+	return m.FilesystemModuleService.OpenArchive(modul, version)
+}
+
+func newModules(t *testing.T) *countingModules {
+	t.Helper()
+	modules, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	require.NoError(t, err)
+	return &countingModules{FilesystemModuleService: modules}
+}
+
+var (
+	consul = service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	dns    = service.ModuleDescriptor{Namespace: "acme", Name: "dns", System: "aws"}
+)
+
+func upload(t *testing.T, modules service.ModuleService, modul service.ModuleDescriptor, version string) {
+	t.Helper()
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": `variable "version" { default = "` + version + `" }`})
+	require.NoError(t, modules.UploadModule(modul, version, bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+}
+
+// newSource has two versions of consul in the verified hashicorp namespace and acme/dns
+func newSource(t *testing.T) *countingModules {
+	source := newModules(t)
+	upload(t, source, consul, "0.1.0")
+	upload(t, source, consul, "0.2.0")
+	upload(t, source, dns, "1.0.0")
+	require.NoError(t, source.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+	return source
+}
+
+func TestMigrateCopiesModules(t *testing.T) {
+	source, destination := newSource(t), newModules(t)
+	outcomes := []string{}
+	migrator := &Migrator{From: source, To: destination, Progress: func(v Version) { outcomes = append(outcomes, v.String()+" "+string(v.Outcome)) }}
+
+	summary, err := migrator.Migrate()
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Namespaces: 1, Copied: 3, ProvidersSkipped: true}, summary)
+	assert.ElementsMatch(t, []string{"hashicorp/consul/aws 0.1.0 copied", "hashicorp/consul/aws 0.2.0 copied", "acme/dns/aws 1.0.0 copied"}, outcomes)
+
+	// the owner and publish date are kept
+	expected, err := source.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	details, err := destination.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", details.Module.Owner)
+	assert.Equal(t, expected.Module.PublishedAt, details.Module.PublishedAt)
+	assert.True(t, details.Module.Verified)
+
+	// a second run copies the versions published since the first
+	upload(t, source, dns, "1.1.0")
+	summary, err = migrator.Migrate()
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Copied: 1, Skipped: 3, ProvidersSkipped: true}, summary)
+}
+
+func TestMigrateResumesFromState(t *testing.T) {
+	source, destination := newSource(t), newModules(t)
+	path := filepath.Join(t.TempDir(), "state")
+	state, err := OpenState(path)
+	require.NoError(t, err)
+	_, err = (&Migrator{From: source, To: destination, State: state}).Migrate()
+	require.NoError(t, err)
+	require.NoError(t, state.Close())
+	journal, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(journal), "hashicorp/consul/aws/0.1.0 ")
+
+	// the versions of the journal are neither read from the source nor the destination
+	source.opens, destination.opens = 0, 0
+	state, err = OpenState(path)
+	require.NoError(t, err)
+	defer state.Close()
+	summary, err := (&Migrator{From: source, To: destination, State: state}).Migrate()
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.Skipped)
+	assert.Zero(t, source.opens)
+	assert.Zero(t, destination.opens)
+}
+
+func TestMigrateReportsConflicts(t *testing.T) {
+	source, destination := newSource(t), newModules(t)
+	require.NoError(t, destination.UploadModule(consul, "0.1.0", bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": "# other"})), service.UploadOptions{Publisher: "local"}))
+
+	summary, err := (&Migrator{From: source, To: destination}).Migrate()
+	assert.ErrorContains(t, err, "1 exist with other checksums")
+	assert.Equal(t, 2, summary.Copied)
+	if assert.Len(t, summary.Conflicts, 1) {
+		assert.Equal(t, "hashicorp/consul/aws 0.1.0", summary.Conflicts[0].String())
+	}
+
+	details, err := destination.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "local", details.Module.Owner)
+}
+
+// urlBlobStore hands out urls to its archives like s3 does, it is no archive store
+type urlBlobStore struct {
+	*httptest.Server
+	archives map[string][]byte
+}
+
+func newURLBlobStore(t *testing.T) *urlBlobStore {
+	blobs := &urlBlobStore{archives: map[string][]byte{}}
+	blobs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := blobs.archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(blobs.Close)
+	return blobs
+}
+
+func (b *urlBlobStore) key(modul service.ModuleDescriptor, version string) string {
+	return "/" + modul.Namespace + "/" + modul.Name + "/" + modul.System + "/" + version + ".zip"
+}
+
+func (b *urlBlobStore) PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	if _, ok := b.archives[b.key(modul, version)]; ok && !overwrite {
+		return service.ErrAlreadyExists
+	}
+	b.archives[b.key(modul, version)] = data
+	return nil
+}
+
+func (b *urlBlobStore) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	if _, ok := b.archives[b.key(modul, version)]; !ok {
+		return "", service.ErrNotFound
+	}
+	return b.URL + b.key(modul, version), nil
+}
+
+func TestMigrateFromCatalogOverURLBlobStore(t *testing.T) {
+	db, err := sqlitecatalog.Open(context.Background(), filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	source := catalog.NewCatalogModuleService(db, newURLBlobStore(t))
+	upload(t, source, consul, "0.1.0")
+	upload(t, source, dns, "1.0.0")
+	destination := newModules(t)
+
+	summary, err := (&Migrator{From: source, To: destination}).Migrate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Copied)
+	_, err = destination.Get(dns, "1.0.0")
+	assert.NoError(t, err)
+
+	// the migration is no download
+	details, err := source.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Zero(t, details.Module.Downloads)
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// State is a journal of the migrated versions with their checksums, one "<namespace>/<name>/<system>/<version> <sha256>" per line.
+// A nil state records nothing
+type State struct {
+	mu       sync.Mutex
+	file     *os.File
+	migrated map[string]string
+}
+
+// OpenState reads the versions of previous runs from the journal and appends the ones of this run
+func OpenState(path string) (*State, error) {
+	state := &State{migrated: map[string]string{}}
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			key, sum, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+			if !ok {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: invalid migration state", path, line)
+			}
+			state.migrated[key] = sum
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if state.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *State) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func (s *State) done(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum, ok := s.migrated[key]
+	return sum, ok
+}
+
+func (s *State) record(key, sum string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.migrated[key]; ok {
+		return nil
+	}
+	if _, err := fmt.Fprintf(s.file, "%s %s\n", key, sum); err != nil {
+		return err
+	}
+	s.migrated[key] = sum
+	return nil
+}
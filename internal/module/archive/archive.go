@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// Inspection holds what could be extracted from a module archive
+type Inspection struct {
+	SHA256      string
+	Size        int64
+	Description string
+	Root        service.ModuleSpec
+	Submodules  []service.ModuleSpec
+	Examples    []service.ModuleSpec
+}
+
+// Metadata builds the metadata document of a newly published version from the inspection
+func (i *Inspection) Metadata(modul service.ModuleDescriptor, version, publisher string, now time.Time) service.ModuleMetadata {
+	return service.ModuleMetadata{
+		Namespace:   modul.Namespace,
+		Name:        modul.Name,
+		System:      modul.System,
+		Version:     version,
+		Owner:       publisher,
+		Description: i.Description,
+		SHA256:      i.SHA256,
+		Size:        i.Size,
+		PublishedAt: now,
+		UpdatedAt:   now,
+		Root:        i.Root,
+		Submodules:  i.Submodules,
+		Examples:    i.Examples,
+	}
+}
+
+// Inspect reads a zipped module and extracts the information stored in its metadata
+func Inspect(data []byte) (*Inspection, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("module archive is not a valid zip file: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	inspection := &Inspection{
+		SHA256: hex.EncodeToString(sum[:]),
+		Size:   int64(len(data)),
+	}
+
+	if !tfconfig.IsModuleDirOnFilesystem(tfconfig.WrapFS(reader), ".") {
+		return nil, fmt.Errorf("module archive contains no terraform files at its root")
+	}
+	if inspection.Root, err = inspectModule(reader, "."); err != nil {
+		return nil, err
+	}
+	if inspection.Description, err = firstParagraph(strings.NewReader(inspection.Root.Readme)); err != nil {
+		return nil, err
+	}
+	if inspection.Submodules, err = inspectModules(reader, submodulesDir); err != nil {
+		return nil, err
+	}
+	if inspection.Examples, err = inspectModules(reader, examplesDir); err != nil {
+		return nil, err
+	}
+	return inspection, nil
+}
+
+// inspectModules inspects every module below parent
+func inspectModules(fsys fs.FS, parent string) ([]service.ModuleSpec, error) {
+	specs := []service.ModuleSpec{}
+	for _, dir := range moduleDirs(fsys, parent) {
+		spec, err := inspectModule(fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func inspectModule(fsys fs.FS, dir string) (service.ModuleSpec, error) {
+	module, err := loadModule(fsys, dir)
+	if err != nil {
+		return service.ModuleSpec{}, err
+	}
+	spec, err := moduleSpec(dir, module)
+	if err != nil {
+		return spec, err
+	}
+	spec.Readme, err = readme(fsys, dir)
+	return spec, err
+}
+
+// readme returns the README.md of dir, matched case insensitive, or nothing if there is none
+func readme(fsys fs.FS, dir string) (string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), "README.md") {
+			content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+			if err != nil {
+				return "", err
+			}
+			return string(content), nil
+		}
+	}
+	return "", nil
+}
+
+// firstParagraph returns the first text paragraph of a readme, skipping headings, badges and html
+func firstParagraph(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	paragraph := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			if len(paragraph) > 0 {
+				return strings.Join(paragraph, " "), nil
+			}
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "!["), strings.HasPrefix(line, "[!["), strings.HasPrefix(line, "<"):
+			if len(paragraph) > 0 {
+				return strings.Join(paragraph, " "), nil
+			}
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	return strings.Join(paragraph, " "), scanner.Err()
+}
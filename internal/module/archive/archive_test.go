@@ -0,0 +1,198 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	data := tft.ModuleZip(t, map[string]string{
+		"main.tf": "",
+		"README.md": `# Network
+
+[![Build](https://example.com/badge.svg)](https://example.com)
+
+Creates a VPC with
+public and private subnets.
+
+## Usage
+`,
+		"modules/sub/README.md": "Not the root readme",
+	})
+
+	inspection, err := Inspect(data)
+	assert.NoError(t, err)
+
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), inspection.SHA256)
+	assert.Equal(t, int64(len(data)), inspection.Size)
+	assert.Equal(t, "Creates a VPC with public and private subnets.", inspection.Description)
+}
+
+func TestInspectWithoutReadme(t *testing.T) {
+	inspection, err := Inspect(tft.ModuleZip(t, map[string]string{"main.tf": ""}))
+	assert.NoError(t, err)
+	assert.Equal(t, "", inspection.Description)
+}
+
+func TestInspectInvalidArchive(t *testing.T) {
+	_, err := Inspect([]byte("not a zip"))
+	assert.Error(t, err)
+}
+
+func TestFirstParagraph(t *testing.T) {
+	description, err := firstParagraph(strings.NewReader("<p align=\"center\">\n\nPlain text first line"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Plain text first line", description)
+}
+
+func TestInspectExtractsModuleSpecs(t *testing.T) {
+	data := tft.ModuleZip(t, map[string]string{
+		"versions.tf": `
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = ">= 4.0"
+    }
+    random = {
+      source = "hashicorp/random"
+    }
+  }
+}`,
+		"main.tf": `
+resource "aws_vpc" "this" {}
+data "template_file" "init" {}
+module "subnets" {
+  source = "./modules/subnets"
+}
+module "label" {
+  source  = "cloudposse/label/null"
+  version = "0.25.0"
+}`,
+		"modules/subnets/main.tf": `resource "aws_subnet" "this" {}`,
+		"modules/README.md":       "not a module",
+		"examples/simple/main.tf": `module "vpc" { source = "../.." }`,
+	})
+
+	inspection, err := Inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "", inspection.Root.Path)
+	assert.Equal(t, []service.ProviderDependency{
+		{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws", Version: ">= 4.0"},
+		{Name: "random", Namespace: "hashicorp", Source: "hashicorp/random", Version: ""},
+		{Name: "template", Namespace: "hashicorp", Source: "hashicorp/template", Version: ""},
+	}, inspection.Root.Providers)
+	assert.Equal(t, []service.ModuleDependency{
+		{Name: "label", Source: "cloudposse/label/null", Version: "0.25.0"},
+		{Name: "subnets", Source: "./modules/subnets", Version: ""},
+	}, inspection.Root.Dependencies)
+
+	assert.Equal(t, []service.ModuleSpec{
+		{
+			Path:         "modules/subnets",
+			Inputs:       []service.Input{},
+			Outputs:      []service.Output{},
+			Resources:    []service.Resource{{Name: "this", Type: "aws_subnet"}},
+			Providers:    []service.ProviderDependency{{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws"}},
+			Dependencies: []service.ModuleDependency{},
+		},
+	}, inspection.Submodules)
+
+	assert.Equal(t, []service.ModuleSpec{
+		{
+			Path:         "examples/simple",
+			Inputs:       []service.Input{},
+			Outputs:      []service.Output{},
+			Resources:    []service.Resource{},
+			Providers:    []service.ProviderDependency{},
+			Dependencies: []service.ModuleDependency{{Name: "vpc", Source: "../.."}},
+		},
+	}, inspection.Examples)
+}
+
+func TestInspectExtractsInterface(t *testing.T) {
+	data := tft.ModuleZip(t, map[string]string{
+		"variables.tf": `
+variable "name" {
+  type        = string
+  description = "Name of the VPC"
+}
+variable "cidr" {
+  type    = string
+  default = "10.0.0.0/16"
+}
+variable "tags" {
+  type    = map(string)
+  default = {}
+}
+variable "password" {
+  type      = string
+  sensitive = true
+}`,
+		"outputs.tf": `
+output "vpc_id" {
+  description = "ID of the VPC"
+  value       = aws_vpc.this.id
+}
+output "secret" {
+  value     = var.password
+  sensitive = true
+}`,
+		"main.tf": `
+resource "aws_vpc" "this" {}
+resource "aws_internet_gateway" "this" {}
+data "aws_region" "current" {}`,
+		"README.md":               "# VPC\n\nCreates a VPC.\n",
+		"modules/empty/main.tf":   "locals {}",
+		"modules/empty/readme.md": "Nothing to see",
+	})
+
+	inspection, err := Inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []service.Input{
+		{Name: "cidr", Type: "string", Default: `"10.0.0.0/16"`},
+		{Name: "name", Type: "string", Description: "Name of the VPC", Required: true},
+		{Name: "password", Type: "string", Required: true, Sensitive: true},
+		{Name: "tags", Type: "map(string)", Default: "{}"},
+	}, inspection.Root.Inputs)
+	assert.Equal(t, []service.Output{
+		{Name: "secret", Sensitive: true},
+		{Name: "vpc_id", Description: "ID of the VPC"},
+	}, inspection.Root.Outputs)
+	assert.Equal(t, []service.Resource{
+		{Name: "this", Type: "aws_internet_gateway"},
+		{Name: "this", Type: "aws_vpc"},
+	}, inspection.Root.Resources)
+	assert.Equal(t, "# VPC\n\nCreates a VPC.\n", inspection.Root.Readme)
+	assert.Equal(t, "Creates a VPC.", inspection.Description)
+	assert.False(t, inspection.Root.Empty)
+
+	if assert.Len(t, inspection.Submodules, 1) {
+		assert.True(t, inspection.Submodules[0].Empty)
+		assert.Equal(t, "Nothing to see", inspection.Submodules[0].Readme)
+	}
+	assert.Equal(t, []service.ModuleSpec{}, inspection.Examples)
+}
+
+func TestInspectRejectsArchiveWithoutTerraformFiles(t *testing.T) {
+	_, err := Inspect(tft.ModuleZip(t, map[string]string{"README.md": "# Nothing"}))
+	assert.Error(t, err)
+}
+
+func TestInspectRejectsInvalidTerraform(t *testing.T) {
+	_, err := Inspect(tft.ModuleZip(t, map[string]string{"main.tf": `resource "aws_vpc" {`}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// submodulesDir and examplesDir are where the registry looks for submodules and examples, following the standard module structure
+const (
+	submodulesDir = "modules"
+	examplesDir   = "examples"
+)
+
+// loadModule parses the terraform files in dir of the archive
+func loadModule(fsys fs.FS, dir string) (*tfconfig.Module, error) {
+	module, diags := tfconfig.LoadModuleFromFilesystem(tfconfig.WrapFS(fsys), dir)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse module %s: %w", dir, diags.Err())
+	}
+	return module, nil
+}
+
+// moduleDirs returns the directories below parent that contain terraform files
+func moduleDirs(fsys fs.FS, parent string) []string {
+	dirs := []string{}
+	// archives without the directory simply have no such modules
+	entries, _ := fs.ReadDir(fsys, parent)
+	for _, entry := range entries {
+		dir := path.Join(parent, entry.Name())
+		if entry.IsDir() && tfconfig.IsModuleDirOnFilesystem(tfconfig.WrapFS(fsys), dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// moduleSpec lists the interface, resources, providers and module dependencies of a parsed module
+func moduleSpec(dir string, module *tfconfig.Module) (service.ModuleSpec, error) {
+	spec := service.ModuleSpec{
+		Inputs:       []service.Input{},
+		Outputs:      []service.Output{},
+		Resources:    []service.Resource{},
+		Providers:    []service.ProviderDependency{},
+		Dependencies: []service.ModuleDependency{},
+	}
+	if dir != "." {
+		spec.Path = dir
+	}
+
+	for _, variable := range module.Variables {
+		input, err := moduleInput(variable)
+		if err != nil {
+			return spec, fmt.Errorf("failed to read variable %s of module %s: %w", variable.Name, dir, err)
+		}
+		spec.Inputs = append(spec.Inputs, input)
+	}
+	sort.Slice(spec.Inputs, func(i, j int) bool {
+		return spec.Inputs[i].Name < spec.Inputs[j].Name
+	})
+
+	for _, output := range module.Outputs {
+		spec.Outputs = append(spec.Outputs, service.Output{
+			Name:        output.Name,
+			Description: output.Description,
+			Sensitive:   output.Sensitive,
+		})
+	}
+	sort.Slice(spec.Outputs, func(i, j int) bool {
+		return spec.Outputs[i].Name < spec.Outputs[j].Name
+	})
+
+	for _, resource := range module.ManagedResources {
+		spec.Resources = append(spec.Resources, service.Resource{
+			Name: resource.Name,
+			Type: resource.Type,
+		})
+	}
+	sort.Slice(spec.Resources, func(i, j int) bool {
+		if spec.Resources[i].Type != spec.Resources[j].Type {
+			return spec.Resources[i].Type < spec.Resources[j].Type
+		}
+		return spec.Resources[i].Name < spec.Resources[j].Name
+	})
+
+	providers := map[string]service.ProviderDependency{}
+	for name, requirement := range module.RequiredProviders {
+		providers[name] = providerDependency(name, requirement)
+	}
+	// providers only referenced by resources are implicitly required from the hashicorp namespace
+	for _, resources := range []map[string]*tfconfig.Resource{module.ManagedResources, module.DataResources} {
+		for _, resource := range resources {
+			if _, ok := providers[resource.Provider.Name]; !ok {
+				providers[resource.Provider.Name] = providerDependency(resource.Provider.Name, &tfconfig.ProviderRequirement{})
+			}
+		}
+	}
+	for _, provider := range providers {
+		spec.Providers = append(spec.Providers, provider)
+	}
+	sort.Slice(spec.Providers, func(i, j int) bool {
+		return spec.Providers[i].Name < spec.Providers[j].Name
+	})
+
+	for _, call := range module.ModuleCalls {
+		spec.Dependencies = append(spec.Dependencies, service.ModuleDependency{
+			Name:    call.Name,
+			Source:  call.Source,
+			Version: call.Version,
+		})
+	}
+	sort.Slice(spec.Dependencies, func(i, j int) bool {
+		return spec.Dependencies[i].Name < spec.Dependencies[j].Name
+	})
+
+	// like the public registry a module without any of these has nothing to offer to its callers
+	spec.Empty = len(spec.Inputs) == 0 && len(spec.Outputs) == 0 && len(spec.Resources) == 0 &&
+		len(module.DataResources) == 0 && len(spec.Dependencies) == 0
+	return spec, nil
+}
+
+// moduleInput converts a variable, defaults are stored json encoded like the public registry does
+func moduleInput(variable *tfconfig.Variable) (service.Input, error) {
+	input := service.Input{
+		Name:        variable.Name,
+		Type:        variable.Type,
+		Description: variable.Description,
+		Required:    variable.Required,
+		Sensitive:   variable.Sensitive,
+	}
+	if !variable.Required {
+		value, err := json.Marshal(variable.Default)
+		if err != nil {
+			return input, err
+		}
+		input.Default = string(value)
+	}
+	return input, nil
+}
+
+func providerDependency(name string, requirement *tfconfig.ProviderRequirement) service.ProviderDependency {
+	source := requirement.Source
+	if source == "" {
+		source = "hashicorp/" + name
+	}
+	namespace := ""
+	if parts := strings.Split(source, "/"); len(parts) >= 2 {
+		namespace = parts[len(parts)-2]
+	}
+	return service.ProviderDependency{
+		Name:      name,
+		Namespace: namespace,
+		Source:    source,
+		Version:   strings.Join(requirement.VersionConstraints, ", "),
+	}
+}
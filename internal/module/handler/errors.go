@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// ErrorResponse is the error body defined by the registry protocol
+type ErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+var serviceErrorStatus = []struct {
+	err    error
+	status int
+}{
+	{service.ErrNotFound, http.StatusNotFound},
+	{service.ErrAlreadyExists, http.StatusConflict},
+	{service.ErrInvalidVersion, http.StatusBadRequest},
+	{service.ErrInvalidArchive, http.StatusBadRequest},
+	{service.ErrInvalidModule, http.StatusBadRequest},
+	{service.ErrInvalidBinding, http.StatusBadRequest},
+	{service.ErrInvalidRelease, http.StatusBadRequest},
+	{service.ErrForbidden, http.StatusForbidden},
+	{service.ErrBackendUnavailable, http.StatusServiceUnavailable},
+}
+
+// serviceError translates an error of the ModuleService into a http error, unknown errors are logged and hidden behind a 500
+func serviceError(c echo.Context, err error) error {
+	for _, mapping := range serviceErrorStatus {
+		if !errors.Is(err, mapping.err) {
+			continue
+		}
+		// details of server side failures stay in the log
+		if mapping.status >= http.StatusInternalServerError {
+			c.Logger().Warn(err)
+			return echo.NewHTTPError(mapping.status).SetInternal(err)
+		}
+		c.Logger().Info(err)
+		return echo.NewHTTPError(mapping.status, err.Error()).SetInternal(err)
+	}
+	c.Logger().Warn(err)
+	return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+}
+
+// ErrorHandler renders errors with the {"errors": [...]} body terraform expects
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	// errors of the services that handlers return as they are get the status of the service error
+	he := &echo.HTTPError{}
+	if !errors.As(err, &he) {
+		errors.As(serviceError(c, err), &he)
+	}
+
+	message := http.StatusText(he.Code)
+	if m, ok := he.Message.(string); ok && m != "" {
+		message = m
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(he.Code)
+	} else {
+		err = c.JSON(he.Code, ErrorResponse{Errors: []string{message}})
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kinbiko/jsonassert"
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/module/service"
+	tfv "github.com/mxab/tf-registry/internal/validator"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingModuleService returns err from every method
+type failingModuleService struct {
+	err error
+}
+
+func (f *failingModuleService) Search(service.SearchParams) (service.ModuleResult, error) {
+	return service.ModuleResult{}, f.err
+}
+func (f *failingModuleService) List(service.ListParams) (service.ModuleResult, error) {
+	return service.ModuleResult{}, f.err
+}
+func (f *failingModuleService) Versions(service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	return nil, f.err
+}
+func (f *failingModuleService) Get(service.ModuleDescriptor, string) (service.ModuleDetails, error) {
+	return service.ModuleDetails{}, f.err
+}
+func (f *failingModuleService) DownloadUrl(service.ModuleDescriptor, string) (string, error) {
+	return "", f.err
+}
+func (f *failingModuleService) UploadModule(service.ModuleDescriptor, string, io.Reader, service.UploadOptions) error {
+	return f.err
+}
+
+func TestServiceErrorStatus(t *testing.T) {
+	table := []struct {
+		err             error
+		expectedCode    int
+		expectedMessage string
+	}{
+		{fmt.Errorf("module a/b/c 1.0.0: %w", service.ErrNotFound), http.StatusNotFound, "module a/b/c 1.0.0: not found"},
+		{fmt.Errorf("module a/b/c 1.0.0: %w", service.ErrAlreadyExists), http.StatusConflict, "module a/b/c 1.0.0: already exists"},
+		{fmt.Errorf("%w: foo", service.ErrInvalidVersion), http.StatusBadRequest, "invalid version: foo"},
+		{fmt.Errorf("%w: not a zip", service.ErrInvalidArchive), http.StatusBadRequest, "invalid module archive: not a zip"},
+		{fmt.Errorf("%w: \"..\"", service.ErrInvalidModule), http.StatusBadRequest, "invalid module address: \"..\""},
+		{fmt.Errorf("provider a/b 1.0.0: %w: no packages", service.ErrInvalidRelease), http.StatusBadRequest, "provider a/b 1.0.0: invalid provider release: no packages"},
+		{service.ErrForbidden, http.StatusForbidden, "forbidden"},
+		{fmt.Errorf("%w: connection refused", service.ErrBackendUnavailable), http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)},
+		{errors.New("boom"), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)},
+	}
+
+	for _, test := range table {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			e := echo.New()
+			e.Validator = tfv.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/v1/modules/:namespace/:name/:system/:version/download")
+			c.SetParamNames("namespace", "name", "system", "version")
+			c.SetParamValues("a", "b", "c", "1.0.0")
+
+			controller := &Controller{
+				ModuleService: &failingModuleService{err: test.err},
+			}
+
+			err := controller.DownloadModule(c)
+			he := &echo.HTTPError{}
+			if assert.ErrorAs(t, err, &he) {
+				assert.Equal(t, test.expectedCode, he.Code)
+				assert.Equal(t, test.expectedMessage, he.Message)
+				assert.ErrorIs(t, he, test.err)
+			}
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	ErrorHandler(echo.NewHTTPError(http.StatusNotFound, "module a/b/c: not found"), c)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["module a/b/c: not found"]}`)
+}
+
+func TestErrorHandlerUnknownError(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	ErrorHandler(errors.New("boom"), c)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["Internal Server Error"]}`)
+}
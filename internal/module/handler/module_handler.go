@@ -1,9 +1,16 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/mxab/tf-registry/internal/auth"
 	"github.com/mxab/tf-registry/internal/module/service"
 	"github.com/samber/lo"
 )
@@ -15,6 +22,7 @@ type (
 		Offset    int    `query:"offset" validate:"gte=0"`
 		Provider  string `query:"provider"`
 		Namespace string `param:"namespace"`
+		Verified  bool   `query:"verified"`
 	}
 	SearchRequest struct {
 		Limit     int    `query:"limit" validate:"gte=0,lte=100"`
@@ -22,12 +30,30 @@ type (
 		Query     string `query:"q" validate:"required"`
 		Namespace string `param:"namespace"`
 		Provider  string `query:"provider"`
+		Verified  bool   `query:"verified"`
 	}
 	ListModuleVersionsRequest struct {
 		Namespace string `param:"namespace"`
 		Name      string `param:"name"`
 		System    string `param:"system"`
 	}
+	ModuleRequest struct {
+		Namespace string `param:"namespace"`
+		Name      string `param:"name"`
+		System    string `param:"system"`
+	}
+	LatestModulesRequest struct {
+		Limit     int    `query:"limit" validate:"gte=0,lte=100"`
+		Offset    int    `query:"offset" validate:"gte=0"`
+		Namespace string `param:"namespace"`
+		Name      string `param:"name"`
+	}
+	GetModuleRequest struct {
+		Namespace string `param:"namespace"`
+		Name      string `param:"name"`
+		System    string `param:"system"`
+		Version   string `param:"version"`
+	}
 	DownloadModuleRequest struct {
 		Namespace string `param:"namespace"`
 		Name      string `param:"name"`
@@ -39,6 +65,7 @@ type (
 		Name      string `param:"name"`
 		System    string `param:"system"`
 		Version   string `param:"version"`
+		Force     bool   `query:"force"`
 	}
 	Module struct {
 		Id          string `json:"id"`
@@ -50,6 +77,8 @@ type (
 		Description string `json:"description"`
 		Source      string `json:"source"`
 		PublishedAt string `json:"published_at"`
+		Downloads   int    `json:"downloads"`
+		Verified    bool   `json:"verified"`
 	}
 	ModuleResultMeta struct {
 		Limit         int  `json:"limit"`
@@ -66,16 +95,92 @@ type (
 		Modules []ModuleVersions `json:"modules"`
 	}
 	ModuleVersions struct {
+		Source   string          `json:"source"`
 		Versions []ModuleVersion `json:"versions"`
 	}
 	ModuleVersion struct {
-		Version string `json:"version"`
+		Version    string        `json:"version"`
+		Root       VersionSpec   `json:"root"`
+		Submodules []VersionSpec `json:"submodules"`
+	}
+	// VersionSpec is the summary of a module listed with its versions
+	VersionSpec struct {
+		Path         string                       `json:"path,omitempty"`
+		Providers    []service.ProviderDependency `json:"providers"`
+		Dependencies []service.ModuleDependency   `json:"dependencies"`
+	}
+	ModuleDetails struct {
+		Module
+		Root       ModuleSpec   `json:"root"`
+		Submodules []ModuleSpec `json:"submodules"`
+		Examples   []ModuleSpec `json:"examples"`
+		Providers  []string     `json:"providers"`
+		Versions   []string     `json:"versions"`
+	}
+	// ModuleSpec is the interface of the root module, a submodule or an example
+	ModuleSpec struct {
+		Path                 string                       `json:"path"`
+		Name                 string                       `json:"name"`
+		Readme               string                       `json:"readme"`
+		Empty                bool                         `json:"empty"`
+		Inputs               []service.Input              `json:"inputs"`
+		Outputs              []service.Output             `json:"outputs"`
+		Dependencies         []service.ModuleDependency   `json:"dependencies"`
+		ProviderDependencies []service.ProviderDependency `json:"provider_dependencies"`
+		Resources            []service.Resource           `json:"resources"`
 	}
 	Controller struct {
 		ModuleService service.ModuleService
+		// IsAdmin decides if a request may use admin only features like forced uploads, nil means nobody may
+		IsAdmin func(c echo.Context) bool
+		// Authorizer decides what a request may do in a namespace, nil allows everything
+		Authorizer Authorizer
+	}
+	// Authorizer decides per namespace what the caller of a request may do
+	Authorizer interface {
+		CanRead(c echo.Context, namespace string) (bool, error)
+		CanPublish(c echo.Context, namespace string) (bool, error)
+		CanManage(c echo.Context, namespace string) (bool, error)
+		// HiddenNamespaces are left out of the lists and searches of the request
+		HiddenNamespaces(c echo.Context) ([]string, error)
 	}
 )
 
+// authorizeNamespace checks the namespace of the requested module, modules of namespaces the caller may not read are not found
+func (ctrl *Controller) authorizeNamespace(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		namespace := c.Param("namespace")
+		if ctrl.Authorizer == nil || namespace == "" {
+			return next(c)
+		}
+		allowed, err := ctrl.Authorizer.CanRead(c, namespace)
+		if err != nil {
+			return serviceError(c, err)
+		}
+		if !allowed {
+			return serviceError(c, fmt.Errorf("namespace %s: %w", namespace, service.ErrNotFound))
+		}
+		if c.Request().Method != http.MethodPost {
+			return next(c)
+		}
+		if allowed, err = ctrl.Authorizer.CanPublish(c, namespace); err != nil {
+			return serviceError(c, err)
+		}
+		if !allowed {
+			return serviceError(c, fmt.Errorf("publishing to namespace %s requires the publisher role: %w", namespace, service.ErrForbidden))
+		}
+		return next(c)
+	}
+}
+
+// hiddenNamespaces returns the namespaces whose modules the caller may not see
+func (ctrl *Controller) hiddenNamespaces(c echo.Context) ([]string, error) {
+	if ctrl.Authorizer == nil {
+		return nil, nil
+	}
+	return ctrl.Authorizer.HiddenNamespaces(c)
+}
+
 func (ctrl *Controller) ListModules(c echo.Context) (err error) {
 
 	listRequest := &ListRequest{
@@ -90,15 +195,20 @@ func (ctrl *Controller) ListModules(c echo.Context) (err error) {
 	if err = c.Validate(listRequest); err != nil {
 		return err
 	}
+	hidden, err := ctrl.hiddenNamespaces(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
 	data, err := ctrl.ModuleService.List(service.ListParams{
-		Limit:     listRequest.Limit,
-		Offset:    listRequest.Offset,
-		Provider:  listRequest.Provider,
-		Namespace: listRequest.Namespace,
+		Limit:              listRequest.Limit,
+		Offset:             listRequest.Offset,
+		Provider:           listRequest.Provider,
+		Namespace:          listRequest.Namespace,
+		Verified:           listRequest.Verified,
+		ExcludedNamespaces: hidden,
 	})
 	if err != nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+		return serviceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, ModuleResult{
@@ -123,15 +233,20 @@ func (ctrl *Controller) SearchModules(c echo.Context) (err error) {
 		return err
 	}
 
+	hidden, err := ctrl.hiddenNamespaces(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
 	data, err := ctrl.ModuleService.Search(service.SearchParams{Query: searchRequest.Query,
-		Limit:     searchRequest.Limit,
-		Offset:    searchRequest.Offset,
-		Provider:  searchRequest.Provider,
-		Namespace: searchRequest.Namespace,
+		Limit:              searchRequest.Limit,
+		Offset:             searchRequest.Offset,
+		Provider:           searchRequest.Provider,
+		Namespace:          searchRequest.Namespace,
+		Verified:           searchRequest.Verified,
+		ExcludedNamespaces: hidden,
 	})
 	if err != nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+		return serviceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, ModuleResult{
@@ -165,6 +280,8 @@ func convertModule(m service.Module, _ int) Module {
 		Description: m.Description,
 		Source:      m.Source,
 		PublishedAt: m.PublishedAt,
+		Downloads:   m.Downloads,
+		Verified:    m.Verified,
 	}
 }
 func (ctrl *Controller) ListModuleVersions(c echo.Context) (err error) {
@@ -183,22 +300,219 @@ func (ctrl *Controller) ListModuleVersions(c echo.Context) (err error) {
 		System:    request.System,
 	})
 	if err != nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+		return serviceError(c, err)
 	}
 	return c.JSON(http.StatusOK, ModuleVersionsResponse{
 		Modules: []ModuleVersions{
 			{
-				Versions: lo.Map(result, func(v string, _ int) ModuleVersion {
-					return ModuleVersion{
-						Version: v,
-					}
-				}),
+				Source:   fmt.Sprintf("%s/%s/%s", request.Namespace, request.Name, request.System),
+				Versions: lo.Map(result, convertModuleVersion),
 			},
 		},
 	})
 }
 
+func convertModuleVersion(v service.ModuleVersion, _ int) ModuleVersion {
+	return ModuleVersion{
+		Version:    v.Version,
+		Root:       convertVersionSpec(v.Root, 0),
+		Submodules: lo.Map(nonNil(v.Submodules), convertVersionSpec),
+	}
+}
+
+func convertVersionSpec(spec service.ModuleSpec, _ int) VersionSpec {
+	return VersionSpec{
+		Path:         spec.Path,
+		Providers:    nonNil(spec.Providers),
+		Dependencies: nonNil(spec.Dependencies),
+	}
+}
+
+// nonNil makes sure missing lists, e.g. of metadata written by older registry versions, are rendered as empty lists
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// GetModule returns a single version of a module with its interface
+func (ctrl *Controller) GetModule(c echo.Context) (err error) {
+	request := new(GetModuleRequest)
+
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+
+	// accept v prefixed versions, unknown formats are left to the service to not find
+	if version, err := service.NormalizeVersion(request.Version); err == nil {
+		request.Version = version
+	}
+
+	descriptor := service.ModuleDescriptor{
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		System:    request.System,
+	}
+	versions, err := ctrl.versions(descriptor)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	details, err := ctrl.moduleDetails(descriptor, request.Version, versions)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, details)
+}
+
+// GetLatestModule returns the latest version of a module for one provider
+func (ctrl *Controller) GetLatestModule(c echo.Context) (err error) {
+	request := new(ModuleRequest)
+
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+	descriptor := service.ModuleDescriptor{
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		System:    request.System,
+	}
+	versions, err := ctrl.versions(descriptor)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	latest, _ := service.LatestVersion(versions)
+
+	details, err := ctrl.moduleDetails(descriptor, latest, versions)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, details)
+}
+
+// moduleDetails loads a version of a module together with its other providers and versions
+func (ctrl *Controller) moduleDetails(descriptor service.ModuleDescriptor, version string, versions []string) (ModuleDetails, error) {
+	details, err := ctrl.ModuleService.Get(descriptor, version)
+	if err != nil {
+		return ModuleDetails{}, err
+	}
+	providers, err := ctrl.ModuleService.List(service.ListParams{
+		Limit:     service.MaxLimit,
+		Namespace: descriptor.Namespace,
+		Name:      descriptor.Name,
+	})
+	if err != nil {
+		return ModuleDetails{}, err
+	}
+	return convertModuleDetails(details, providers.Modules, versions), nil
+}
+
+// ListLatestModules returns the latest version of a module for every provider
+func (ctrl *Controller) ListLatestModules(c echo.Context) (err error) {
+	request := &LatestModulesRequest{
+		Limit:  10,
+		Offset: 0,
+	}
+
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+	data, err := ctrl.ModuleService.List(service.ListParams{
+		Limit:     request.Limit,
+		Offset:    request.Offset,
+		Namespace: request.Namespace,
+		Name:      request.Name,
+	})
+	if err != nil {
+		return serviceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, ModuleResult{
+		Meta:    convertMeta(data.Meta),
+		Modules: lo.Map(data.Modules, convertModule),
+	})
+}
+
+// DownloadLatestModule redirects to the download of the latest version
+func (ctrl *Controller) DownloadLatestModule(c echo.Context) (err error) {
+	request := new(ModuleRequest)
+
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+	versions, err := ctrl.versions(service.ModuleDescriptor{
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		System:    request.System,
+	})
+	if err != nil {
+		return serviceError(c, err)
+	}
+	latest, _ := service.LatestVersion(versions)
+
+	base := strings.TrimSuffix(c.Request().URL.Path, "/download")
+	return c.Redirect(http.StatusFound, fmt.Sprintf("%s/%s/download", base, url.PathEscape(latest)))
+}
+
+// versions lists the version numbers of a module
+func (ctrl *Controller) versions(descriptor service.ModuleDescriptor) ([]string, error) {
+	versions, err := ctrl.ModuleService.Versions(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("module %s/%s/%s: %w", descriptor.Namespace, descriptor.Name, descriptor.System, service.ErrNotFound)
+	}
+	return lo.Map(versions, func(v service.ModuleVersion, _ int) string {
+		return v.Version
+	}), nil
+}
+
+func convertModuleDetails(details service.ModuleDetails, providers []service.Module, versions []string) ModuleDetails {
+	root := convertModuleSpec(details.Root, 0)
+	root.Name = details.Module.Name
+	return ModuleDetails{
+		Module:     convertModule(details.Module, 0),
+		Root:       root,
+		Submodules: lo.Map(nonNil(details.Submodules), convertModuleSpec),
+		Examples:   lo.Map(nonNil(details.Examples), convertModuleSpec),
+		Providers: lo.Map(providers, func(m service.Module, _ int) string {
+			return m.Provider
+		}),
+		Versions: versions,
+	}
+}
+
+func convertModuleSpec(spec service.ModuleSpec, _ int) ModuleSpec {
+	name := ""
+	if spec.Path != "" {
+		name = path.Base(spec.Path)
+	}
+	return ModuleSpec{
+		Path:                 spec.Path,
+		Name:                 name,
+		Readme:               spec.Readme,
+		Empty:                spec.Empty,
+		Inputs:               nonNil(spec.Inputs),
+		Outputs:              nonNil(spec.Outputs),
+		Dependencies:         nonNil(spec.Dependencies),
+		ProviderDependencies: nonNil(spec.Providers),
+		Resources:            nonNil(spec.Resources),
+	}
+}
+
 // DownloadModule
 func (ctrl *Controller) DownloadModule(c echo.Context) (err error) {
 	request := new(DownloadModuleRequest)
@@ -210,19 +524,54 @@ func (ctrl *Controller) DownloadModule(c echo.Context) (err error) {
 		return err
 	}
 
+	// accept v prefixed versions, unknown formats are left to the service to not find
+	if version, err := service.NormalizeVersion(request.Version); err == nil {
+		request.Version = version
+	}
+
 	url, err := ctrl.ModuleService.DownloadUrl(service.ModuleDescriptor{
 		Namespace: request.Namespace,
 		Name:      request.Name,
 		System:    request.System,
 	}, request.Version)
 	if err != nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+		return serviceError(c, err)
 	}
 	c.Response().Header().Set("X-Terraform-Get", url)
 	return c.NoContent(http.StatusNoContent)
 }
 
+// DownloadArchive serves the archive of module services that do not store it behind a url of their own
+func (ctrl *Controller) DownloadArchive(c echo.Context) (err error) {
+	store, ok := ctrl.ModuleService.(service.ArchiveStore)
+	if !ok {
+		return echo.ErrNotFound
+	}
+	request := new(DownloadModuleRequest)
+
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+	if version, err := service.NormalizeVersion(request.Version); err == nil {
+		request.Version = version
+	}
+
+	archive, modTime, err := store.OpenArchive(service.ModuleDescriptor{
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		System:    request.System,
+	}, request.Version)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer archive.Close()
+	http.ServeContent(c.Response(), c.Request(), "module.zip", modTime, archive)
+	return nil
+}
+
 func (ctrl *Controller) UploadModule(c echo.Context) (err error) {
 	request := new(UploadModuleRequest)
 
@@ -230,31 +579,68 @@ func (ctrl *Controller) UploadModule(c echo.Context) (err error) {
 	request.Namespace = c.Param("namespace")
 	request.Name = c.Param("name")
 	request.System = c.Param("system")
+	if force := c.QueryParam("force"); force != "" {
+		if request.Force, err = strconv.ParseBool(force); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "force must be a boolean")
+		}
+	}
 
 	if err = c.Validate(request); err != nil {
 		return err
 	}
+	if request.Version, err = service.NormalizeVersion(request.Version); err != nil {
+		return serviceError(c, err)
+	}
+
+	options := service.UploadOptions{}
+	// the publisher becomes the owner of the module version
+	if identity, ok := auth.IdentityFrom(c); ok {
+		options.Publisher = identity.Subject
+	}
+	if request.Force {
+		if ctrl.IsAdmin == nil || !ctrl.IsAdmin(c) {
+			return serviceError(c, fmt.Errorf("overwriting a published version requires admin permissions: %w", service.ErrForbidden))
+		}
+		options.Force = true
+		if options.Publisher == "" {
+			options.Publisher = "admin"
+		}
+	}
 
 	req := c.Request()
 	if req.Body == nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+		return echo.NewHTTPError(http.StatusBadRequest, "missing module archive")
 	}
 	if err = ctrl.ModuleService.UploadModule(service.ModuleDescriptor{
 		Namespace: request.Namespace,
 		Name:      request.Name,
 		System:    request.System,
-	}, request.Version, req.Body); err != nil {
-		c.Logger().Warn(err)
-		return echo.ErrInternalServerError
+	}, request.Version, req.Body, options); err != nil {
+		return serviceError(c, err)
+	}
+	if options.Force {
+		c.Logger().Warnj(log.JSON{
+			"audit":     "module_version_overwritten",
+			"module":    fmt.Sprintf("%s/%s/%s", request.Namespace, request.Name, request.System),
+			"version":   request.Version,
+			"by":        options.Publisher,
+			"remote_ip": c.RealIP(),
+		})
 	}
 	return c.NoContent(http.StatusNoContent)
 }
-func RegisterModuleControllerGroup(g *echo.Group, moduleService service.ModuleService) {
-	ctrl := &Controller{ModuleService: moduleService}
+
+func RegisterModuleControllerGroup(g *echo.Group, moduleService service.ModuleService, isAdmin func(c echo.Context) bool, authorizer Authorizer) {
+	ctrl := &Controller{ModuleService: moduleService, IsAdmin: isAdmin, Authorizer: authorizer}
+	g.Use(ctrl.authorizeNamespace)
 	g.GET("", ctrl.ListModules)
 	g.GET("/search", ctrl.SearchModules)
-	g.GET("/:namespace/:name/:system/:version", ctrl.ListModuleVersions)
+	g.GET("/:namespace/:name", ctrl.ListLatestModules)
+	g.GET("/:namespace/:name/:system", ctrl.GetLatestModule)
+	g.GET("/:namespace/:name/:system/download", ctrl.DownloadLatestModule)
+	g.GET("/:namespace/:name/:system/versions", ctrl.ListModuleVersions)
+	g.GET("/:namespace/:name/:system/:version", ctrl.GetModule)
 	g.GET("/:namespace/:name/:system/:version/download", ctrl.DownloadModule)
+	g.GET("/:namespace/:name/:system/:version/archive.zip", ctrl.DownloadArchive)
 	g.POST("/:namespace/:name/:system/:version/upload", ctrl.UploadModule)
 }
@@ -12,6 +12,8 @@ import (
 
 	"github.com/kinbiko/jsonassert"
 	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/mxab/tf-registry/internal/module/service"
 	tfv "github.com/mxab/tf-registry/internal/validator"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -62,6 +64,15 @@ func TestSearchModules(t *testing.T) {
 			expectedCode: http.StatusOK,
 			expectedJSON: buildExpectedModulesJson(t, map[string]any{"next_offset": "<<PRESENCE>>"}, map[string]any{"name": "network"}, map[string]any{"name": "network"}),
 		},
+		{
+			name: "only verified",
+			queryParams: map[string]string{
+				"q":        "network",
+				"verified": "true",
+			},
+			expectedCode: http.StatusOK,
+			expectedJSON: buildExpectedModulesJson(t, map[string]any{"next_offset": "<<PRESENCE>>"}),
+		},
 		{
 			name: "with limit",
 			queryParams: map[string]string{
@@ -123,7 +134,7 @@ func TestSearchModules(t *testing.T) {
 }
 
 func buildExpectedModulesJson(t *testing.T, meta map[string]any, modules ...map[string]any) string {
-	var defaultMapForModuleFields = map[string]string{
+	var defaultMapForModuleFields = map[string]any{
 		"id":           "<<PRESENCE>>",
 		"owner":        "",
 		"namespace":    "<<PRESENCE>>",
@@ -133,6 +144,8 @@ func buildExpectedModulesJson(t *testing.T, meta map[string]any, modules ...map[
 		"description":  "<<PRESENCE>>",
 		"source":       "<<PRESENCE>>",
 		"published_at": "<<PRESENCE>>",
+		"downloads":    0,
+		"verified":     false,
 	}
 
 	var defaultMapForMetaFields = map[string]string{
@@ -194,8 +207,13 @@ func TestListModuleVersions(t *testing.T) {
 		json, err := json.Marshal(map[string]any{
 			"modules": []any{
 				map[string]any{
-					"versions": []map[string]string{
-						{"version": "1.1.1"},
+					"source": "Azure/network/azurerm",
+					"versions": []map[string]any{
+						{
+							"version":    "1.1.1",
+							"root":       map[string]any{"providers": []any{}, "dependencies": []any{}},
+							"submodules": []any{},
+						},
 					},
 				},
 			},
@@ -238,7 +256,7 @@ func TestUploadModule(t *testing.T) {
 	req.Header.Set("Content-Type", "application/octet-stream")
 	c.SetPath("/v1/modules/:namespace/:name/:system/:version/upload")
 	c.SetParamNames("namespace", "name", "system", "version")
-	c.SetParamValues("Azure", "network", "azurerm", "1.1.1")
+	c.SetParamValues("Azure", "network", "azurerm", "1.2.0")
 
 	controller := &Controller{
 		ModuleService: tft.NewMockModuleService(),
@@ -249,3 +267,414 @@ func TestUploadModule(t *testing.T) {
 		assert.Equal(t, http.StatusNoContent, rec.Code)
 	}
 }
+
+func TestDownloadModuleNotFound(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version/download")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("Azure", "network", "azurerm", "9.9.9")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.DownloadModule(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
+
+func TestListModuleVersionsNotFound(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/versions")
+	c.SetParamNames("namespace", "name", "system")
+	c.SetParamValues("Azure", "unknown", "azurerm")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.ListModuleVersions(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
+
+func TestUploadModuleNormalizesVersion(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("test")))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version/upload")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("Azure", "network", "azurerm", "v1.2.0")
+
+	moduleService := tft.NewMockModuleService()
+	controller := &Controller{
+		ModuleService: moduleService,
+	}
+
+	// Assertions
+	if assert.NoError(t, controller.UploadModule(c)) {
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		versions, err := moduleService.Versions(service.ModuleDescriptor{Namespace: "Azure", Name: "network", System: "azurerm"})
+		assert.NoError(t, err)
+		assert.Equal(t, "1.2.0", versions[len(versions)-1].Version)
+	}
+}
+
+func TestUploadModuleRejectsInvalidVersion(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("test")))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version/upload")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("Azure", "network", "azurerm", "latest")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.UploadModule(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	}
+}
+
+func TestUploadModuleRejectsPublishedVersion(t *testing.T) {
+	table := []struct {
+		name         string
+		query        string
+		token        string
+		expectedCode int
+	}{
+		{name: "re-upload", query: "", token: "nope", expectedCode: http.StatusConflict},
+		{name: "force without token", query: "force=true", expectedCode: http.StatusUnauthorized},
+		{name: "force with publisher token", query: "force=true", token: "nope", expectedCode: http.StatusForbidden},
+		{name: "force with admin token", query: "force=true", token: "secret", expectedCode: http.StatusNoContent},
+		{name: "invalid force", query: "force=maybe", token: "secret", expectedCode: http.StatusBadRequest},
+	}
+
+	tokens, err := auth.NewStaticTokens([]auth.TokenEntry{{Subject: "ci", SHA256: auth.HashToken("nope"), Scopes: []auth.Scope{auth.ScopePublish}}})
+	require.NoError(t, err)
+	authenticate := auth.Middleware(auth.Config{Authenticator: auth.Authenticators{auth.AdminToken("secret"), tokens}})
+
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = tfv.New()
+			req := httptest.NewRequest(http.MethodPost, "/?"+test.query, bytes.NewReader([]byte("test")))
+			if test.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/v1/modules/:namespace/:name/:system/:version/upload")
+			c.SetParamNames("namespace", "name", "system", "version")
+			c.SetParamValues("Azure", "network", "azurerm", "1.1.1")
+
+			controller := &Controller{
+				ModuleService: tft.NewMockModuleService(),
+				IsAdmin:       auth.IsAdmin,
+			}
+
+			err := authenticate(controller.UploadModule)(c)
+			if test.expectedCode < 400 && assert.NoError(t, err) {
+				assert.Equal(t, test.expectedCode, rec.Code)
+			} else if test.expectedCode >= 400 && assert.Error(t, err) {
+				assert.Equal(t, test.expectedCode, err.(*echo.HTTPError).Code)
+			}
+		})
+	}
+}
+
+func TestGetLatestModule(t *testing.T) {
+	// Setup
+	ja := jsonassert.New(t)
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system")
+	c.SetParamNames("namespace", "name", "system")
+	c.SetParamValues("Azure", "network", "azurerm")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	if assert.NoError(t, controller.GetLatestModule(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		ja.Assertf(rec.Body.String(), `{
+			"id": "Azure/network/azurerm/1.1.1",
+			"owner": "",
+			"namespace": "Azure",
+			"name": "network",
+			"version": "1.1.1",
+			"provider": "azurerm",
+			"description": "Terraform Azure RM Module for Network",
+			"source": "https://github.com/Azure/terraform-azurerm-network",
+			"published_at": "2017-11-22T17:15:34.325436Z",
+			"downloads": 0,
+			"verified": false,
+			"root": {
+				"path": "",
+				"name": "network",
+				"readme": "",
+				"empty": false,
+				"inputs": [],
+				"outputs": [],
+				"dependencies": [],
+				"provider_dependencies": [],
+				"resources": []
+			},
+			"submodules": [],
+			"examples": [],
+			"providers": ["azurerm"],
+			"versions": ["1.1.1"]
+		}`)
+	}
+}
+
+func TestGetLatestModuleNotFound(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system")
+	c.SetParamNames("namespace", "name", "system")
+	c.SetParamValues("Azure", "unknown", "azurerm")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.GetLatestModule(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
+
+func TestListLatestModules(t *testing.T) {
+	// Setup
+	ja := jsonassert.New(t)
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name")
+	c.SetParamNames("namespace", "name")
+	c.SetParamValues("Azure", "network")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	if assert.NoError(t, controller.ListLatestModules(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		ja.Assertf(rec.Body.String(), buildExpectedModulesJson(t, map[string]any{"next_offset": "<<PRESENCE>>"}, map[string]any{"namespace": "Azure", "name": "network", "provider": "azurerm"}))
+	}
+}
+
+func TestDownloadLatestModule(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/Azure/network/azurerm/download", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/download")
+	c.SetParamNames("namespace", "name", "system")
+	c.SetParamValues("Azure", "network", "azurerm")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	if assert.NoError(t, controller.DownloadLatestModule(c)) {
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/v1/modules/Azure/network/azurerm/1.1.1/download", rec.Header().Get(echo.HeaderLocation))
+	}
+}
+
+func TestGetModule(t *testing.T) {
+	// Setup
+	ja := jsonassert.New(t)
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("zoitech", "network", "aws", "v0.0.3")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	if assert.NoError(t, controller.GetModule(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		ja.Assertf(rec.Body.String(), `{
+			"id": "zoitech/network/aws/0.0.3",
+			"owner": "",
+			"namespace": "zoitech",
+			"name": "network",
+			"version": "0.0.3",
+			"provider": "aws",
+			"description": "<<PRESENCE>>",
+			"source": "<<PRESENCE>>",
+			"published_at": "<<PRESENCE>>",
+			"downloads": 0,
+			"verified": false,
+			"root": "<<PRESENCE>>",
+			"submodules": [],
+			"examples": [],
+			"providers": ["aws"],
+			"versions": ["0.0.3"]
+		}`)
+	}
+}
+
+func TestGetModuleNotFound(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("zoitech", "network", "aws", "9.9.9")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.GetModule(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
+
+func TestConvertModuleDetails(t *testing.T) {
+	ja := jsonassert.New(t)
+	details := convertModuleDetails(service.ModuleDetails{
+		Module: service.Module{Id: "hashicorp/consul/aws/0.1.0", Namespace: "hashicorp", Name: "consul", Provider: "aws", Version: "0.1.0"},
+		Root: service.ModuleSpec{
+			Readme:    "# Consul",
+			Inputs:    []service.Input{{Name: "ami", Type: "string", Default: `""`}},
+			Outputs:   []service.Output{{Name: "asg_name", Description: "Name of the ASG"}},
+			Resources: []service.Resource{{Name: "servers", Type: "aws_autoscaling_group"}},
+			Providers: []service.ProviderDependency{{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws"}},
+		},
+		Submodules: []service.ModuleSpec{{Path: "modules/consul-cluster", Empty: true}},
+		Examples: []service.ModuleSpec{{
+			Path:         "examples/simple",
+			Dependencies: []service.ModuleDependency{{Name: "consul", Source: "../.."}},
+		}},
+	}, []service.Module{{Provider: "aws"}}, []string{"0.1.0"})
+
+	body, err := json.Marshal(details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja.Assertf(string(body), `{
+		"id": "hashicorp/consul/aws/0.1.0",
+		"owner": "",
+		"namespace": "hashicorp",
+		"name": "consul",
+		"version": "0.1.0",
+		"provider": "aws",
+		"description": "",
+		"source": "",
+		"published_at": "",
+		"downloads": 0,
+		"verified": false,
+		"root": {
+			"path": "",
+			"name": "consul",
+			"readme": "# Consul",
+			"empty": false,
+			"inputs": [{"name": "ami", "type": "string", "description": "", "default": "\"\"", "required": false}],
+			"outputs": [{"name": "asg_name", "description": "Name of the ASG"}],
+			"dependencies": [],
+			"provider_dependencies": [{"name": "aws", "namespace": "hashicorp", "source": "hashicorp/aws", "version": ""}],
+			"resources": [{"name": "servers", "type": "aws_autoscaling_group"}]
+		},
+		"submodules": [{
+			"path": "modules/consul-cluster",
+			"name": "consul-cluster",
+			"readme": "",
+			"empty": true,
+			"inputs": [],
+			"outputs": [],
+			"dependencies": [],
+			"provider_dependencies": [],
+			"resources": []
+		}],
+		"examples": [{
+			"path": "examples/simple",
+			"name": "simple",
+			"readme": "",
+			"empty": false,
+			"inputs": [],
+			"outputs": [],
+			"dependencies": [{"name": "consul", "source": "../..", "version": ""}],
+			"provider_dependencies": [],
+			"resources": []
+		}],
+		"providers": ["aws"],
+		"versions": ["0.1.0"]
+	}`)
+}
+
+func TestDownloadArchiveWithoutArchiveStore(t *testing.T) {
+	// Setup
+	e := echo.New()
+	e.Validator = tfv.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:system/:version/archive.zip")
+	c.SetParamNames("namespace", "name", "system", "version")
+	c.SetParamValues("Azure", "network", "azurerm", "1.1.1")
+
+	controller := &Controller{
+		ModuleService: tft.NewMockModuleService(),
+	}
+
+	// Assertions
+	err := controller.DownloadArchive(c)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*echo.HTTPError).Code)
+	}
+}
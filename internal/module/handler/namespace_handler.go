@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+type (
+	NamespaceRequest struct {
+		Namespace string `param:"namespace"`
+	}
+	// UpdateNamespaceRequest changes the settings that are set, the others keep their value
+	UpdateNamespaceRequest struct {
+		Namespace string `param:"namespace"`
+		Verified  *bool  `json:"verified"`
+		Private   *bool  `json:"private"`
+		Proxied   *bool  `json:"proxied"`
+	}
+	BindingsRequest struct {
+		Namespace string            `param:"namespace"`
+		Bindings  []service.Binding `json:"bindings"`
+	}
+	BindingRequest struct {
+		Namespace string       `param:"namespace"`
+		Role      service.Role `json:"role" param:"role"`
+		Member    string       `json:"member" param:"member"`
+	}
+	Namespace struct {
+		Name     string            `json:"name"`
+		Verified bool              `json:"verified"`
+		Private  bool              `json:"private"`
+		Proxied  bool              `json:"proxied"`
+		Bindings []service.Binding `json:"bindings"`
+	}
+	NamespaceList struct {
+		Namespaces []Namespace `json:"namespaces"`
+	}
+	BindingList struct {
+		Bindings []service.Binding `json:"bindings"`
+	}
+	// NamespaceController is the api for the settings of namespaces.
+	// Admins manage all namespaces, the owners of a namespace manage its privacy and bindings
+	NamespaceController struct {
+		Namespaces service.NamespaceStore
+		// IsAdmin decides if a request may manage namespaces, nil means nobody may
+		IsAdmin func(c echo.Context) bool
+		// Authorizer decides who manages a namespace besides admins, nil means nobody
+		Authorizer Authorizer
+	}
+)
+
+func (ctrl *NamespaceController) isAdmin(c echo.Context) bool {
+	return ctrl.IsAdmin != nil && ctrl.IsAdmin(c)
+}
+
+// requireAdmin rejects requests of non admins
+func (ctrl *NamespaceController) requireAdmin(c echo.Context, action string) error {
+	if !ctrl.isAdmin(c) {
+		return fmt.Errorf("%s requires admin permissions: %w", action, service.ErrForbidden)
+	}
+	return nil
+}
+
+// requireManager rejects requests of callers that are neither admin nor owner of the namespace
+func (ctrl *NamespaceController) requireManager(c echo.Context, namespace string) error {
+	if ctrl.isAdmin(c) {
+		return nil
+	}
+	if ctrl.Authorizer != nil {
+		allowed, err := ctrl.Authorizer.CanManage(c, namespace)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("managing namespace %s requires the owner role: %w", namespace, service.ErrForbidden)
+}
+
+func (ctrl *NamespaceController) ListNamespaces(c echo.Context) error {
+	if err := ctrl.requireAdmin(c, "listing namespaces"); err != nil {
+		return serviceError(c, err)
+	}
+	namespaces, err := ctrl.Namespaces.Namespaces()
+	if err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, NamespaceList{Namespaces: lo.Map(namespaces, convertNamespace)})
+}
+
+func (ctrl *NamespaceController) GetNamespace(c echo.Context) (err error) {
+	request := new(NamespaceRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = ctrl.requireManager(c, request.Namespace); err != nil {
+		return serviceError(c, err)
+	}
+	namespace, err := ctrl.Namespaces.Namespace(request.Namespace)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, convertNamespace(namespace, 0))
+}
+
+// UpdateNamespace changes the settings of a namespace, namespaces without modules are created so they can be set up in advance.
+// Only admins verify and proxy namespaces
+func (ctrl *NamespaceController) UpdateNamespace(c echo.Context) (err error) {
+	request := new(UpdateNamespaceRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = ctrl.requireManager(c, request.Namespace); err != nil {
+		return serviceError(c, err)
+	}
+	if request.Verified != nil {
+		if err = ctrl.requireAdmin(c, "verifying namespaces"); err != nil {
+			return serviceError(c, err)
+		}
+	}
+	if request.Proxied != nil {
+		if err = ctrl.requireAdmin(c, "proxying namespaces"); err != nil {
+			return serviceError(c, err)
+		}
+	}
+	namespace, err := ctrl.namespace(request.Namespace)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	if request.Verified != nil {
+		namespace.Verified = *request.Verified
+	}
+	if request.Private != nil {
+		namespace.Private = *request.Private
+	}
+	if request.Proxied != nil {
+		namespace.Proxied = *request.Proxied
+	}
+	if err = ctrl.put(c, namespace, "namespace_updated"); err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, convertNamespace(namespace, 0))
+}
+
+func (ctrl *NamespaceController) ListBindings(c echo.Context) (err error) {
+	request := new(NamespaceRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = ctrl.requireManager(c, request.Namespace); err != nil {
+		return serviceError(c, err)
+	}
+	namespace, err := ctrl.Namespaces.Namespace(request.Namespace)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, BindingList{Bindings: nonNil(namespace.Bindings)})
+}
+
+// ReplaceBindings sets all bindings of a namespace
+func (ctrl *NamespaceController) ReplaceBindings(c echo.Context) (err error) {
+	request := new(BindingsRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return ctrl.updateBindings(c, request.Namespace, func([]service.Binding) []service.Binding {
+		return request.Bindings
+	})
+}
+
+// AddBinding grants a role to a member
+func (ctrl *NamespaceController) AddBinding(c echo.Context) (err error) {
+	request := new(BindingRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	binding := service.Binding{Role: request.Role, Member: request.Member}
+	return ctrl.updateBindings(c, request.Namespace, func(bindings []service.Binding) []service.Binding {
+		if lo.Contains(bindings, binding) {
+			return bindings
+		}
+		return append(bindings, binding)
+	})
+}
+
+// RemoveBinding revokes a role from a member
+func (ctrl *NamespaceController) RemoveBinding(c echo.Context) (err error) {
+	request := new(BindingRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	binding := service.Binding{Role: request.Role, Member: request.Member}
+	return ctrl.updateBindings(c, request.Namespace, func(bindings []service.Binding) []service.Binding {
+		return lo.Without(bindings, binding)
+	})
+}
+
+// updateBindings changes the bindings of a namespace, owners cannot leave a namespace without owner as only admins could manage it then
+func (ctrl *NamespaceController) updateBindings(c echo.Context, name string, update func([]service.Binding) []service.Binding) error {
+	if err := ctrl.requireManager(c, name); err != nil {
+		return serviceError(c, err)
+	}
+	namespace, err := ctrl.namespace(name)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	namespace.Bindings = update(append([]service.Binding{}, namespace.Bindings...))
+	if err := service.ValidateBindings(namespace.Bindings); err != nil {
+		return serviceError(c, err)
+	}
+	hasOwner := lo.ContainsBy(namespace.Bindings, func(b service.Binding) bool { return b.Role == service.RoleOwner })
+	if !hasOwner && !ctrl.isAdmin(c) {
+		return serviceError(c, fmt.Errorf("namespace %s needs an owner: %w", name, service.ErrInvalidBinding))
+	}
+	if err := ctrl.put(c, namespace, "namespace_bindings_updated"); err != nil {
+		return serviceError(c, err)
+	}
+	return c.JSON(http.StatusOK, BindingList{Bindings: nonNil(namespace.Bindings)})
+}
+
+// namespace loads the settings of a namespace, new namespaces start without settings
+func (ctrl *NamespaceController) namespace(name string) (service.Namespace, error) {
+	namespace, err := ctrl.Namespaces.Namespace(name)
+	if errors.Is(err, service.ErrNotFound) {
+		return service.Namespace{Name: name}, nil
+	}
+	return namespace, err
+}
+
+// put stores the settings of a namespace and writes an audit log entry
+func (ctrl *NamespaceController) put(c echo.Context, namespace service.Namespace, audit string) error {
+	if err := ctrl.Namespaces.PutNamespace(namespace); err != nil {
+		return err
+	}
+	identity, _ := auth.IdentityFrom(c)
+	c.Logger().Infoj(log.JSON{
+		"audit":     audit,
+		"namespace": namespace.Name,
+		"verified":  namespace.Verified,
+		"private":   namespace.Private,
+		"proxied":   namespace.Proxied,
+		"bindings":  namespace.Bindings,
+		"by":        identity.Subject,
+		"remote_ip": c.RealIP(),
+	})
+	return nil
+}
+
+func convertNamespace(namespace service.Namespace, _ int) Namespace {
+	return Namespace{
+		Name:     namespace.Name,
+		Verified: namespace.Verified,
+		Private:  namespace.Private,
+		Proxied:  namespace.Proxied,
+		Bindings: nonNil(namespace.Bindings),
+	}
+}
+
+func RegisterNamespaceControllerGroup(g *echo.Group, namespaces service.NamespaceStore, isAdmin func(c echo.Context) bool, authorizer Authorizer) {
+	ctrl := &NamespaceController{Namespaces: namespaces, IsAdmin: isAdmin, Authorizer: authorizer}
+	g.GET("", ctrl.ListNamespaces)
+	g.GET("/:namespace", ctrl.GetNamespace)
+	g.PATCH("/:namespace", ctrl.UpdateNamespace)
+	g.GET("/:namespace/bindings", ctrl.ListBindings)
+	g.PUT("/:namespace/bindings", ctrl.ReplaceBindings)
+	g.POST("/:namespace/bindings", ctrl.AddBinding)
+	g.DELETE("/:namespace/bindings/:role/:member", ctrl.RemoveBinding)
+}
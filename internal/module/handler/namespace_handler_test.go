@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kinbiko/jsonassert"
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryNamespaces map[string]service.Namespace
+
+func (m memoryNamespaces) Namespaces() ([]service.Namespace, error) {
+	namespaces := []service.Namespace{}
+	for _, ns := range m {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces, nil
+}
+
+func (m memoryNamespaces) Namespace(name string) (service.Namespace, error) {
+	ns, ok := m[name]
+	if !ok {
+		return service.Namespace{}, fmt.Errorf("namespace %s: %w", name, service.ErrNotFound)
+	}
+	return ns, nil
+}
+
+func (m memoryNamespaces) PutNamespace(ns service.Namespace) error {
+	m[ns.Name] = ns
+	return nil
+}
+
+// newNamespaceServer accepts the admin token secret and the publisher token nope
+func newNamespaceServer(t *testing.T, namespaces service.NamespaceStore) *echo.Echo {
+	tokens, err := auth.NewStaticTokens([]auth.TokenEntry{{Subject: "ci", SHA256: auth.HashToken("nope"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}}})
+	assert.NoError(t, err)
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	g := e.Group("/v1/admin/namespaces", auth.Middleware(auth.Config{Authenticator: auth.Authenticators{auth.AdminToken("secret"), tokens}}))
+	RegisterNamespaceControllerGroup(g, namespaces, auth.IsAdmin, nil)
+	return e
+}
+
+func TestNamespacesRequireAdmin(t *testing.T) {
+	e := newNamespaceServer(t, memoryNamespaces{})
+
+	for token, expectedCode := range map[string]int{"": http.StatusUnauthorized, "nope": http.StatusForbidden} {
+		for _, method := range []string{http.MethodGet, http.MethodPatch} {
+			req := httptest.NewRequest(method, "/v1/admin/namespaces/hashicorp", strings.NewReader(`{"verified": true}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, expectedCode, rec.Code, method+" "+token)
+		}
+	}
+}
+
+func TestUpdateNamespace(t *testing.T) {
+	ja := jsonassert.New(t)
+	namespaces := memoryNamespaces{"Azure": {Name: "Azure"}}
+	e := newNamespaceServer(t, namespaces)
+
+	table := []struct {
+		namespace    string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{namespace: "Azure", body: `{"verified": true}`, expectedCode: http.StatusOK, expectedBody: `{"name": "Azure", "verified": true, "private": false, "proxied": false, "bindings": []}`},
+		{namespace: "Azure", body: `{}`, expectedCode: http.StatusOK, expectedBody: `{"name": "Azure", "verified": true, "private": false, "proxied": false, "bindings": []}`},
+		{namespace: "hashicorp", body: `{"verified": true}`, expectedCode: http.StatusOK, expectedBody: `{"name": "hashicorp", "verified": true, "private": false, "proxied": false, "bindings": []}`},
+		{namespace: "Azure", body: `{"verified": false}`, expectedCode: http.StatusOK, expectedBody: `{"name": "Azure", "verified": false, "private": false, "proxied": false, "bindings": []}`},
+		{namespace: "terraform-aws-modules", body: `{"proxied": true}`, expectedCode: http.StatusOK, expectedBody: `{"name": "terraform-aws-modules", "verified": false, "private": false, "proxied": true, "bindings": []}`},
+		{namespace: "Azure", body: `{"verified": "yes"}`, expectedCode: http.StatusBadRequest},
+	}
+	for _, test := range table {
+		req := httptest.NewRequest(http.MethodPatch, "/v1/admin/namespaces/"+test.namespace, strings.NewReader(test.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expectedCode, rec.Code, test.body)
+		if test.expectedBody != "" {
+			ja.Assertf(rec.Body.String(), test.expectedBody)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/namespaces", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"namespaces": [{"name": "Azure", "verified": false, "private": false, "proxied": false, "bindings": []}, {"name": "hashicorp", "verified": true, "private": false, "proxied": false, "bindings": []}, {"name": "terraform-aws-modules", "verified": false, "private": false, "proxied": true, "bindings": []}]}`)
+}
+
+func TestGetNamespaceNotFound(t *testing.T) {
+	e := newNamespaceServer(t, memoryNamespaces{})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/namespaces/hashicorp", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestOwnersManageBindings(t *testing.T) {
+	ja := jsonassert.New(t)
+	tokens, err := auth.NewStaticTokens([]auth.TokenEntry{
+		{Subject: "alice", SHA256: auth.HashToken("alice"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}},
+		{Subject: "bob", SHA256: auth.HashToken("bob"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopePublish}},
+	})
+	assert.NoError(t, err)
+	authorizer := auth.NewNamespaceAuthorizer(memoryNamespaces{
+		"acme": {Name: "acme", Bindings: []service.Binding{{Role: service.RoleOwner, Member: "user:alice"}}},
+	})
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	g := e.Group("/v1/admin/namespaces", auth.Middleware(auth.Config{Authenticator: auth.Authenticators{auth.AdminToken("secret"), tokens}}))
+	RegisterNamespaceControllerGroup(g, authorizer, auth.IsAdmin, authorizer)
+
+	table := []struct {
+		name         string
+		token        string
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "owner grants publish", token: "alice", method: http.MethodPost, path: "/acme/bindings", body: `{"role": "publisher", "member": "group:ci"}`,
+			expectedCode: http.StatusOK, expectedBody: `{"bindings": [{"role": "owner", "member": "user:alice"}, {"role": "publisher", "member": "group:ci"}]}`},
+		{name: "non owner", token: "bob", method: http.MethodPost, path: "/acme/bindings", body: `{"role": "owner", "member": "user:bob"}`, expectedCode: http.StatusForbidden},
+		{name: "unknown role", token: "alice", method: http.MethodPost, path: "/acme/bindings", body: `{"role": "maintainer", "member": "user:bob"}`, expectedCode: http.StatusBadRequest},
+		{name: "invalid member", token: "alice", method: http.MethodPost, path: "/acme/bindings", body: `{"role": "reader", "member": "bob"}`, expectedCode: http.StatusBadRequest},
+		{name: "owner makes private", token: "alice", method: http.MethodPatch, path: "/acme", body: `{"private": true}`,
+			expectedCode: http.StatusOK, expectedBody: `{"name": "acme", "verified": false, "private": true, "proxied": false, "bindings": "<<PRESENCE>>"}`},
+		{name: "owner cannot verify", token: "alice", method: http.MethodPatch, path: "/acme", body: `{"verified": true}`, expectedCode: http.StatusForbidden},
+		{name: "owner cannot proxy", token: "alice", method: http.MethodPatch, path: "/acme", body: `{"proxied": true}`, expectedCode: http.StatusForbidden},
+		{name: "owner cannot list all", token: "alice", method: http.MethodGet, path: "", expectedCode: http.StatusForbidden},
+		{name: "last owner stays", token: "alice", method: http.MethodDelete, path: "/acme/bindings/owner/user:alice", expectedCode: http.StatusBadRequest},
+		{name: "owner revokes", token: "alice", method: http.MethodDelete, path: "/acme/bindings/publisher/group:ci",
+			expectedCode: http.StatusOK, expectedBody: `{"bindings": [{"role": "owner", "member": "user:alice"}]}`},
+		{name: "admin replaces", token: "secret", method: http.MethodPut, path: "/acme/bindings", body: `{"bindings": [{"role": "owner", "member": "user:bob"}]}`,
+			expectedCode: http.StatusOK, expectedBody: `{"bindings": [{"role": "owner", "member": "user:bob"}]}`},
+		{name: "previous owner", token: "alice", method: http.MethodGet, path: "/acme/bindings", expectedCode: http.StatusForbidden},
+		{name: "new owner", token: "bob", method: http.MethodGet, path: "/acme/bindings",
+			expectedCode: http.StatusOK, expectedBody: `{"bindings": [{"role": "owner", "member": "user:bob"}]}`},
+	}
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/v1/admin/namespaces"+test.path, strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expectedCode, rec.Code, rec.Body.String())
+			if test.expectedBody != "" {
+				ja.Assertf(rec.Body.String(), test.expectedBody)
+			}
+		})
+	}
+}
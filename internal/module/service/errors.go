@@ -0,0 +1,16 @@
+package service
+
+import "errors"
+
+// errors returned by a ModuleService, implementations wrap them to add context
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrInvalidVersion     = errors.New("invalid version")
+	ErrInvalidArchive     = errors.New("invalid module archive")
+	ErrInvalidModule      = errors.New("invalid module address")
+	ErrInvalidBinding     = errors.New("invalid binding")
+	ErrInvalidRelease     = errors.New("invalid provider release")
+	ErrForbidden          = errors.New("forbidden")
+	ErrBackendUnavailable = errors.New("backend unavailable")
+)
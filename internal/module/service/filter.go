@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// FilterModules keeps the modules matching the provider, name, verification and excluded namespaces of a list request
+func FilterModules(modules []Module, params ListParams) []Module {
+	return lo.Filter(modules, func(m Module, _ int) bool {
+		return (params.Provider == "" || m.Provider == params.Provider) &&
+			(params.Name == "" || m.Name == params.Name) &&
+			(!params.Verified || m.Verified) &&
+			!lo.Contains(params.ExcludedNamespaces, m.Namespace)
+	})
+}
+
+// SearchModules keeps the modules of the provider whose namespace, name, provider or description contain the query
+func SearchModules(modules []Module, params SearchParams) []Module {
+	query := strings.ToLower(params.Query)
+	return lo.Filter(modules, func(m Module, _ int) bool {
+		if params.Provider != "" && m.Provider != params.Provider {
+			return false
+		}
+		if params.Verified && !m.Verified {
+			return false
+		}
+		if lo.Contains(params.ExcludedNamespaces, m.Namespace) {
+			return false
+		}
+		return strings.Contains(strings.ToLower(m.Namespace), query) ||
+			strings.Contains(strings.ToLower(m.Name), query) ||
+			strings.Contains(strings.ToLower(m.Provider), query) ||
+			strings.Contains(strings.ToLower(m.Description), query)
+	})
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MetadataSchemaVersion is the version of the ModuleMetadata document written by this registry,
+// version 2 added the extracted root and submodules, version 3 the overwrite audit trail,
+// version 4 the inputs, outputs, resources and readmes of every module and the examples
+const MetadataSchemaVersion = 4
+
+// ModuleMetadata is the document stored next to every module archive
+type ModuleMetadata struct {
+	SchemaVersion int       `json:"schema_version"`
+	Namespace     string    `json:"namespace"`
+	Name          string    `json:"name"`
+	System        string    `json:"system"`
+	Version       string    `json:"version"`
+	Owner         string    `json:"owner"`
+	Description   string    `json:"description"`
+	Source        string    `json:"source"`
+	SHA256        string    `json:"sha256"`
+	Size          int64     `json:"size"`
+	PublishedAt   time.Time `json:"published_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	Root       ModuleSpec   `json:"root"`
+	Submodules []ModuleSpec `json:"submodules"`
+	Examples   []ModuleSpec `json:"examples,omitempty"`
+
+	Overwrites []Overwrite `json:"overwrites,omitempty"`
+}
+
+// Overwrite records a forced re-upload of an already published version
+type Overwrite struct {
+	At             time.Time `json:"at"`
+	By             string    `json:"by"`
+	PreviousSHA256 string    `json:"previous_sha256"`
+}
+
+// Replaces keeps the owner and publish date of the previous metadata of the version and records the overwrite
+func (m *ModuleMetadata) Replaces(previous ModuleMetadata, by string) {
+	m.Owner = previous.Owner
+	m.PublishedAt = previous.PublishedAt
+	m.Overwrites = append(previous.Overwrites, Overwrite{
+		At:             m.UpdatedAt,
+		By:             by,
+		PreviousSHA256: previous.SHA256,
+	})
+}
+
+func (m ModuleMetadata) Descriptor() ModuleDescriptor {
+	return ModuleDescriptor{
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		System:    m.System,
+	}
+}
+
+func (m ModuleMetadata) Module() Module {
+	return Module{
+		Id:          fmt.Sprintf("%s/%s/%s/%s", m.Namespace, m.Name, m.System, m.Version),
+		Owner:       m.Owner,
+		Namespace:   m.Namespace,
+		Name:        m.Name,
+		Version:     m.Version,
+		Provider:    m.System,
+		Description: m.Description,
+		Source:      m.Source,
+		PublishedAt: m.PublishedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func (m ModuleMetadata) ModuleDetails() ModuleDetails {
+	return ModuleDetails{
+		Module:     m.Module(),
+		Root:       m.Root,
+		Submodules: m.Submodules,
+		Examples:   m.Examples,
+	}
+}
+
+func (m ModuleMetadata) ModuleVersion() ModuleVersion {
+	return ModuleVersion{
+		Version:    m.Version,
+		Root:       m.Root,
+		Submodules: m.Submodules,
+	}
+}
+
+// DecodeMetadata reads a metadata document and rejects schema versions this registry does not know
+func DecodeMetadata(r io.Reader) (ModuleMetadata, error) {
+	metadata := ModuleMetadata{}
+	if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+		return metadata, fmt.Errorf("failed to decode module metadata: %w", err)
+	}
+	if metadata.SchemaVersion < 1 || metadata.SchemaVersion > MetadataSchemaVersion {
+		return metadata, fmt.Errorf("unsupported module metadata schema version %d", metadata.SchemaVersion)
+	}
+	return metadata, nil
+}
+
+func EncodeMetadata(metadata ModuleMetadata) ([]byte, error) {
+	metadata.SchemaVersion = MetadataSchemaVersion
+	return json.MarshalIndent(metadata, "", "  ")
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"io"
+	"time"
 )
 
 // go interface called ModuleService, has a search method that takes a query string, limit int, offset int, provider string, namespace string, verified bool and returns a ModuleResult, and a Get method that takes an id string and returns a Module
@@ -17,6 +18,9 @@ type (
 		Description string
 		Source      string
 		PublishedAt string
+		Downloads   int
+		// Verified is set for modules of namespaces an admin verified
+		Verified bool
 	}
 	ModuleResultMeta struct {
 		Limit         int
@@ -33,13 +37,20 @@ type (
 		Offset    int
 		Provider  string
 		Namespace string
+		Name      string
+		// Verified limits the result to modules of verified namespaces
+		Verified bool
+		// ExcludedNamespaces hides the modules of namespaces the caller may not read
+		ExcludedNamespaces []string
 	}
 	SearchParams struct {
-		Query     string
-		Limit     int
-		Offset    int
-		Provider  string
-		Namespace string
+		Query              string
+		Limit              int
+		Offset             int
+		Provider           string
+		Namespace          string
+		Verified           bool
+		ExcludedNamespaces []string
 	}
 
 	ModuleDescriptor struct {
@@ -47,11 +58,85 @@ type (
 		Name      string
 		System    string
 	}
+	UploadOptions struct {
+		// Force replaces an already published version, only admins may do that
+		Force bool
+		// Publisher identifies who uploads the module
+		Publisher string
+		// PublishedAt keeps the publish date of a version copied from another registry, zero is the time of the upload
+		PublishedAt time.Time
+	}
+
+	// ModuleDetails is a single version of a module with the interface extracted from its archive
+	ModuleDetails struct {
+		Module     Module
+		Root       ModuleSpec
+		Submodules []ModuleSpec
+		Examples   []ModuleSpec
+	}
+
+	// ModuleVersion is a published version with the interface extracted from its archive
+	ModuleVersion struct {
+		Version    string
+		Root       ModuleSpec
+		Submodules []ModuleSpec
+	}
+	// ModuleSpec describes the root module, a submodule or an example of an archive, Path is empty for the root
+	ModuleSpec struct {
+		Path         string               `json:"path,omitempty"`
+		Readme       string               `json:"readme,omitempty"`
+		Empty        bool                 `json:"empty,omitempty"`
+		Inputs       []Input              `json:"inputs,omitempty"`
+		Outputs      []Output             `json:"outputs,omitempty"`
+		Resources    []Resource           `json:"resources,omitempty"`
+		Providers    []ProviderDependency `json:"providers"`
+		Dependencies []ModuleDependency   `json:"dependencies"`
+	}
+	// Input is a variable of a module, Default holds the json encoded default value
+	Input struct {
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+		Default     string `json:"default"`
+		Required    bool   `json:"required"`
+		Sensitive   bool   `json:"sensitive,omitempty"`
+	}
+	Output struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Sensitive   bool   `json:"sensitive,omitempty"`
+	}
+	Resource struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	ProviderDependency struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Source    string `json:"source"`
+		Version   string `json:"version"`
+	}
+	ModuleDependency struct {
+		Name    string `json:"name"`
+		Source  string `json:"source"`
+		Version string `json:"version"`
+	}
 )
 type ModuleService interface {
 	Search(params SearchParams) (ModuleResult, error)
 	List(params ListParams) (ModuleResult, error)
-	Versions(modul ModuleDescriptor) ([]string, error)
+	Versions(modul ModuleDescriptor) ([]ModuleVersion, error)
+	Get(modul ModuleDescriptor, version string) (ModuleDetails, error)
 	DownloadUrl(ModuleDescriptor, string) (string, error)
-	UploadModule(ModuleDescriptor, string, io.Reader) error
+	UploadModule(ModuleDescriptor, string, io.Reader, UploadOptions) error
+}
+
+// ArchiveStore is implemented by module services whose archives are served by the registry itself instead of a storage url
+type ArchiveStore interface {
+	OpenArchive(modul ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error)
+}
+
+// ArchiveLocator is implemented by module services that count downloads, ArchiveUrl is the DownloadUrl without counting it
+type ArchiveLocator interface {
+	ArchiveUrl(modul ModuleDescriptor, version string) (string, error)
 }
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Namespace holds the settings of a namespace, namespaces without settings are public, not verified and have no members
+type Namespace struct {
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
+	// Private namespaces can only be read by their members
+	Private bool `json:"private"`
+	// Proxied namespaces are resolved against the upstream registry of the server and cannot be published to
+	Proxied bool `json:"proxied"`
+	// Bindings grant roles in the namespace, once it has bindings only owners and publishers may publish to it
+	Bindings []Binding `json:"bindings,omitempty"`
+}
+
+// HasSettings tells if the namespace differs from one without settings
+func (n Namespace) HasSettings() bool {
+	return n.Verified || n.Private || n.Proxied || len(n.Bindings) > 0
+}
+
+// Role is what a member may do in a namespace, every role includes the ones below it
+type Role string
+
+const (
+	// RoleOwner manages the bindings and privacy of the namespace
+	RoleOwner Role = "owner"
+	// RolePublisher uploads modules
+	RolePublisher Role = "publisher"
+	// RoleReader reads the modules of a private namespace
+	RoleReader Role = "reader"
+)
+
+// Binding grants a role to a member, a member is user:<subject> or group:<name>
+type Binding struct {
+	Role   Role   `json:"role"`
+	Member string `json:"member"`
+}
+
+// Includes tells if the role grants the permissions of the other role
+func (r Role) Includes(other Role) bool {
+	return r.rank() >= other.rank() && other.rank() > 0
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RolePublisher:
+		return 2
+	case RoleReader:
+		return 1
+	}
+	return 0
+}
+
+// NamespaceStore is implemented by module services that keep settings of namespaces, like their verification by an admin
+type NamespaceStore interface {
+	// Namespaces lists the namespaces with modules or settings sorted by name
+	Namespaces() ([]Namespace, error)
+	Namespace(name string) (Namespace, error)
+	PutNamespace(namespace Namespace) error
+}
+
+// ValidateNamespace rejects names that cannot be part of a module address
+func ValidateNamespace(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: namespace %q", ErrInvalidModule, name)
+	}
+	return nil
+}
+
+// ValidateBindings rejects unknown roles and members that are neither users nor groups
+func ValidateBindings(bindings []Binding) error {
+	for _, binding := range bindings {
+		if binding.Role.rank() == 0 {
+			return fmt.Errorf("%w: unknown role %q, use owner, publisher or reader", ErrInvalidBinding, binding.Role)
+		}
+		kind, name, ok := strings.Cut(binding.Member, ":")
+		if !ok || name == "" || (kind != "user" && kind != "group") {
+			return fmt.Errorf("%w: member %q must be user:<name> or group:<name>", ErrInvalidBinding, binding.Member)
+		}
+	}
+	return nil
+}
+
+// VerifiedNamespaces returns the names of the verified namespaces
+func VerifiedNamespaces(namespaces []Namespace) map[string]bool {
+	verified := map[string]bool{}
+	for _, namespace := range namespaces {
+		if namespace.Verified {
+			verified[namespace.Name] = true
+		}
+	}
+	return verified
+}
+
+func DecodeNamespace(r io.Reader) (Namespace, error) {
+	namespace := Namespace{}
+	if err := json.NewDecoder(r).Decode(&namespace); err != nil {
+		return namespace, fmt.Errorf("failed to decode namespace: %w", err)
+	}
+	return namespace, nil
+}
+
+func EncodeNamespace(namespace Namespace) ([]byte, error) {
+	return json.MarshalIndent(namespace, "", "  ")
+}
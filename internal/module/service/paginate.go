@@ -0,0 +1,29 @@
+package service
+
+import "github.com/samber/lo"
+
+// MaxLimit is the largest page size a ModuleService returns
+const MaxLimit = 100
+
+// Paginate cuts a page out of modules and computes the meta the same way the handlers expect it
+func Paginate(modules []Module, limit, offset int) ModuleResult {
+	meta := PageMeta(len(modules), limit, offset)
+	return ModuleResult{
+		Meta:    meta,
+		Modules: lo.Subset(modules, meta.CurrentOffset, uint(meta.Limit)),
+	}
+}
+
+// PageMeta computes the meta of a page for services that paginate on their own, e.g. in a database query.
+// The limit and offset of the returned meta are the clamped values the page has to be cut with
+func PageMeta(total, limit, offset int) ModuleResultMeta {
+	limit = lo.Clamp(limit, 0, MaxLimit)
+	offset = lo.Clamp(offset, 0, total)
+
+	return ModuleResultMeta{
+		Limit:         limit,
+		CurrentOffset: offset,
+		NextOffset:    lo.Clamp(offset+limit, 0, total),
+		PrevOffset:    lo.Clamp(offset-limit, 0, total),
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+)
+
+// semverPattern is the official semantic versioning 2.0.0 pattern
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// NormalizeVersion strips a leading v and rejects everything that is not a semantic version
+func NormalizeVersion(version string) (string, error) {
+	normalized := strings.TrimPrefix(version, "v")
+	if !semverPattern.MatchString(normalized) {
+		return "", fmt.Errorf("%w: %q is not a semantic version", ErrInvalidVersion, version)
+	}
+	return normalized, nil
+}
+
+// CompareVersions orders versions by semver precedence, versions that cannot be parsed come first in lexical order
+func CompareVersions(a, b string) int {
+	va, errA := goversion.NewSemver(a)
+	vb, errB := goversion.NewSemver(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+// SortVersions sorts versions ascending by semver precedence
+func SortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+}
+
+// LatestVersion picks the highest stable version, pre-releases are only considered if there is no stable version
+func LatestVersion(versions []string) (string, bool) {
+	latest, latestPrerelease := "", ""
+	for _, version := range versions {
+		if v, err := goversion.NewSemver(version); err == nil && v.Prerelease() != "" {
+			if latestPrerelease == "" || CompareVersions(version, latestPrerelease) > 0 {
+				latestPrerelease = version
+			}
+			continue
+		}
+		if latest == "" || CompareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+	if latest != "" {
+		return latest, true
+	}
+	return latestPrerelease, latestPrerelease != ""
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeVersion(t *testing.T) {
+	table := []struct {
+		version  string
+		expected string
+		valid    bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"v1.2.3", "1.2.3", true},
+		{"1.0.0-beta.1", "1.0.0-beta.1", true},
+		{"1.0.0+build.5", "1.0.0+build.5", true},
+		{"1.2", "", false},
+		{"01.2.3", "", false},
+		{"latest", "", false},
+		{"", "", false},
+	}
+	for _, test := range table {
+		t.Run(test.version, func(t *testing.T) {
+			version, err := NormalizeVersion(test.version)
+			if test.valid {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expected, version)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidVersion)
+			}
+		})
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"1.10.0", "1.2.0", "1.10.0-rc.1", "1.9.0", "legacy", "1.10.0-alpha"}
+	SortVersions(versions)
+	assert.Equal(t, []string{"legacy", "1.2.0", "1.9.0", "1.10.0-alpha", "1.10.0-rc.1", "1.10.0"}, versions)
+}
+
+func TestLatestVersion(t *testing.T) {
+	latest, ok := LatestVersion([]string{"1.2.0", "1.10.0", "2.0.0-beta.1", "1.9.0"})
+	assert.True(t, ok)
+	assert.Equal(t, "1.10.0", latest)
+
+	latest, ok = LatestVersion([]string{"2.0.0-alpha", "2.0.0-beta.1"})
+	assert.True(t, ok)
+	assert.Equal(t, "2.0.0-beta.1", latest)
+
+	_, ok = LatestVersion([]string{})
+	assert.False(t, ok)
+}
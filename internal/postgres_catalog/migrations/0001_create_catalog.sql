@@ -0,0 +1,34 @@
+CREATE TABLE namespaces (
+    name       TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE modules (
+    id             BIGSERIAL PRIMARY KEY,
+    namespace      TEXT NOT NULL REFERENCES namespaces (name),
+    name           TEXT NOT NULL,
+    system         TEXT NOT NULL,
+    -- highest stable version by semver precedence, maintained by the registry on every publish
+    latest_version TEXT NOT NULL,
+    downloads      BIGINT NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (namespace, name, system)
+);
+
+CREATE INDEX modules_name_idx ON modules (name);
+CREATE INDEX modules_system_idx ON modules (system);
+
+CREATE TABLE module_versions (
+    module_id    BIGINT NOT NULL REFERENCES modules (id) ON DELETE CASCADE,
+    version      TEXT NOT NULL,
+    owner        TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    sha256       TEXT NOT NULL,
+    size         BIGINT NOT NULL,
+    published_at TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL,
+    downloads    BIGINT NOT NULL DEFAULT 0,
+    -- the metadata document as stored next to the archive
+    metadata     JSONB NOT NULL,
+    PRIMARY KEY (module_id, version)
+);
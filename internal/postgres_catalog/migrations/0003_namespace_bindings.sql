@@ -0,0 +1,10 @@
+-- private namespaces are hidden from everyone but their members
+ALTER TABLE namespaces ADD COLUMN private BOOLEAN NOT NULL DEFAULT false;
+
+-- the roles granted to users and groups in a namespace
+CREATE TABLE namespace_bindings (
+    namespace TEXT NOT NULL REFERENCES namespaces (name) ON DELETE CASCADE,
+    role      TEXT NOT NULL,
+    member    TEXT NOT NULL,
+    PRIMARY KEY (namespace, role, member)
+);
@@ -0,0 +1,104 @@
+package postgrescatalog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lib/pq"
+	"github.com/mxab/tf-registry/internal/catalog"
+	"github.com/mxab/tf-registry/internal/module/service"
+	sqlcatalog "github.com/mxab/tf-registry/internal/sql_catalog"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockId is the advisory lock that keeps registry instances starting at the same time from migrating concurrently
+const migrationLockId = 7_347_267_001
+
+var _ catalog.Catalog = (*PostgresCatalog)(nil)
+
+// dialect searches case insensitive and sorts with the C collation, migrations take an advisory lock
+var dialect = sqlcatalog.Dialect{
+	Migrations: migrations,
+	MigrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	LockMigrations:     lockMigrations,
+	Collate:            ` COLLATE "C"`,
+	ExcludedNamespaces: "NOT (m.namespace = ANY($4))",
+	Excluded:           excluded,
+	Search:             search,
+	MapError:           mapError,
+}
+
+// PostgresCatalog keeps the module metadata in postgres tables, see the migrations for the schema
+type PostgresCatalog struct {
+	*sqlcatalog.Catalog
+	db *sql.DB
+}
+
+func NewPostgresCatalog(db *sql.DB) *PostgresCatalog {
+	return &PostgresCatalog{Catalog: sqlcatalog.New(db, dialect), db: db}
+}
+
+// Open connects to the database and migrates it to the current schema
+func Open(ctx context.Context, databaseUrl string) (*PostgresCatalog, error) {
+	db, err := sql.Open("postgres", databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, mapError(err)
+	}
+	c := NewPostgresCatalog(db)
+	if err := c.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// mapError reports connection problems as an unavailable backend
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	var pqErr *pq.Error
+	// connection exceptions, insufficient resources and the server shutting down
+	if errors.As(err, &pqErr) && (pqErr.Code.Class() == "08" || pqErr.Code.Class() == "53" || pqErr.Code.Class() == "57") {
+		return fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+func lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockId); err != nil {
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockId)
+	}, nil
+}
+
+// excluded passes the excluded namespaces as array, an empty array instead of NULL when there are none
+func excluded(namespaces []string) any {
+	return pq.Array(append([]string{}, namespaces...))
+}
+
+func search(query string) (string, []any) {
+	return `(m.namespace ILIKE $5 OR m.name ILIKE $5 OR m.system ILIKE $5 OR v.description ILIKE $5)`,
+		[]any{"%" + sqlcatalog.EscapeLike(query) + "%"}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	modulehandler "github.com/mxab/tf-registry/internal/module/handler"
+	moduleservice "github.com/mxab/tf-registry/internal/module/service"
+	"github.com/mxab/tf-registry/internal/provider/service"
+)
+
+// https://developer.hashicorp.com/terraform/internals/provider-network-mirror-protocol
+type (
+	MirrorRequest struct {
+		Hostname  string `param:"hostname"`
+		Namespace string `param:"namespace"`
+		Type      string `param:"type"`
+		// File is index.json or <version>.json
+		File string `param:"file"`
+	}
+	MirrorVersionsResponse struct {
+		Versions map[string]struct{} `json:"versions"`
+	}
+	MirrorArchivesResponse struct {
+		Archives map[string]MirrorArchive `json:"archives"`
+	}
+	MirrorArchive struct {
+		URL    string   `json:"url"`
+		Hashes []string `json:"hashes"`
+	}
+	// MirrorController serves the providers of the store as network mirror
+	MirrorController struct {
+		Controller
+		// Hostnames are the hostnames of the providers in the store, none mirrors them for any hostname
+		Hostnames []string
+	}
+)
+
+// Mirror answers the index.json with the versions and the <version>.json with the archives of a provider
+func (ctrl *MirrorController) Mirror(c echo.Context) (err error) {
+	request := new(MirrorRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	provider := service.ProviderDescriptor{Namespace: request.Namespace, Type: request.Type}
+	if request.File == "index.json" {
+		return ctrl.index(c, provider)
+	}
+	if !strings.HasSuffix(request.File, ".json") {
+		return echo.ErrNotFound
+	}
+	return ctrl.archives(c, provider, strings.TrimSuffix(request.File, ".json"))
+}
+
+// matchHostname hides the providers of other hostnames
+func (ctrl *MirrorController) matchHostname(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		hostname := c.Param("hostname")
+		if len(ctrl.Hostnames) == 0 || hostname == "" {
+			return next(c)
+		}
+		for _, h := range ctrl.Hostnames {
+			if strings.EqualFold(h, hostname) {
+				return next(c)
+			}
+		}
+		return fmt.Errorf("hostname %s: %w", hostname, moduleservice.ErrNotFound)
+	}
+}
+
+func (ctrl *MirrorController) index(c echo.Context, provider service.ProviderDescriptor) error {
+	releases, err := ctrl.Providers.ProviderReleases(provider)
+	if err != nil {
+		return err
+	}
+	versions := map[string]struct{}{}
+	for _, release := range releases {
+		versions[release.Version] = struct{}{}
+	}
+	return c.JSON(http.StatusOK, MirrorVersionsResponse{Versions: versions})
+}
+
+func (ctrl *MirrorController) archives(c echo.Context, provider service.ProviderDescriptor, version string) error {
+	release, err := ctrl.Providers.ProviderRelease(provider, version)
+	if err != nil {
+		return err
+	}
+	_, serveFiles := ctrl.Providers.(service.ProviderFileStore)
+	archives := map[string]MirrorArchive{}
+	for _, pkg := range release.Packages {
+		// urls are relative to the <version>.json, the files of file stores are served by the mirror itself
+		url := release.Version + "/" + pkg.Filename
+		if !serveFiles {
+			if url, err = ctrl.Providers.ProviderFileUrl(provider, release.Version, pkg.Filename); err != nil {
+				return err
+			}
+		}
+		archives[pkg.OS+"_"+pkg.Arch] = MirrorArchive{URL: url, Hashes: pkg.Hashes()}
+	}
+	return c.JSON(http.StatusOK, MirrorArchivesResponse{Archives: archives})
+}
+
+// RegisterMirrorControllerGroup serves the network mirror protocol, hostnames other than the ones of the registry and its upstream are not found
+func RegisterMirrorControllerGroup(g *echo.Group, providers service.ProviderStore, hostnames []string, authorizer modulehandler.Authorizer) {
+	ctrl := &MirrorController{Controller: Controller{Providers: providers, Authorizer: authorizer}, Hostnames: hostnames}
+	g.Use(ctrl.matchHostname, ctrl.authorizeNamespace)
+	g.GET("/:hostname/:namespace/:type/:file", ctrl.Mirror)
+	g.GET("/:hostname/:namespace/:type/:version/:file", ctrl.DownloadFile)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/kinbiko/jsonassert"
+	"github.com/labstack/echo/v4"
+	modulehandler "github.com/mxab/tf-registry/internal/module/handler"
+	"github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMirrorServer(t *testing.T) *echo.Echo {
+	store := newProviderStore(t)
+	uploader := &service.Uploader{Store: store}
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	uploader.Signer, err = service.NewSigner(key, "")
+	require.NoError(t, err)
+	_, err = uploader.Upload(service.ReleaseUpload{
+		Provider: service.ProviderDescriptor{Namespace: "acme", Type: "cloud"},
+		Version:  "2.0.0",
+		Files:    tft.NewProviderUpload("cloud", "2.0.0", "linux_amd64"),
+	})
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.HTTPErrorHandler = modulehandler.ErrorHandler
+	RegisterMirrorControllerGroup(e.Group("/v1/mirror"), store, []string{"registry.example.com"}, readAuthorizer{hidden: "internal"})
+	return e
+}
+
+func TestMirrorListsVersions(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newMirrorServer(t)
+
+	rec := get(e, "/v1/mirror/registry.example.com/acme/cloud/index.json")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"versions": {"1.0.0": {}, "1.1.0": {}, "2.0.0": {}}}`)
+
+	// terraform asks for the providers of other registries too
+	rec = get(e, "/v1/mirror/registry.terraform.io/acme/cloud/index.json")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["hostname registry.terraform.io: not found"]}`)
+
+	rec = get(e, "/v1/mirror/registry.example.com/internal/secret/index.json")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = get(e, "/v1/mirror/registry.example.com/acme/unknown/index.json")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestMirrorListsArchives(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newMirrorServer(t)
+
+	endpoint := "/v1/mirror/registry.example.com/acme/cloud/2.0.0.json"
+	rec := get(e, endpoint)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"archives": {
+		"linux_amd64": {
+			"url": "2.0.0/terraform-provider-cloud_2.0.0_linux_amd64.zip",
+			"hashes": ["<<PRESENCE>>", "<<PRESENCE>>"]
+		}
+	}}`)
+	assert.Regexp(t, `"hashes":\["h1:[A-Za-z0-9+/=]+","zh:[0-9a-f]{64}"\]`, rec.Body.String())
+
+	// releases stored before the h1 hash only have the zh hash
+	rec = get(e, "/v1/mirror/registry.example.com/acme/cloud/1.0.0.json")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"archives": {
+		"linux_amd64": {"url": "1.0.0/terraform-provider-cloud_1.0.0_linux_amd64.zip", "hashes": ["<<PRESENCE>>"]},
+		"darwin_arm64": {"url": "1.0.0/terraform-provider-cloud_1.0.0_darwin_arm64.zip", "hashes": ["<<PRESENCE>>"]}
+	}}`)
+
+	// terraform resolves the urls against the <version>.json
+	base, err := url.Parse(endpoint)
+	require.NoError(t, err)
+	archive, err := url.Parse("2.0.0/terraform-provider-cloud_2.0.0_linux_amd64.zip")
+	require.NoError(t, err)
+	rec = get(e, base.ResolveReference(archive).String())
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = get(e, "/v1/mirror/registry.example.com/acme/cloud/3.0.0.json")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = get(e, "/v1/mirror/registry.example.com/acme/cloud/2.0.0")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mxab/tf-registry/internal/auth"
+	modulehandler "github.com/mxab/tf-registry/internal/module/handler"
+	moduleservice "github.com/mxab/tf-registry/internal/module/service"
+	"github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/samber/lo"
+)
+
+// https://developer.hashicorp.com/terraform/internals/provider-registry-protocol
+type (
+	ProviderRequest struct {
+		Namespace string `param:"namespace"`
+		Type      string `param:"type"`
+	}
+	DownloadProviderRequest struct {
+		Namespace string `param:"namespace"`
+		Type      string `param:"type"`
+		Version   string `param:"version"`
+		OS        string `param:"os"`
+		Arch      string `param:"arch"`
+	}
+	ProviderFileRequest struct {
+		Namespace string `param:"namespace"`
+		Type      string `param:"type"`
+		Version   string `param:"version"`
+		File      string `param:"file"`
+	}
+	UploadProviderRequest struct {
+		Namespace string `param:"namespace" validate:"required"`
+		Type      string `param:"type" validate:"required"`
+		Version   string `param:"version" validate:"required"`
+	}
+	ProviderVersionsResponse struct {
+		Versions []ProviderVersion `json:"versions"`
+	}
+	ProviderVersion struct {
+		Version   string     `json:"version"`
+		Protocols []string   `json:"protocols"`
+		Platforms []Platform `json:"platforms"`
+	}
+	Platform struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+	}
+	ProviderDownload struct {
+		Protocols           []string    `json:"protocols"`
+		OS                  string      `json:"os"`
+		Arch                string      `json:"arch"`
+		Filename            string      `json:"filename"`
+		DownloadURL         string      `json:"download_url"`
+		SHASumsURL          string      `json:"shasums_url"`
+		SHASumsSignatureURL string      `json:"shasums_signature_url"`
+		SHASum              string      `json:"shasum"`
+		SigningKeys         SigningKeys `json:"signing_keys"`
+	}
+	SigningKeys struct {
+		GPGPublicKeys []GPGPublicKey `json:"gpg_public_keys"`
+	}
+	GPGPublicKey struct {
+		KeyID          string  `json:"key_id"`
+		ASCIIArmor     string  `json:"ascii_armor"`
+		TrustSignature string  `json:"trust_signature"`
+		Source         string  `json:"source"`
+		SourceURL      *string `json:"source_url"`
+	}
+	// Controller serves the provider registry protocol from a provider store
+	Controller struct {
+		Providers service.ProviderStore
+		// Uploader publishes uploaded releases, nil disables uploads
+		Uploader *service.Uploader
+		// Authorizer decides who reads and publishes the providers of a namespace, nil allows everybody
+		Authorizer modulehandler.Authorizer
+	}
+)
+
+// authorizeNamespace hides the providers of namespaces the caller may not read, uploads require the publisher role
+func (ctrl *Controller) authorizeNamespace(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		namespace := c.Param("namespace")
+		if ctrl.Authorizer == nil || namespace == "" {
+			return next(c)
+		}
+		allowed, err := ctrl.Authorizer.CanRead(c, namespace)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("namespace %s: %w", namespace, moduleservice.ErrNotFound)
+		}
+		if c.Request().Method != http.MethodPost {
+			return next(c)
+		}
+		if allowed, err = ctrl.Authorizer.CanPublish(c, namespace); err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("publishing to namespace %s requires the publisher role: %w", namespace, moduleservice.ErrForbidden)
+		}
+		return next(c)
+	}
+}
+
+func (ctrl *Controller) ListVersions(c echo.Context) (err error) {
+	request := new(ProviderRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	releases, err := ctrl.Providers.ProviderReleases(service.ProviderDescriptor{Namespace: request.Namespace, Type: request.Type})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ProviderVersionsResponse{Versions: lo.Map(releases, convertVersion)})
+}
+
+// Download describes the package of a platform with the urls of the package, the checksums and their signature
+func (ctrl *Controller) Download(c echo.Context) (err error) {
+	request := new(DownloadProviderRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	provider := service.ProviderDescriptor{Namespace: request.Namespace, Type: request.Type}
+	release, err := ctrl.Providers.ProviderRelease(provider, request.Version)
+	if err != nil {
+		return err
+	}
+	pkg, ok := release.Package(request.OS, request.Arch)
+	if !ok {
+		return fmt.Errorf("%s: platform %s_%s: %w", service.ReleaseSubject(provider, request.Version), request.OS, request.Arch, moduleservice.ErrNotFound)
+	}
+	urls := map[string]string{}
+	for _, file := range []string{pkg.Filename, release.ShasumsFilename(), release.SignatureFilename()} {
+		if urls[file], err = ctrl.Providers.ProviderFileUrl(provider, release.Version, file); err != nil {
+			return err
+		}
+	}
+	return c.JSON(http.StatusOK, ProviderDownload{
+		Protocols:           nonNil(release.Protocols),
+		OS:                  pkg.OS,
+		Arch:                pkg.Arch,
+		Filename:            pkg.Filename,
+		DownloadURL:         urls[pkg.Filename],
+		SHASumsURL:          urls[release.ShasumsFilename()],
+		SHASumsSignatureURL: urls[release.SignatureFilename()],
+		SHASum:              pkg.SHA256,
+		SigningKeys:         SigningKeys{GPGPublicKeys: lo.Map(release.SigningKeys, convertSigningKey)},
+	})
+}
+
+// DownloadFile serves a file of a release for stores without own download urls
+func (ctrl *Controller) DownloadFile(c echo.Context) (err error) {
+	store, ok := ctrl.Providers.(service.ProviderFileStore)
+	if !ok {
+		return echo.ErrNotFound
+	}
+	request := new(ProviderFileRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	file, modTime, err := store.OpenProviderFile(service.ProviderDescriptor{Namespace: request.Namespace, Type: request.Type}, request.Version, request.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	http.ServeContent(c.Response(), c.Request(), request.File, modTime, file)
+	return nil
+}
+
+// UploadProvider publishes a release from a multipart form, the files field holds the packages and optionally the manifest,
+// SHA256SUMS and its signature, the protocols and platforms fields declare what the release must contain
+func (ctrl *Controller) UploadProvider(c echo.Context) (err error) {
+	request := new(UploadProviderRequest)
+	if err = c.Bind(request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err = c.Validate(request); err != nil {
+		return err
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "expected a multipart form with the files of the release")
+	}
+	upload := service.ReleaseUpload{
+		Provider:  service.ProviderDescriptor{Namespace: request.Namespace, Type: request.Type},
+		Version:   request.Version,
+		Protocols: formList(form.Value["protocols"]),
+		Platforms: formList(form.Value["platforms"]),
+		Files:     map[string][]byte{},
+	}
+	for _, header := range form.File["files"] {
+		if upload.Files[header.Filename], err = readFormFile(header); err != nil {
+			return err
+		}
+	}
+	if identity, ok := auth.IdentityFrom(c); ok {
+		upload.Publisher = identity.Subject
+	}
+	if _, err = ctrl.Uploader.Upload(upload); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// formList accepts repeated and comma separated values
+func formList(values []string) []string {
+	list := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func convertVersion(release service.Release, _ int) ProviderVersion {
+	return ProviderVersion{
+		Version:   release.Version,
+		Protocols: nonNil(release.Protocols),
+		Platforms: lo.Map(release.Packages, func(p service.Package, _ int) Platform {
+			return Platform{OS: p.OS, Arch: p.Arch}
+		}),
+	}
+}
+
+func convertSigningKey(key service.SigningKey, _ int) GPGPublicKey {
+	converted := GPGPublicKey{
+		KeyID:          key.KeyID,
+		ASCIIArmor:     key.ASCIIArmor,
+		TrustSignature: key.TrustSignature,
+		Source:         key.Source,
+	}
+	if key.SourceURL != "" {
+		converted.SourceURL = &key.SourceURL
+	}
+	return converted
+}
+
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func RegisterProviderControllerGroup(g *echo.Group, providers service.ProviderStore, uploader *service.Uploader, authorizer modulehandler.Authorizer) {
+	ctrl := &Controller{Providers: providers, Uploader: uploader, Authorizer: authorizer}
+	g.Use(ctrl.authorizeNamespace)
+	g.GET("/:namespace/:type/versions", ctrl.ListVersions)
+	g.GET("/:namespace/:type/:version/download/:os/:arch", ctrl.Download)
+	g.GET("/:namespace/:type/:version/files/:file", ctrl.DownloadFile)
+	if uploader != nil {
+		g.POST("/:namespace/:type/:version/upload", ctrl.UploadProvider)
+	}
+}
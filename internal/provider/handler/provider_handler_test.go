@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kinbiko/jsonassert"
+	"github.com/labstack/echo/v4"
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	modulehandler "github.com/mxab/tf-registry/internal/module/handler"
+	"github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/mxab/tf-registry/internal/validator"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAuthorizer lets everybody read all namespaces but the hidden one and publish to all but the read only one
+type readAuthorizer struct {
+	modulehandler.Authorizer
+	hidden   string
+	readOnly string
+}
+
+func (a readAuthorizer) CanRead(c echo.Context, namespace string) (bool, error) {
+	return namespace != a.hidden, nil
+}
+
+func (a readAuthorizer) CanPublish(c echo.Context, namespace string) (bool, error) {
+	return namespace != a.readOnly, nil
+}
+
+func newProviderStore(t *testing.T) *filesystemmoduleservice.FilesystemModuleService {
+	store, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	require.NoError(t, err)
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		release, files := tft.NewProviderRelease("acme", "cloud", version, "linux_amd64", "darwin_arm64")
+		require.NoError(t, store.PutProviderRelease(release, files))
+	}
+	release, files := tft.NewProviderRelease("internal", "secret", "1.0.0", "linux_amd64")
+	require.NoError(t, store.PutProviderRelease(release, files))
+	return store
+}
+
+func newProviderServer(t *testing.T) *echo.Echo {
+	store := newProviderStore(t)
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	signer, err := service.NewSigner(key, "")
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Validator = validator.New()
+	e.HTTPErrorHandler = modulehandler.ErrorHandler
+	uploader := &service.Uploader{Store: store, Signer: signer}
+	RegisterProviderControllerGroup(e.Group("/v1/providers"), store, uploader, readAuthorizer{hidden: "internal", readOnly: "readonly"})
+	return e
+}
+
+func get(e *echo.Echo, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// uploadRequest builds the multipart form of an upload
+func uploadRequest(t *testing.T, target string, files map[string][]byte, fields map[string]string) *http.Request {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for name, data := range files {
+		part, err := w.CreateFormFile("files", name)
+		require.NoError(t, err)
+		_, err = part.Write(data)
+		require.NoError(t, err)
+	}
+	for name, value := range fields {
+		require.NoError(t, w.WriteField(name, value))
+	}
+	require.NoError(t, w.Close())
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return req
+}
+
+func TestListProviderVersions(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newProviderServer(t)
+
+	rec := get(e, "/v1/providers/acme/cloud/versions")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"versions": [
+		{"version": "1.0.0", "protocols": ["5.0"], "platforms": [{"os": "linux", "arch": "amd64"}, {"os": "darwin", "arch": "arm64"}]},
+		{"version": "1.1.0", "protocols": ["5.0"], "platforms": [{"os": "linux", "arch": "amd64"}, {"os": "darwin", "arch": "arm64"}]}
+	]}`)
+
+	rec = get(e, "/v1/providers/acme/unknown/versions")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["provider acme/unknown: not found"]}`)
+
+	rec = get(e, "/v1/providers/internal/secret/versions")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDownloadProvider(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newProviderServer(t)
+
+	endpoint := "/v1/providers/acme/cloud/1.1.0/download/linux/amd64"
+	rec := get(e, endpoint)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{
+		"protocols": ["5.0"],
+		"os": "linux",
+		"arch": "amd64",
+		"filename": "terraform-provider-cloud_1.1.0_linux_amd64.zip",
+		"download_url": "../../files/terraform-provider-cloud_1.1.0_linux_amd64.zip",
+		"shasums_url": "../../files/terraform-provider-cloud_1.1.0_SHA256SUMS",
+		"shasums_signature_url": "../../files/terraform-provider-cloud_1.1.0_SHA256SUMS.sig",
+		"shasum": "<<PRESENCE>>",
+		"signing_keys": {"gpg_public_keys": [{
+			"key_id": "51852D87348FFC4C",
+			"ascii_armor": "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+			"trust_signature": "",
+			"source": "",
+			"source_url": null
+		}]}
+	}`)
+
+	// terraform resolves the urls against the download endpoint
+	base, err := url.Parse(endpoint)
+	require.NoError(t, err)
+	file, err := url.Parse("../../files/terraform-provider-cloud_1.1.0_SHA256SUMS")
+	require.NoError(t, err)
+	rec = get(e, base.ResolveReference(file).String())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "terraform-provider-cloud_1.1.0_darwin_arm64.zip")
+
+	rec = get(e, "/v1/providers/acme/cloud/1.1.0/download/windows/amd64")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["provider acme/cloud 1.1.0: platform windows_amd64: not found"]}`)
+
+	rec = get(e, "/v1/providers/acme/cloud/2.0.0/download/linux/amd64")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = get(e, "/v1/providers/internal/secret/1.0.0/files/terraform-provider-secret_1.0.0_linux_amd64.zip")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestUploadProvider(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newProviderServer(t)
+
+	files := tft.NewProviderUpload("cloud", "2.0.0", "linux_amd64", "windows_amd64")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, uploadRequest(t, "/v1/providers/acme/cloud/v2.0.0/upload", files, map[string]string{"platforms": "linux_amd64,windows_amd64"}))
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = get(e, "/v1/providers/acme/cloud/2.0.0/download/windows/amd64")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ja.Assertf(rec.Body.String(), `{
+		"protocols": ["5.0"],
+		"os": "windows",
+		"arch": "amd64",
+		"filename": "terraform-provider-cloud_2.0.0_windows_amd64.zip",
+		"download_url": "../../files/terraform-provider-cloud_2.0.0_windows_amd64.zip",
+		"shasums_url": "../../files/terraform-provider-cloud_2.0.0_SHA256SUMS",
+		"shasums_signature_url": "../../files/terraform-provider-cloud_2.0.0_SHA256SUMS.sig",
+		"shasum": "<<PRESENCE>>",
+		"signing_keys": {"gpg_public_keys": [{
+			"key_id": "<<PRESENCE>>",
+			"ascii_armor": "<<PRESENCE>>",
+			"trust_signature": "",
+			"source": "",
+			"source_url": null
+		}]}
+	}`)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, uploadRequest(t, "/v1/providers/acme/cloud/2.0.0/upload", files, nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestUploadProviderRejectsIncompleteReleases(t *testing.T) {
+	ja := jsonassert.New(t)
+	e := newProviderServer(t)
+
+	files := tft.NewProviderUpload("cloud", "2.0.0", "linux_amd64")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, uploadRequest(t, "/v1/providers/acme/cloud/2.0.0/upload", files, map[string]string{"platforms": "linux_amd64,darwin_arm64"}))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	ja.Assertf(rec.Body.String(), `{"errors": ["provider acme/cloud 2.0.0: invalid provider release: package of platform darwin_arm64 is missing"]}`)
+
+	// the version stays invisible
+	rec = get(e, "/v1/providers/acme/cloud/2.0.0/download/linux/amd64")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, uploadRequest(t, "/v1/providers/readonly/cloud/2.0.0/upload", files, nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// PackageHash is the h1: hash terraform records in the lock file, the dirhash of the files in the package zip
+func PackageHash(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	files := []string{}
+	byName := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files = append(files, f.Name)
+		byName[f.Name] = f
+	}
+	return dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		f, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("file %q not found in zip", name)
+		}
+		return f.Open()
+	})
+}
+
+// Hashes are the hashes of the package in the formats of the lock file, the h1: hash is missing for releases stored without it
+func (p Package) Hashes() []string {
+	hashes := []string{}
+	if p.Hash != "" {
+		hashes = append(hashes, p.Hash)
+	}
+	return append(hashes, "zh:"+p.SHA256)
+}
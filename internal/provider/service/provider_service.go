@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	moduleservice "github.com/mxab/tf-registry/internal/module/service"
+)
+
+// https://developer.hashicorp.com/terraform/internals/provider-registry-protocol
+
+// ReleaseSchemaVersion is the version of the Release document written by this registry
+const ReleaseSchemaVersion = 1
+
+type (
+	ProviderDescriptor struct {
+		Namespace string
+		Type      string
+	}
+	// Release is the document stored next to the files of a provider version
+	Release struct {
+		SchemaVersion int    `json:"schema_version"`
+		Namespace     string `json:"namespace"`
+		Type          string `json:"type"`
+		Version       string `json:"version"`
+		// Protocols are the plugin protocol versions the provider supports, like 5.0
+		Protocols []string  `json:"protocols"`
+		Packages  []Package `json:"packages"`
+		// SigningKeys are the public keys one of which signed the SHA256SUMS file
+		SigningKeys []SigningKey `json:"signing_keys"`
+		Publisher   string       `json:"publisher"`
+		PublishedAt time.Time    `json:"published_at"`
+	}
+	// Package is the zip archive of a release for one platform
+	Package struct {
+		OS       string `json:"os"`
+		Arch     string `json:"arch"`
+		Filename string `json:"filename"`
+		SHA256   string `json:"sha256"`
+		// Hash is the h1: hash of the files in the zip
+		Hash string `json:"hash,omitempty"`
+	}
+	SigningKey struct {
+		KeyID          string `json:"key_id"`
+		ASCIIArmor     string `json:"ascii_armor"`
+		TrustSignature string `json:"trust_signature"`
+		Source         string `json:"source"`
+		SourceURL      string `json:"source_url,omitempty"`
+	}
+)
+
+// ProviderStore is implemented by storages that keep provider releases next to the modules
+type ProviderStore interface {
+	// ProviderReleases returns the published releases of a provider sorted ascending by version
+	ProviderReleases(provider ProviderDescriptor) ([]Release, error)
+	ProviderRelease(provider ProviderDescriptor, version string) (Release, error)
+	// ProviderFileUrl is where terraform downloads a file of a release, relative urls are resolved against the download endpoint
+	ProviderFileUrl(provider ProviderDescriptor, version string, file string) (string, error)
+	// PutProviderRelease stores the files of a release before its document, published versions cannot be replaced
+	PutProviderRelease(release Release, files map[string][]byte) error
+}
+
+// ProviderFileStore is implemented by provider stores whose files are served by the registry itself
+type ProviderFileStore interface {
+	OpenProviderFile(provider ProviderDescriptor, version string, file string) (io.ReadSeekCloser, time.Time, error)
+}
+
+func (r Release) Descriptor() ProviderDescriptor {
+	return ProviderDescriptor{Namespace: r.Namespace, Type: r.Type}
+}
+
+// ShasumsFilename is the name of the file with the sha256 sums of all packages
+func (r Release) ShasumsFilename() string {
+	return fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", r.Type, r.Version)
+}
+
+// SignatureFilename is the name of the detached gpg signature of the sha256 sums
+func (r Release) SignatureFilename() string {
+	return r.ShasumsFilename() + ".sig"
+}
+
+// PackageFilename is the conventional name of the package of a platform
+func (r Release) PackageFilename(os, arch string) string {
+	return fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", r.Type, r.Version, os, arch)
+}
+
+// Files are the names of all files of the release
+func (r Release) Files() []string {
+	files := []string{r.ShasumsFilename(), r.SignatureFilename()}
+	for _, p := range r.Packages {
+		files = append(files, p.Filename)
+	}
+	return files
+}
+
+// Package returns the package of a platform
+func (r Release) Package(os, arch string) (Package, bool) {
+	for _, p := range r.Packages {
+		if p.OS == os && p.Arch == arch {
+			return p, true
+		}
+	}
+	return Package{}, false
+}
+
+// HasFile tells if the file belongs to the release
+func (r Release) HasFile(file string) bool {
+	for _, f := range r.Files() {
+		if f == file {
+			return true
+		}
+	}
+	return false
+}
+
+// SortReleases sorts releases ascending by semver precedence
+func SortReleases(releases []Release) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return moduleservice.CompareVersions(releases[i].Version, releases[j].Version) < 0
+	})
+}
+
+func DecodeRelease(r io.Reader) (Release, error) {
+	release := Release{}
+	if err := json.NewDecoder(r).Decode(&release); err != nil {
+		return release, fmt.Errorf("failed to decode provider release: %w", err)
+	}
+	return release, nil
+}
+
+func EncodeRelease(release Release) ([]byte, error) {
+	return json.MarshalIndent(release, "", "  ")
+}
+
+func ProviderSubject(provider ProviderDescriptor) string {
+	return fmt.Sprintf("provider %s/%s", provider.Namespace, provider.Type)
+}
+
+func ReleaseSubject(provider ProviderDescriptor, version string) string {
+	return fmt.Sprintf("%s %s", ProviderSubject(provider), version)
+}
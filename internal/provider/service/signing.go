@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// Signer signs the SHA256SUMS of releases uploaded without signature
+type Signer struct {
+	entity *openpgp.Entity
+	key    SigningKey
+}
+
+// LoadSigner reads an ascii armored private key, the passphrase decrypts it if it is protected
+func LoadSigner(path, passphrase string) (*Signer, error) {
+	armored, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(armored, passphrase)
+}
+
+func NewSigner(armoredPrivateKey []byte, passphrase string) (*Signer, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredPrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpg key: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("failed to read gpg key: the key ring is empty")
+	}
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, errors.New("gpg key has no private key")
+	}
+	// the primary key or a signing subkey makes the signature
+	privateKeys := []*openpgp.Subkey{{PrivateKey: entity.PrivateKey}}
+	for i := range entity.Subkeys {
+		privateKeys = append(privateKeys, &entity.Subkeys[i])
+	}
+	for _, subkey := range privateKeys {
+		if subkey.PrivateKey == nil || !subkey.PrivateKey.Encrypted {
+			continue
+		}
+		if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt gpg key: %w", err)
+		}
+	}
+
+	armored, err := armorPublicKey(entity)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		entity: entity,
+		key:    SigningKey{KeyID: entity.PrimaryKey.KeyIdString(), ASCIIArmor: armored},
+	}, nil
+}
+
+// Key is the public key terraform verifies the signatures of the signer with
+func (s *Signer) Key() SigningKey {
+	return s.key
+}
+
+// Sign creates a detached binary signature of data
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	signature := new(bytes.Buffer)
+	if err := openpgp.DetachSign(signature, s.entity, bytes.NewReader(data), nil); err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return signature.Bytes(), nil
+}
+
+// ParseSigningKeys reads the public keys of an ascii armored key ring
+func ParseSigningKeys(armoredKeyRing []byte) ([]SigningKey, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKeyRing))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpg keys: %w", err)
+	}
+	keys := []SigningKey{}
+	for _, entity := range entities {
+		armored, err := armorPublicKey(entity)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, SigningKey{KeyID: entity.PrimaryKey.KeyIdString(), ASCIIArmor: armored})
+	}
+	return keys, nil
+}
+
+// LoadSigningKeys reads the public keys of an ascii armored key ring file
+func LoadSigningKeys(path string) ([]SigningKey, error) {
+	armored, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKeys(armored)
+}
+
+// CheckSignature returns the key that made the detached signature of data, binary and ascii armored signatures are accepted
+func CheckSignature(keys []SigningKey, data, signature []byte) (SigningKey, error) {
+	for _, key := range keys {
+		keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key.ASCIIArmor))
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("failed to read gpg key %s: %w", key.KeyID, err)
+		}
+		check := openpgp.CheckDetachedSignature
+		if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+			check = openpgp.CheckArmoredDetachedSignature
+		}
+		if _, err := check(keyRing, bytes.NewReader(data), bytes.NewReader(signature)); err == nil {
+			return key, nil
+		}
+	}
+	return SigningKey{}, errors.New("signature is not made by a trusted key")
+}
+
+func armorPublicKey(entity *openpgp.Entity) (string, error) {
+	armored := new(bytes.Buffer)
+	w, err := armor.Encode(armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err = entity.Serialize(w); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return armored.String(), nil
+}
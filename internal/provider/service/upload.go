@@ -0,0 +1,262 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	moduleservice "github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+var (
+	protocolPattern = regexp.MustCompile(`^\d+\.\d+$`)
+	platformPattern = regexp.MustCompile(`^[a-z0-9]+_[a-z0-9]+$`)
+)
+
+type (
+	// ReleaseUpload are the files of a provider version the way goreleaser builds them
+	ReleaseUpload struct {
+		Provider ProviderDescriptor
+		Version  string
+		// Protocols the provider supports, they are read from the goreleaser manifest if empty
+		Protocols []string
+		// Platforms the release must contain as os_arch, empty accepts the platforms of the packages
+		Platforms []string
+		// Files by name, the per platform zips and optionally the manifest, the SHA256SUMS and its signature
+		Files     map[string][]byte
+		Publisher string
+	}
+	// Uploader validates uploaded releases and makes them visible once they are complete
+	Uploader struct {
+		Store ProviderStore
+		// Signer signs the SHA256SUMS of uploads without signature, nil requires signed uploads
+		Signer *Signer
+		// TrustedKeys may sign the SHA256SUMS of uploads besides the key of the signer
+		TrustedKeys []SigningKey
+	}
+	// manifest is the terraform-registry-manifest.json goreleaser publishes as <project>_<version>_manifest.json
+	manifest struct {
+		Version  int `json:"version"`
+		Metadata struct {
+			ProtocolVersions []string `json:"protocol_versions"`
+		} `json:"metadata"`
+	}
+)
+
+func invalidRelease(subject, format string, args ...any) error {
+	return fmt.Errorf("%s: %w: %s", subject, moduleservice.ErrInvalidRelease, fmt.Sprintf(format, args...))
+}
+
+// Upload checks that the packages of all platforms and the protocols are present and the checksums match,
+// signs the checksums unless they are signed by a trusted key and stores the release
+func (u *Uploader) Upload(upload ReleaseUpload) (Release, error) {
+	version, err := moduleservice.NormalizeVersion(upload.Version)
+	if err != nil {
+		return Release{}, err
+	}
+	release := Release{
+		SchemaVersion: ReleaseSchemaVersion,
+		Namespace:     upload.Provider.Namespace,
+		Type:          upload.Provider.Type,
+		Version:       version,
+		Publisher:     upload.Publisher,
+	}
+	subject := ReleaseSubject(upload.Provider, version)
+
+	prefix := fmt.Sprintf("terraform-provider-%s_%s_", release.Type, version)
+	manifestName := prefix + "manifest.json"
+	files := map[string][]byte{}
+	var shasums, signature, manifestData []byte
+	for name, data := range upload.Files {
+		switch {
+		case name == release.ShasumsFilename():
+			shasums = data
+		case name == release.SignatureFilename():
+			signature = data
+		case name == manifestName:
+			manifestData = data
+		case strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".zip"):
+			platform := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".zip")
+			if !platformPattern.MatchString(platform) {
+				return Release{}, invalidRelease(subject, "package %s has no os_arch platform", name)
+			}
+			if err := checkPackage(release, data); err != nil {
+				return Release{}, invalidRelease(subject, "package %s: %v", name, err)
+			}
+			hash, err := PackageHash(data)
+			if err != nil {
+				return Release{}, invalidRelease(subject, "package %s: %v", name, err)
+			}
+			os, arch, _ := strings.Cut(platform, "_")
+			sum := sha256.Sum256(data)
+			release.Packages = append(release.Packages, Package{OS: os, Arch: arch, Filename: name, SHA256: hex.EncodeToString(sum[:]), Hash: hash})
+			files[name] = data
+		default:
+			return Release{}, invalidRelease(subject, "unexpected file %s, packages are named %s<os>_<arch>.zip", name, prefix)
+		}
+	}
+	if len(release.Packages) == 0 {
+		return Release{}, invalidRelease(subject, "no packages")
+	}
+	sort.Slice(release.Packages, func(i, j int) bool {
+		return release.Packages[i].Filename < release.Packages[j].Filename
+	})
+	for _, platform := range upload.Platforms {
+		os, arch, _ := strings.Cut(platform, "_")
+		if _, ok := release.Package(os, arch); !ok {
+			return Release{}, invalidRelease(subject, "package of platform %s is missing", platform)
+		}
+	}
+
+	if release.Protocols, err = protocols(upload.Protocols, manifestData); err != nil {
+		return Release{}, invalidRelease(subject, "%v", err)
+	}
+
+	if shasums == nil {
+		if signature != nil {
+			return Release{}, invalidRelease(subject, "signature without %s", release.ShasumsFilename())
+		}
+		shasums = buildShasums(release.Packages)
+	} else if err := checkShasums(shasums, release.Packages, manifestName, manifestData); err != nil {
+		return Release{}, invalidRelease(subject, "%s: %v", release.ShasumsFilename(), err)
+	}
+
+	key := SigningKey{}
+	switch {
+	case signature != nil:
+		trusted := u.TrustedKeys
+		if u.Signer != nil {
+			trusted = append([]SigningKey{u.Signer.Key()}, trusted...)
+		}
+		if key, err = CheckSignature(trusted, shasums, signature); err != nil {
+			return Release{}, invalidRelease(subject, "%v", err)
+		}
+	case u.Signer != nil:
+		if signature, err = u.Signer.Sign(shasums); err != nil {
+			return Release{}, err
+		}
+		key = u.Signer.Key()
+	default:
+		return Release{}, invalidRelease(subject, "%s is missing and the registry has no key to sign it", release.SignatureFilename())
+	}
+	release.SigningKeys = []SigningKey{key}
+	files[release.ShasumsFilename()] = shasums
+	files[release.SignatureFilename()] = signature
+
+	release.PublishedAt = time.Now().UTC()
+	if err := u.Store.PutProviderRelease(release, files); err != nil {
+		return Release{}, err
+	}
+	return release, nil
+}
+
+// checkPackage makes sure the package is a zip with the provider binary
+func checkPackage(release Release, data []byte) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	binary := "terraform-provider-" + release.Type
+	for _, f := range archive.File {
+		if strings.HasPrefix(f.Name, binary) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s binary", binary)
+}
+
+// protocols returns the declared protocols, each must be listed in the manifest if there is one
+func protocols(declared []string, manifestData []byte) ([]string, error) {
+	listed := []string{}
+	if manifestData != nil {
+		m := manifest{}
+		if err := json.Unmarshal(manifestData, &m); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %v", err)
+		}
+		listed = m.Metadata.ProtocolVersions
+	}
+	if len(declared) == 0 {
+		declared = listed
+	} else if manifestData != nil {
+		for _, protocol := range declared {
+			if !lo.Contains(listed, protocol) {
+				return nil, fmt.Errorf("protocol %s is not listed in the manifest", protocol)
+			}
+		}
+	}
+	if len(declared) == 0 {
+		return nil, fmt.Errorf("protocol versions are missing")
+	}
+	for _, protocol := range declared {
+		if !protocolPattern.MatchString(protocol) {
+			return nil, fmt.Errorf("protocol %q is no <major>.<minor> version", protocol)
+		}
+	}
+	sorted := append([]string{}, declared...)
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+func buildShasums(packages []Package) []byte {
+	shasums := new(bytes.Buffer)
+	for _, p := range packages {
+		fmt.Fprintf(shasums, "%s  %s\n", p.SHA256, p.Filename)
+	}
+	return shasums.Bytes()
+}
+
+// ParseShasums reads the sha256 sums of a SHA256SUMS file by file name
+func ParseShasums(shasums []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(shasums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed line %q", scanner.Text())
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+	return sums, scanner.Err()
+}
+
+// checkShasums compares the uploaded checksums with the packages, goreleaser also lists the manifest.
+// Signed checksums of packages that were not uploaded would promise packages the registry cannot serve
+func checkShasums(shasums []byte, packages []Package, manifestName string, manifestData []byte) error {
+	sums, err := ParseShasums(shasums)
+	if err != nil {
+		return err
+	}
+	for _, name := range lo.Keys(sums) {
+		if name != manifestName && !lo.ContainsBy(packages, func(p Package) bool { return p.Filename == name }) {
+			return fmt.Errorf("%s is listed but not uploaded", name)
+		}
+	}
+	for _, p := range packages {
+		sum, ok := sums[p.Filename]
+		if !ok {
+			return fmt.Errorf("%s is not listed", p.Filename)
+		}
+		if sum != p.SHA256 {
+			return fmt.Errorf("checksum of %s does not match", p.Filename)
+		}
+	}
+	if sum, ok := sums[manifestName]; ok && manifestData != nil {
+		actual := sha256.Sum256(manifestData)
+		if sum != hex.EncodeToString(actual[:]) {
+			return fmt.Errorf("checksum of %s does not match", manifestName)
+		}
+	}
+	return nil
+}
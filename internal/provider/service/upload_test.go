@@ -0,0 +1,193 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	moduleservice "github.com/mxab/tf-registry/internal/module/service"
+	"github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// memoryStore keeps the stored releases in memory
+type memoryStore struct {
+	service.ProviderStore
+	releases []service.Release
+	files    map[string][]byte
+}
+
+func (s *memoryStore) PutProviderRelease(release service.Release, files map[string][]byte) error {
+	s.releases = append(s.releases, release)
+	s.files = files
+	return nil
+}
+
+func newSigner(t *testing.T) *service.Signer {
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	signer, err := service.NewSigner(key, "")
+	require.NoError(t, err)
+	return signer
+}
+
+func TestUploadSignsChecksums(t *testing.T) {
+	store := &memoryStore{}
+	signer := newSigner(t)
+	uploader := &service.Uploader{Store: store, Signer: signer}
+
+	release, err := uploader.Upload(service.ReleaseUpload{
+		Provider:  service.ProviderDescriptor{Namespace: "acme", Type: "cloud"},
+		Version:   "v1.0.0",
+		Platforms: []string{"linux_amd64", "darwin_arm64"},
+		Files:     tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64", "darwin_arm64"),
+		Publisher: "ci",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", release.Version)
+	assert.Equal(t, []string{"5.0"}, release.Protocols)
+	assert.Equal(t, "ci", release.Publisher)
+	assert.Equal(t, []service.SigningKey{signer.Key()}, release.SigningKeys)
+	if assert.Len(t, release.Packages, 2) {
+		assert.Equal(t, "terraform-provider-cloud_1.0.0_darwin_arm64.zip", release.Packages[0].Filename)
+		assert.Equal(t, "darwin", release.Packages[0].OS)
+		assert.Equal(t, "arm64", release.Packages[0].Arch)
+	}
+	assert.Len(t, store.releases, 1)
+
+	// the manifest is not part of the stored release
+	assert.ElementsMatch(t, release.Files(), lo.Keys(store.files))
+	shasums := store.files[release.ShasumsFilename()]
+	assert.Contains(t, string(shasums), release.Packages[0].SHA256+"  terraform-provider-cloud_1.0.0_darwin_arm64.zip\n")
+	key, err := service.CheckSignature(release.SigningKeys, shasums, store.files[release.SignatureFilename()])
+	require.NoError(t, err)
+	assert.Equal(t, signer.Key().KeyID, key.KeyID)
+}
+
+func TestUploadAcceptsSignedChecksums(t *testing.T) {
+	// the release was signed by the ci pipeline with a key the registry trusts
+	ci := newSigner(t)
+	files := tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64")
+	sum := sha256.Sum256(files["terraform-provider-cloud_1.0.0_linux_amd64.zip"])
+	shasums := []byte(hex.EncodeToString(sum[:]) + "  terraform-provider-cloud_1.0.0_linux_amd64.zip\n")
+	signature, err := ci.Sign(shasums)
+	require.NoError(t, err)
+	files["terraform-provider-cloud_1.0.0_SHA256SUMS"] = shasums
+	files["terraform-provider-cloud_1.0.0_SHA256SUMS.sig"] = signature
+
+	upload := service.ReleaseUpload{
+		Provider:  service.ProviderDescriptor{Namespace: "acme", Type: "cloud"},
+		Version:   "1.0.0",
+		Protocols: []string{"5.0"},
+		Files:     files,
+	}
+
+	store := &memoryStore{}
+	uploader := &service.Uploader{Store: store, Signer: newSigner(t), TrustedKeys: []service.SigningKey{ci.Key()}}
+	release, err := uploader.Upload(upload)
+	require.NoError(t, err)
+	assert.Equal(t, []service.SigningKey{ci.Key()}, release.SigningKeys)
+	assert.Equal(t, signature, store.files[release.SignatureFilename()])
+
+	uploader = &service.Uploader{Store: &memoryStore{}, Signer: newSigner(t)}
+	_, err = uploader.Upload(upload)
+	assert.ErrorIs(t, err, moduleservice.ErrInvalidRelease)
+	assert.ErrorContains(t, err, "signature is not made by a trusted key")
+}
+
+func TestUploadValidatesRelease(t *testing.T) {
+	signer := newSigner(t)
+	table := []struct {
+		name     string
+		modify   func(upload *service.ReleaseUpload)
+		expected string
+	}{
+		{"missing platform", func(upload *service.ReleaseUpload) {
+			upload.Platforms = []string{"linux_amd64", "windows_amd64"}
+		}, "package of platform windows_amd64 is missing"},
+		{"missing protocols", func(upload *service.ReleaseUpload) {
+			delete(upload.Files, "terraform-provider-cloud_1.0.0_manifest.json")
+		}, "protocol versions are missing"},
+		{"protocol not in manifest", func(upload *service.ReleaseUpload) {
+			upload.Protocols = []string{"6.0"}
+		}, "protocol 6.0 is not listed in the manifest"},
+		{"invalid protocol", func(upload *service.ReleaseUpload) {
+			delete(upload.Files, "terraform-provider-cloud_1.0.0_manifest.json")
+			upload.Protocols = []string{"5"}
+		}, `protocol "5" is no <major>.<minor> version`},
+		{"no zip", func(upload *service.ReleaseUpload) {
+			upload.Files["terraform-provider-cloud_1.0.0_linux_amd64.zip"] = []byte("binary")
+		}, "package terraform-provider-cloud_1.0.0_linux_amd64.zip: zip: not a valid zip file"},
+		{"unexpected file", func(upload *service.ReleaseUpload) {
+			upload.Files["terraform-provider-other_1.0.0_linux_amd64.zip"] = []byte("binary")
+		}, "unexpected file terraform-provider-other_1.0.0_linux_amd64.zip"},
+		{"checksum mismatch", func(upload *service.ReleaseUpload) {
+			upload.Files["terraform-provider-cloud_1.0.0_SHA256SUMS"] = []byte("0000  terraform-provider-cloud_1.0.0_linux_amd64.zip\n")
+		}, "checksum of terraform-provider-cloud_1.0.0_linux_amd64.zip does not match"},
+		{"checksum of package not uploaded", func(upload *service.ReleaseUpload) {
+			sum := sha256.Sum256(upload.Files["terraform-provider-cloud_1.0.0_linux_amd64.zip"])
+			upload.Files["terraform-provider-cloud_1.0.0_SHA256SUMS"] = []byte(hex.EncodeToString(sum[:]) + "  terraform-provider-cloud_1.0.0_linux_amd64.zip\n" +
+				hex.EncodeToString(sum[:]) + "  terraform-provider-cloud_1.0.0_darwin_arm64.zip\n")
+		}, "terraform-provider-cloud_1.0.0_darwin_arm64.zip is listed but not uploaded"},
+		{"no packages", func(upload *service.ReleaseUpload) {
+			delete(upload.Files, "terraform-provider-cloud_1.0.0_linux_amd64.zip")
+		}, "no packages"},
+	}
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			store := &memoryStore{}
+			upload := service.ReleaseUpload{
+				Provider: service.ProviderDescriptor{Namespace: "acme", Type: "cloud"},
+				Version:  "1.0.0",
+				Files:    tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64"),
+			}
+			test.modify(&upload)
+			_, err := (&service.Uploader{Store: store, Signer: signer}).Upload(upload)
+			assert.True(t, errors.Is(err, moduleservice.ErrInvalidRelease), err)
+			assert.ErrorContains(t, err, test.expected)
+			assert.Empty(t, store.releases)
+		})
+	}
+}
+
+func TestNewSignerRejectsEmptyKeyRing(t *testing.T) {
+	empty := new(bytes.Buffer)
+	w, err := armor.Encode(empty, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = service.NewSigner(empty.Bytes(), "")
+	assert.ErrorContains(t, err, "failed to read gpg key")
+}
+
+func TestUploadRequiresSignatureWithoutSigner(t *testing.T) {
+	_, err := (&service.Uploader{Store: &memoryStore{}}).Upload(service.ReleaseUpload{
+		Provider: service.ProviderDescriptor{Namespace: "acme", Type: "cloud"},
+		Version:  "1.0.0",
+		Files:    tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64"),
+	})
+	assert.ErrorContains(t, err, "terraform-provider-cloud_1.0.0_SHA256SUMS.sig is missing and the registry has no key to sign it")
+}
+
+func TestPackageHashMatchesTerraform(t *testing.T) {
+	// terraform hashes the package with the dirhash of the zip
+	data := tft.NewProviderUpload("cloud", "1.0.0", "linux_amd64")["terraform-provider-cloud_1.0.0_linux_amd64.zip"]
+	path := filepath.Join(t.TempDir(), "package.zip")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	expected, err := dirhash.HashZip(path, dirhash.Hash1)
+	require.NoError(t, err)
+
+	hash, err := service.PackageHash(data)
+	require.NoError(t, err)
+	assert.Equal(t, expected, hash)
+}
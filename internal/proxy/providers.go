@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerhandler "github.com/mxab/tf-registry/internal/provider/handler"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"github.com/samber/lo"
+)
+
+// maxPackageSize limits the provider packages fetched from upstream
+const maxPackageSize = 1 << 30
+
+var (
+	_ providerservice.ProviderStore     = (*ProxyProviderStore)(nil)
+	_ providerservice.ProviderFileStore = (*proxyProviderFileStore)(nil)
+)
+
+// ProxyProviderStore resolves the providers of the namespaces an admin marked as proxied against an upstream registry.
+// A version is cached with the packages of the platforms on its first use, after its SHA256SUMS were verified
+// with the signing keys of the upstream and the packages with the SHA256SUMS
+type ProxyProviderStore struct {
+	providerservice.ProviderStore
+	// Namespaces holds the settings of the namespaces the modules are published with, nil proxies no namespace
+	Namespaces service.NamespaceStore
+	Upstream   *Upstream
+	// Platforms are the os_arch platforms cached of a version, empty caches all platforms
+	Platforms []string
+	now       func() time.Time
+}
+
+// proxyProviderFileStore is the proxy of a store whose files are served by the registry
+type proxyProviderFileStore struct {
+	*ProxyProviderStore
+	files providerservice.ProviderFileStore
+}
+
+func (s *proxyProviderFileStore) OpenProviderFile(provider providerservice.ProviderDescriptor, version string, file string) (io.ReadSeekCloser, time.Time, error) {
+	return s.files.OpenProviderFile(provider, version, file)
+}
+
+// NewProxyProviderStore wraps the store, the proxy serves files itself if the store does.
+// The namespaces have to be the ones the authorizer checks, so proxying a namespace covers its modules and providers
+func NewProxyProviderStore(providers providerservice.ProviderStore, namespaces service.NamespaceStore, upstream *Upstream, platforms []string) providerservice.ProviderStore {
+	proxy := &ProxyProviderStore{ProviderStore: providers, Namespaces: namespaces, Upstream: upstream, Platforms: platforms, now: time.Now}
+	if files, ok := providers.(providerservice.ProviderFileStore); ok {
+		return &proxyProviderFileStore{ProxyProviderStore: proxy, files: files}
+	}
+	return proxy
+}
+
+// ProviderReleases lists the upstream versions of proxied providers, their releases only have protocols and platforms.
+// The cached releases are listed if the upstream is unavailable
+func (s *ProxyProviderStore) ProviderReleases(provider providerservice.ProviderDescriptor) ([]providerservice.Release, error) {
+	proxied, err := s.proxied(provider.Namespace)
+	if err != nil || !proxied {
+		return s.ProviderStore.ProviderReleases(provider)
+	}
+	versions, err := s.Upstream.ProviderVersions(provider)
+	if errors.Is(err, service.ErrBackendUnavailable) {
+		if cached, cacheErr := s.ProviderStore.ProviderReleases(provider); cacheErr == nil {
+			return cached, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	releases := []providerservice.Release{}
+	for _, v := range versions {
+		release := providerservice.Release{
+			SchemaVersion: providerservice.ReleaseSchemaVersion,
+			Namespace:     provider.Namespace,
+			Type:          provider.Type,
+			Version:       v.Version,
+			Protocols:     v.Protocols,
+		}
+		for _, platform := range v.Platforms {
+			release.Packages = append(release.Packages, providerservice.Package{OS: platform.OS, Arch: platform.Arch})
+		}
+		releases = append(releases, release)
+	}
+	providerservice.SortReleases(releases)
+	return releases, nil
+}
+
+// ProviderRelease caches the version of proxied providers before it is returned,
+// releases published before the namespace was proxied are not passed off as the ones of the upstream
+func (s *ProxyProviderStore) ProviderRelease(provider providerservice.ProviderDescriptor, version string) (providerservice.Release, error) {
+	proxied, err := s.proxied(provider.Namespace)
+	if err != nil {
+		return providerservice.Release{}, err
+	}
+	release, err := s.ProviderStore.ProviderRelease(provider, version)
+	if !proxied || (err != nil && !errors.Is(err, service.ErrNotFound)) {
+		return release, err
+	}
+	if err == nil {
+		if release.Publisher != s.Upstream.Hostname() {
+			return providerservice.Release{}, fmt.Errorf("%s was published by %s, not cached from %s: %w",
+				providerservice.ReleaseSubject(provider, version), release.Publisher, s.Upstream.Hostname(), service.ErrAlreadyExists)
+		}
+		return release, nil
+	}
+	if err := s.cache(provider, version); err != nil {
+		return providerservice.Release{}, err
+	}
+	return s.ProviderStore.ProviderRelease(provider, version)
+}
+
+// proxied tells if an admin marked the namespace to be resolved against the upstream
+func (s *ProxyProviderStore) proxied(namespace string) (bool, error) {
+	if s.Namespaces == nil {
+		return false, nil
+	}
+	settings, err := s.Namespaces.Namespace(namespace)
+	if errors.Is(err, service.ErrNotFound) {
+		return false, nil
+	}
+	return settings.Proxied, err
+}
+
+// PutProviderRelease rejects uploads to proxied namespaces, their providers come from the upstream
+func (s *ProxyProviderStore) PutProviderRelease(release providerservice.Release, files map[string][]byte) error {
+	proxied, err := s.proxied(release.Namespace)
+	if err != nil {
+		return err
+	}
+	if proxied {
+		return fmt.Errorf("namespace %s is proxied from %s: %w", release.Namespace, s.Upstream.Hostname(), service.ErrForbidden)
+	}
+	return s.ProviderStore.PutProviderRelease(release, files)
+}
+
+// cache verifies and stores the packages of a version
+func (s *ProxyProviderStore) cache(provider providerservice.ProviderDescriptor, version string) error {
+	subject := providerservice.ReleaseSubject(provider, version)
+	versions, err := s.Upstream.ProviderVersions(provider)
+	if err != nil {
+		return err
+	}
+	upstreamVersion, ok := lo.Find(versions, func(v providerhandler.ProviderVersion) bool { return v.Version == version })
+	if !ok {
+		return fmt.Errorf("%s: %w", subject, service.ErrNotFound)
+	}
+	platforms := lo.Filter(upstreamVersion.Platforms, func(p providerhandler.Platform, _ int) bool {
+		return len(s.Platforms) == 0 || lo.Contains(s.Platforms, p.OS+"_"+p.Arch)
+	})
+	if len(platforms) == 0 {
+		return fmt.Errorf("%s: no platform of %v: %w", subject, s.Platforms, service.ErrNotFound)
+	}
+
+	release := providerservice.Release{
+		SchemaVersion: providerservice.ReleaseSchemaVersion,
+		Namespace:     provider.Namespace,
+		Type:          provider.Type,
+		Version:       version,
+		Protocols:     upstreamVersion.Protocols,
+		Publisher:     s.Upstream.Hostname(),
+		PublishedAt:   s.now().UTC(),
+	}
+	files := map[string][]byte{}
+	var sums map[string]string
+	for _, platform := range platforms {
+		download, endpoint, err := s.Upstream.ProviderDownload(provider, version, platform.OS, platform.Arch)
+		if err != nil {
+			return err
+		}
+		// the checksums and their signature are the same for all platforms
+		if sums == nil {
+			if sums, err = s.verifyShasums(&release, files, download, endpoint); err != nil {
+				return fmt.Errorf("%s: %w", subject, err)
+			}
+		}
+		pkg, err := s.fetchPackage(download, endpoint, sums)
+		if err != nil {
+			return fmt.Errorf("%s: %w", subject, err)
+		}
+		files[download.Filename] = pkg.data
+		release.Packages = append(release.Packages, pkg.Package)
+	}
+
+	err = s.ProviderStore.PutProviderRelease(release, files)
+	// a concurrent request cached it first
+	if errors.Is(err, service.ErrAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+// verifyShasums checks the signature of the SHA256SUMS with the keys of the upstream, like terraform does, and stores both in files
+func (s *ProxyProviderStore) verifyShasums(release *providerservice.Release, files map[string][]byte, download providerhandler.ProviderDownload, endpoint *url.URL) (map[string]string, error) {
+	shasums, err := s.Upstream.fetch(endpoint, download.SHASumsURL, maxArchiveSize)
+	if err != nil {
+		return nil, fmt.Errorf("SHA256SUMS: %w", err)
+	}
+	signature, err := s.Upstream.fetch(endpoint, download.SHASumsSignatureURL, maxArchiveSize)
+	if err != nil {
+		return nil, fmt.Errorf("SHA256SUMS signature: %w", err)
+	}
+	keys := lo.Map(download.SigningKeys.GPGPublicKeys, func(key providerhandler.GPGPublicKey, _ int) providerservice.SigningKey {
+		return providerservice.SigningKey{
+			KeyID:          key.KeyID,
+			ASCIIArmor:     key.ASCIIArmor,
+			TrustSignature: key.TrustSignature,
+			Source:         key.Source,
+			SourceURL:      lo.FromPtr(key.SourceURL),
+		}
+	})
+	key, err := providerservice.CheckSignature(keys, shasums, signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: SHA256SUMS of upstream: %v", service.ErrBackendUnavailable, err)
+	}
+	sums, err := providerservice.ParseShasums(shasums)
+	if err != nil {
+		return nil, fmt.Errorf("%w: SHA256SUMS of upstream: %v", service.ErrBackendUnavailable, err)
+	}
+	release.SigningKeys = append(release.SigningKeys, key)
+	files[release.ShasumsFilename()] = shasums
+	files[release.SignatureFilename()] = signature
+	return sums, nil
+}
+
+type fetchedPackage struct {
+	providerservice.Package
+	data []byte
+}
+
+// fetchPackage downloads the package of a platform and compares it with the signed checksums
+func (s *ProxyProviderStore) fetchPackage(download providerhandler.ProviderDownload, endpoint *url.URL, sums map[string]string) (fetchedPackage, error) {
+	data, err := s.Upstream.fetch(endpoint, download.DownloadURL, maxPackageSize)
+	if err != nil {
+		return fetchedPackage{}, fmt.Errorf("package %s: %w", download.Filename, err)
+	}
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if actual != sums[download.Filename] || actual != download.SHASum {
+		return fetchedPackage{}, fmt.Errorf("%w: package %s of upstream does not match its signed checksum", service.ErrBackendUnavailable, download.Filename)
+	}
+	hash, err := providerservice.PackageHash(data)
+	if err != nil {
+		return fetchedPackage{}, fmt.Errorf("%w: package %s of upstream: %v", service.ErrBackendUnavailable, download.Filename, err)
+	}
+	return fetchedPackage{
+		Package: providerservice.Package{OS: download.OS, Arch: download.Arch, Filename: download.Filename, SHA256: actual, Hash: hash},
+		data:    data,
+	}, nil
+}
+
+// ProviderVersions lists the versions of a provider of the upstream
+func (u *Upstream) ProviderVersions(provider providerservice.ProviderDescriptor) ([]providerhandler.ProviderVersion, error) {
+	endpoint, err := u.providerURL(provider, "versions")
+	if err != nil {
+		return nil, err
+	}
+	response := providerhandler.ProviderVersionsResponse{}
+	if err := u.getJSON(endpoint.String(), &response); err != nil {
+		return nil, fmt.Errorf("%s: %w", providerservice.ProviderSubject(provider), err)
+	}
+	return response.Versions, nil
+}
+
+// ProviderDownload describes the package of a platform, its urls are relative to the returned endpoint
+func (u *Upstream) ProviderDownload(provider providerservice.ProviderDescriptor, version, os, arch string) (providerhandler.ProviderDownload, *url.URL, error) {
+	download := providerhandler.ProviderDownload{}
+	endpoint, err := u.providerURL(provider, version, "download", os, arch)
+	if err != nil {
+		return download, nil, err
+	}
+	if err := u.getJSON(endpoint.String(), &download); err != nil {
+		return download, nil, fmt.Errorf("%s: platform %s_%s: %w", providerservice.ReleaseSubject(provider, version), os, arch, err)
+	}
+	return download, endpoint, nil
+}
+
+func (u *Upstream) providerURL(provider providerservice.ProviderDescriptor, segments ...string) (*url.URL, error) {
+	base, err := u.serviceURL("providers.v1")
+	if err != nil {
+		return nil, err
+	}
+	path := url.PathEscape(provider.Namespace) + "/" + url.PathEscape(provider.Type)
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return base.Parse(path)
+}
+
+// fetch downloads a file whose url may be relative to the endpoint
+func (u *Upstream) fetch(endpoint *url.URL, ref string, limit int64) ([]byte, error) {
+	target, err := endpoint.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid url %q", service.ErrBackendUnavailable, ref)
+	}
+	res, err := u.get(target.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s exceeds %d bytes", ref, limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamProviders is a registry with the hashicorp/random provider 3.4.0 for linux_amd64 and darwin_arm64
+type upstreamProviders struct {
+	*httptest.Server
+	packageFetches atomic.Int32
+}
+
+func newUpstreamProviders(t *testing.T, signer *providerservice.Signer, shasumsSigner *providerservice.Signer) *upstreamProviders {
+	platforms := []string{"darwin_arm64", "linux_amd64"}
+	files := tft.NewProviderUpload("random", "3.4.0", platforms...)
+	names := []string{}
+	for name := range files {
+		if strings.HasSuffix(name, ".zip") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	shasums := new(strings.Builder)
+	for _, name := range names {
+		sum := sha256.Sum256(files[name])
+		fmt.Fprintf(shasums, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	files["terraform-provider-random_3.4.0_SHA256SUMS"] = []byte(shasums.String())
+	signature, err := shasumsSigner.Sign(files["terraform-provider-random_3.4.0_SHA256SUMS"])
+	require.NoError(t, err)
+	files["terraform-provider-random_3.4.0_SHA256SUMS.sig"] = signature
+
+	upstream := &upstreamProviders{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"providers.v1": "/api/providers/v1/"}`))
+	})
+	mux.HandleFunc("/api/providers/v1/hashicorp/random/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"versions": [
+			{"version": "3.4.0", "protocols": ["5.0"], "platforms": [{"os": "darwin", "arch": "arm64"}, {"os": "linux", "arch": "amd64"}]},
+			{"version": "3.3.0", "protocols": ["5.0"], "platforms": [{"os": "linux", "arch": "amd64"}]}
+		]}`))
+	})
+	for _, platform := range platforms {
+		os, arch, _ := strings.Cut(platform, "_")
+		filename := "terraform-provider-random_3.4.0_" + platform + ".zip"
+		sum := sha256.Sum256(files[filename])
+		key := signer.Key()
+		download := map[string]any{
+			"protocols":             []string{"5.0"},
+			"os":                    os,
+			"arch":                  arch,
+			"filename":              filename,
+			"download_url":          "/files/" + filename,
+			"shasums_url":           "/files/terraform-provider-random_3.4.0_SHA256SUMS",
+			"shasums_signature_url": "/files/terraform-provider-random_3.4.0_SHA256SUMS.sig",
+			"shasum":                hex.EncodeToString(sum[:]),
+			"signing_keys": map[string]any{"gpg_public_keys": []any{
+				map[string]any{"key_id": key.KeyID, "ascii_armor": key.ASCIIArmor, "source": "HashiCorp", "source_url": "https://www.hashicorp.com/security.html"},
+			}},
+		}
+		mux.HandleFunc("/api/providers/v1/hashicorp/random/3.4.0/download/"+os+"/"+arch, func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(download)
+		})
+	}
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/files/")
+		data, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(name, ".zip") {
+			upstream.packageFetches.Add(1)
+		}
+		_, _ = w.Write(data)
+	})
+	upstream.Server = httptest.NewServer(mux)
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func newSigner(t *testing.T) *providerservice.Signer {
+	t.Helper()
+	key, err := tft.NewGPGKey()
+	require.NoError(t, err)
+	signer, err := providerservice.NewSigner(key, "")
+	require.NoError(t, err)
+	return signer
+}
+
+func newProviderProxy(t *testing.T, upstreamURL string, platforms ...string) (providerservice.ProviderStore, *filesystemmoduleservice.FilesystemModuleService) {
+	local, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, local.PutNamespace(service.Namespace{Name: "hashicorp", Proxied: true}))
+	upstream, err := NewUpstream(upstreamURL)
+	require.NoError(t, err)
+	return NewProxyProviderStore(local, local, upstream, platforms), local
+}
+
+var random = providerservice.ProviderDescriptor{Namespace: "hashicorp", Type: "random"}
+
+func TestProxyCachesProviderRelease(t *testing.T) {
+	signer := newSigner(t)
+	upstream := newUpstreamProviders(t, signer, signer)
+	proxy, local := newProviderProxy(t, upstream.URL, "linux_amd64")
+
+	releases, err := proxy.ProviderReleases(random)
+	require.NoError(t, err)
+	if assert.Len(t, releases, 2) {
+		assert.Equal(t, "3.3.0", releases[0].Version)
+		assert.Equal(t, []providerservice.Package{{OS: "darwin", Arch: "arm64"}, {OS: "linux", Arch: "amd64"}}, releases[1].Packages)
+	}
+
+	for i := 0; i < 2; i++ {
+		release, err := proxy.ProviderRelease(random, "3.4.0")
+		require.NoError(t, err)
+		assert.Equal(t, upstream.Listener.Addr().String(), release.Publisher)
+		assert.Equal(t, []providerservice.SigningKey{{
+			KeyID: signer.Key().KeyID, ASCIIArmor: signer.Key().ASCIIArmor, Source: "HashiCorp", SourceURL: "https://www.hashicorp.com/security.html",
+		}}, release.SigningKeys)
+		// only the configured platforms are cached
+		if assert.Len(t, release.Packages, 1) {
+			assert.Equal(t, "terraform-provider-random_3.4.0_linux_amd64.zip", release.Packages[0].Filename)
+			assert.True(t, strings.HasPrefix(release.Packages[0].Hash, "h1:"))
+		}
+	}
+	assert.Equal(t, int32(1), upstream.packageFetches.Load())
+
+	// the files are served from the local store, the signature is the one of the upstream
+	files, ok := proxy.(providerservice.ProviderFileStore)
+	require.True(t, ok)
+	for _, file := range []string{"terraform-provider-random_3.4.0_linux_amd64.zip", "terraform-provider-random_3.4.0_SHA256SUMS", "terraform-provider-random_3.4.0_SHA256SUMS.sig"} {
+		f, _, err := files.OpenProviderFile(random, "3.4.0", file)
+		require.NoError(t, err, file)
+		data, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.NotEmpty(t, data)
+	}
+
+	// the cached releases are listed while the upstream is down
+	upstream.Close()
+	releases, err = proxy.ProviderReleases(random)
+	require.NoError(t, err)
+	assert.Len(t, releases, 1)
+	_, err = proxy.ProviderRelease(random, "3.3.0")
+	assert.ErrorIs(t, err, service.ErrBackendUnavailable)
+
+	cached, err := local.ProviderReleases(random)
+	require.NoError(t, err)
+	assert.Len(t, cached, 1)
+}
+
+func TestProxyRejectsProvidersWithoutValidSignature(t *testing.T) {
+	upstream := newUpstreamProviders(t, newSigner(t), newSigner(t))
+	proxy, local := newProviderProxy(t, upstream.URL)
+
+	_, err := proxy.ProviderRelease(random, "3.4.0")
+	assert.ErrorIs(t, err, service.ErrBackendUnavailable)
+	assert.ErrorContains(t, err, "signature is not made by a trusted key")
+	assert.Equal(t, int32(0), upstream.packageFetches.Load())
+
+	_, err = local.ProviderRelease(random, "3.4.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestProxyServesLocalProviders(t *testing.T) {
+	signer := newSigner(t)
+	upstream := newUpstreamProviders(t, signer, signer)
+	proxy, local := newProviderProxy(t, upstream.URL)
+
+	// namespaces are local unless they are proxied
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.0.0", "linux_amd64")
+	require.NoError(t, proxy.PutProviderRelease(release, files))
+	_, err := proxy.ProviderRelease(providerservice.ProviderDescriptor{Namespace: "acme", Type: "cloud"}, "1.0.0")
+	assert.NoError(t, err)
+
+	release, files = tft.NewProviderRelease("hashicorp", "random", "3.4.0", "linux_amd64")
+	assert.ErrorIs(t, proxy.PutProviderRelease(release, files), service.ErrForbidden)
+
+	// releases published before the namespace was proxied are not served as the ones of the upstream
+	require.NoError(t, local.PutProviderRelease(release, files))
+	_, err = proxy.ProviderRelease(random, "3.4.0")
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+
+	// without the proxy setting the namespace is local again, the upstream versions are hidden
+	require.NoError(t, local.PutNamespace(service.Namespace{Name: "hashicorp"}))
+	releases, err := proxy.ProviderReleases(random)
+	require.NoError(t, err)
+	if assert.Len(t, releases, 1) {
+		assert.Equal(t, "3.4.0", releases[0].Version)
+	}
+	_, err = proxy.ProviderRelease(random, "3.3.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	assert.Equal(t, int32(0), upstream.packageFetches.Load())
+}
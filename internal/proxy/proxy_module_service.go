@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+var (
+	_ service.ModuleService  = (*ProxyModuleService)(nil)
+	_ service.ArchiveStore   = (*ProxyModuleService)(nil)
+	_ service.NamespaceStore = (*ProxyModuleService)(nil)
+)
+
+// ProxyModuleService resolves the modules of the namespaces an admin marked as proxied against an upstream registry.
+// The archive of a version is fetched on its first use and uploaded to the wrapped module service, which serves it from then on.
+// The other namespaces are served by the wrapped module service alone
+type ProxyModuleService struct {
+	service.ModuleService
+	Upstream *Upstream
+}
+
+func NewProxyModuleService(modules service.ModuleService, upstream *Upstream) *ProxyModuleService {
+	return &ProxyModuleService{ModuleService: modules, Upstream: upstream}
+}
+
+// Versions lists the upstream versions of proxied modules, the cached versions are listed if the upstream is unavailable
+func (s *ProxyModuleService) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	proxied, err := s.proxied(modul.Namespace)
+	if err != nil || !proxied {
+		return s.ModuleService.Versions(modul)
+	}
+	versions, err := s.Upstream.Versions(modul)
+	if errors.Is(err, service.ErrBackendUnavailable) {
+		if cached, cacheErr := s.ModuleService.Versions(modul); cacheErr == nil && len(cached) > 0 {
+			return cached, nil
+		}
+	}
+	return versions, err
+}
+
+// UploadModule rejects uploads to proxied namespaces, their modules come from the upstream
+func (s *ProxyModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader, options service.UploadOptions) error {
+	proxied, err := s.proxied(modul.Namespace)
+	if err != nil {
+		return err
+	}
+	if proxied {
+		return fmt.Errorf("namespace %s is proxied from %s: %w", modul.Namespace, s.Upstream.Hostname(), service.ErrForbidden)
+	}
+	return s.ModuleService.UploadModule(modul, version, content, options)
+}
+
+func (s *ProxyModuleService) Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+	if err := s.ensureCached(modul, version); err != nil {
+		return service.ModuleDetails{}, err
+	}
+	return s.ModuleService.Get(modul, version)
+}
+
+func (s *ProxyModuleService) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+	if err := s.ensureCached(modul, version); err != nil {
+		return "", err
+	}
+	return s.ModuleService.DownloadUrl(modul, version)
+}
+
+func (s *ProxyModuleService) OpenArchive(modul service.ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error) {
+	store, ok := s.ModuleService.(service.ArchiveStore)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("module %s/%s/%s %s: archive is not served by the registry: %w", modul.Namespace, modul.Name, modul.System, version, service.ErrNotFound)
+	}
+	if err := s.ensureCached(modul, version); err != nil {
+		return nil, time.Time{}, err
+	}
+	return store.OpenArchive(modul, version)
+}
+
+func (s *ProxyModuleService) Namespaces() ([]service.Namespace, error) {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Namespaces()
+}
+
+func (s *ProxyModuleService) Namespace(name string) (service.Namespace, error) {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return service.Namespace{}, err
+	}
+	return store.Namespace(name)
+}
+
+func (s *ProxyModuleService) PutNamespace(namespace service.Namespace) error {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return err
+	}
+	return store.PutNamespace(namespace)
+}
+
+func (s *ProxyModuleService) namespaceStore() (service.NamespaceStore, error) {
+	store, ok := s.ModuleService.(service.NamespaceStore)
+	if !ok {
+		return nil, fmt.Errorf("namespaces are not managed by the module storage: %w", service.ErrNotFound)
+	}
+	return store, nil
+}
+
+// proxied tells if an admin marked the namespace to be resolved against the upstream
+func (s *ProxyModuleService) proxied(namespace string) (bool, error) {
+	store, ok := s.ModuleService.(service.NamespaceStore)
+	if !ok {
+		return false, nil
+	}
+	settings, err := store.Namespace(namespace)
+	if errors.Is(err, service.ErrNotFound) {
+		return false, nil
+	}
+	return settings.Proxied, err
+}
+
+// ensureCached uploads the upstream archive of a version of a proxied module unless it is cached already.
+// Versions published before the namespace was proxied are not passed off as the ones of the upstream
+func (s *ProxyModuleService) ensureCached(modul service.ModuleDescriptor, version string) error {
+	proxied, err := s.proxied(modul.Namespace)
+	if err != nil || !proxied {
+		return err
+	}
+	cached, err := s.ModuleService.Get(modul, version)
+	if err == nil {
+		if cached.Module.Owner != s.Upstream.Hostname() {
+			return fmt.Errorf("module %s/%s/%s %s was published by %s, not cached from %s: %w",
+				modul.Namespace, modul.Name, modul.System, version, cached.Module.Owner, s.Upstream.Hostname(), service.ErrAlreadyExists)
+		}
+		return nil
+	}
+	if !errors.Is(err, service.ErrNotFound) {
+		return err
+	}
+	archive, err := s.Upstream.Download(modul, version)
+	if err != nil {
+		return err
+	}
+	err = s.ModuleService.UploadModule(modul, version, bytes.NewReader(archive), service.UploadOptions{Publisher: s.Upstream.Hostname()})
+	// a concurrent request cached it first
+	if errors.Is(err, service.ErrAlreadyExists) {
+		return nil
+	}
+	return err
+}
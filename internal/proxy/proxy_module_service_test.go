@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func moduleTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	w := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// upstreamRegistry is a registry with the hashicorp/consul/aws module whose archive is a tar.gz with the module in a subdirectory
+type upstreamRegistry struct {
+	*httptest.Server
+	archiveFetches atomic.Int32
+}
+
+func newUpstreamRegistry(t *testing.T) *upstreamRegistry {
+	archive := moduleTarGz(t, map[string]string{
+		"README.md":                "# Repository",
+		"modules/consul/main.tf":   `variable "cluster_name" {}`,
+		"modules/consul/README.md": "# Consul\n\nRuns a consul cluster.",
+	})
+	upstream := &upstreamRegistry{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"modules.v1": "/api/modules/v1/"}`))
+	})
+	mux.HandleFunc("/api/modules/v1/hashicorp/consul/aws/versions", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"modules": [{"source": "hashicorp/consul/aws", "versions": [
+			{"version": "0.1.0", "root": {"providers": [{"name": "aws", "namespace": "hashicorp", "source": "hashicorp/aws", "version": ">= 4.0"}], "dependencies": []}, "submodules": []},
+			{"version": "0.2.0", "root": {"providers": [], "dependencies": []}, "submodules": []}
+		]}]}`))
+	})
+	mux.HandleFunc("/api/modules/v1/hashicorp/consul/aws/0.1.0/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Terraform-Get", "/archives/consul.tar.gz//modules/consul")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/archives/consul.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		upstream.archiveFetches.Add(1)
+		_, _ = w.Write(archive)
+	})
+	upstream.Server = httptest.NewServer(mux)
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func newProxy(t *testing.T, upstreamURL string) (*ProxyModuleService, *filesystemmoduleservice.FilesystemModuleService) {
+	local, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, local.PutNamespace(service.Namespace{Name: "hashicorp", Proxied: true}))
+	upstream, err := NewUpstream(upstreamURL)
+	require.NoError(t, err)
+	return NewProxyModuleService(local, upstream), local
+}
+
+var consul = service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+func TestProxyResolvesVersionsUpstream(t *testing.T) {
+	upstream := newUpstreamRegistry(t)
+	proxy, _ := newProxy(t, upstream.URL)
+
+	versions, err := proxy.Versions(consul)
+	require.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, "0.1.0", versions[0].Version)
+		assert.Equal(t, []service.ProviderDependency{{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws", Version: ">= 4.0"}}, versions[0].Root.Providers)
+	}
+
+	_, err = proxy.Versions(service.ModuleDescriptor{Namespace: "hashicorp", Name: "unknown", System: "aws"})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestProxyCachesArchive(t *testing.T) {
+	upstream := newUpstreamRegistry(t)
+	proxy, local := newProxy(t, upstream.URL)
+
+	for i := 0; i < 2; i++ {
+		url, err := proxy.DownloadUrl(consul, "0.1.0")
+		require.NoError(t, err)
+		assert.Equal(t, "./archive.zip", url)
+	}
+	assert.Equal(t, int32(1), upstream.archiveFetches.Load())
+
+	// the module of the subdirectory is stored by the local backend and owned by the upstream
+	details, err := local.Get(consul, "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, upstream.Listener.Addr().String(), details.Module.Owner)
+	assert.Equal(t, "Runs a consul cluster.", details.Module.Description)
+
+	archive, _, err := proxy.OpenArchive(consul, "0.1.0")
+	require.NoError(t, err)
+	defer archive.Close()
+	data, err := io.ReadAll(archive)
+	require.NoError(t, err)
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"main.tf", "README.md"}, names)
+
+	// the cached versions are listed while the upstream is down
+	upstream.Close()
+	versions, err := proxy.Versions(consul)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+	_, err = proxy.DownloadUrl(consul, "0.2.0")
+	assert.ErrorIs(t, err, service.ErrBackendUnavailable)
+}
+
+func TestProxyServesLocalNamespaces(t *testing.T) {
+	upstream := newUpstreamRegistry(t)
+	proxy, local := newProxy(t, upstream.URL)
+	network := service.ModuleDescriptor{Namespace: "acme", Name: "network", System: "aws"}
+	archive := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+
+	// namespaces are local unless they are proxied
+	require.NoError(t, proxy.UploadModule(network, "1.0.0", bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+	versions, err := proxy.Versions(network)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+	_, err = proxy.DownloadUrl(network, "1.0.0")
+	assert.NoError(t, err)
+
+	err = proxy.UploadModule(consul, "0.3.0", bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"})
+	assert.ErrorIs(t, err, service.ErrForbidden)
+
+	// versions published before the namespace was proxied are not served as the ones of the upstream
+	require.NoError(t, local.UploadModule(consul, "0.1.0", bytes.NewReader(archive), service.UploadOptions{Publisher: "ci"}))
+	_, err = proxy.DownloadUrl(consul, "0.1.0")
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+	assert.Equal(t, int32(0), upstream.archiveFetches.Load())
+
+	// without the proxy setting the namespace is local again, the upstream module is hidden
+	require.NoError(t, proxy.PutNamespace(service.Namespace{Name: "hashicorp"}))
+	versions, err = proxy.Versions(consul)
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+	_, err = proxy.DownloadUrl(consul, "0.2.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	assert.Equal(t, int32(0), upstream.archiveFetches.Load())
+}
+
+func TestRepackageLimitsUncompressedSize(t *testing.T) {
+	// the files compress well, only their uncompressed size counts
+	files := map[string]string{"main.tf": strings.Repeat(" ", 600), "variables.tf": strings.Repeat(" ", 600)}
+	for _, archive := range []struct {
+		source archiveSource
+		data   []byte
+	}{
+		{archiveSource{format: "zip"}, tft.ModuleZip(t, files)},
+		{archiveSource{format: "tar.gz"}, moduleTarGz(t, files)},
+	} {
+		_, err := archive.source.repackage(archive.data, 1000)
+		assert.ErrorIs(t, err, service.ErrInvalidArchive, archive.source.format)
+		_, err = archive.source.repackage(archive.data, 1200)
+		assert.NoError(t, err, archive.source.format)
+	}
+}
+
+func TestUpstreamGivesUpOnHungRegistry(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hung }))
+	defer server.Close()
+	defer close(hung)
+	upstream, err := NewUpstream(server.URL)
+	require.NoError(t, err)
+	assert.NotZero(t, upstream.Client.Timeout)
+
+	upstream.Client.Timeout = 50 * time.Millisecond
+	_, err = upstream.Versions(consul)
+	assert.ErrorIs(t, err, service.ErrBackendUnavailable)
+}
+
+func TestParseSource(t *testing.T) {
+	endpoint, _ := url.Parse("https://registry.example.com/v1/modules/a/b/c/1.0.0/download")
+	table := []struct {
+		source   string
+		expected archiveSource
+	}{
+		{"https://example.com/module.zip", archiveSource{url: "https://example.com/module.zip", format: "zip"}},
+		{"./archive.zip", archiveSource{url: "https://registry.example.com/v1/modules/a/b/c/1.0.0/archive.zip", format: "zip"}},
+		{"https://example.com/module.tar.gz//modules/vpc", archiveSource{url: "https://example.com/module.tar.gz", format: "tar.gz", subdir: "modules/vpc"}},
+		{"https://example.com/get?id=1&archive=tgz", archiveSource{url: "https://example.com/get?id=1", format: "tgz"}},
+		{"git::https://github.com/hashicorp/terraform-aws-consul.git//modules/consul?ref=v0.1.0", archiveSource{url: "https://codeload.github.com/hashicorp/terraform-aws-consul/zip/v0.1.0", format: "zip", subdir: "modules/consul", stripRoot: true}},
+		{"github.com/hashicorp/terraform-aws-consul?ref=v0.1.0", archiveSource{url: "https://codeload.github.com/hashicorp/terraform-aws-consul/zip/v0.1.0", format: "zip", stripRoot: true}},
+	}
+	for _, test := range table {
+		t.Run(test.source, func(t *testing.T) {
+			archive, err := parseSource(endpoint, test.source)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, archive)
+		})
+	}
+
+	_, err := parseSource(endpoint, "git::https://gitlab.com/acme/module.git")
+	assert.ErrorContains(t, err, "cannot be cached")
+	_, err = parseSource(endpoint, "s3::https://s3.amazonaws.com/bucket/module.zip")
+	assert.ErrorContains(t, err, "cannot be cached")
+}
+
+func TestRepackageStripsGithubRoot(t *testing.T) {
+	archive := archiveSource{format: "zip", subdir: "modules/consul", stripRoot: true}
+	data, err := archive.repackage(tft.ModuleZip(t, map[string]string{
+		"terraform-aws-consul-0.1.0/main.tf":                "",
+		"terraform-aws-consul-0.1.0/modules/consul/main.tf": "",
+	}), maxModuleSize)
+	require.NoError(t, err)
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	if assert.Len(t, r.File, 1) {
+		assert.Equal(t, "main.tf", r.File[0].Name)
+	}
+
+	_, err = archiveSource{format: "zip", subdir: "missing"}.repackage(tft.ModuleZip(t, map[string]string{"main.tf": ""}), maxModuleSize)
+	assert.ErrorIs(t, err, service.ErrInvalidArchive)
+}
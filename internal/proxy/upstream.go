@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+const (
+	// maxArchiveSize limits the archives fetched from upstream
+	maxArchiveSize = 256 << 20
+	// responseTimeout is how long the upstream may take to answer a request
+	responseTimeout = 10 * time.Second
+	// maxModuleSize limits the uncompressed files of an upstream archive
+	maxModuleSize = 512 << 20
+	// requestTimeout bounds a request including its body, provider packages take a while
+	requestTimeout = 5 * time.Minute
+)
+
+// Upstream is a registry the proxy resolves modules with, found with the service discovery of its host
+type Upstream struct {
+	URL    *url.URL
+	Client *http.Client
+
+	mu       sync.Mutex
+	services map[string]string
+}
+
+// NewUpstream accepts a registry url like https://registry.terraform.io or only its hostname
+func NewUpstream(rawURL string) (*Upstream, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream registry url %q", rawURL)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseTimeout
+	return &Upstream{URL: u, Client: &http.Client{Timeout: requestTimeout, Transport: transport}}, nil
+}
+
+// Hostname of the upstream registry, cached modules are owned by it
+func (u *Upstream) Hostname() string {
+	return u.URL.Host
+}
+
+// serviceURL discovers the base url of a service like modules.v1, the discovery is fetched until it succeeded.
+// The lock is not held while fetching, concurrent first requests may fetch it more than once
+func (u *Upstream) serviceURL(name string) (*url.URL, error) {
+	u.mu.Lock()
+	services := u.services
+	u.mu.Unlock()
+	if services == nil {
+		discovery, err := u.URL.Parse("/.well-known/terraform.json")
+		if err != nil {
+			return nil, err
+		}
+		document := map[string]any{}
+		if err := u.getJSON(discovery.String(), &document); err != nil {
+			return nil, fmt.Errorf("service discovery of %s: %w", u.Hostname(), err)
+		}
+		services = map[string]string{}
+		for key, value := range document {
+			if s, ok := value.(string); ok {
+				services[key] = s
+			}
+		}
+		u.mu.Lock()
+		u.services = services
+		u.mu.Unlock()
+	}
+	base, ok := services[name]
+	if !ok {
+		return nil, fmt.Errorf("registry %s does not offer %s: %w", u.Hostname(), name, service.ErrNotFound)
+	}
+	return u.URL.Parse(strings.TrimSuffix(base, "/") + "/")
+}
+
+// moduleURL builds the url of a module endpoint from the path segments
+func (u *Upstream) moduleURL(modul service.ModuleDescriptor, segments ...string) (*url.URL, error) {
+	base, err := u.serviceURL("modules.v1")
+	if err != nil {
+		return nil, err
+	}
+	escaped := []string{url.PathEscape(modul.Namespace), url.PathEscape(modul.Name), url.PathEscape(modul.System)}
+	for _, segment := range segments {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	return base.Parse(strings.Join(escaped, "/"))
+}
+
+// Versions lists the versions of a module with the providers and dependencies the upstream knows
+func (u *Upstream) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	endpoint, err := u.moduleURL(modul, "versions")
+	if err != nil {
+		return nil, err
+	}
+	response := struct {
+		Modules []struct {
+			Versions []service.ModuleVersion `json:"versions"`
+		} `json:"modules"`
+	}{}
+	if err := u.getJSON(endpoint.String(), &response); err != nil {
+		return nil, fmt.Errorf("%s: %w", subject(modul), err)
+	}
+	if len(response.Modules) == 0 {
+		return nil, fmt.Errorf("%s: %w", subject(modul), service.ErrNotFound)
+	}
+	return response.Modules[0].Versions, nil
+}
+
+// Download fetches the archive of a version and repackages it as zip of the module
+func (u *Upstream) Download(modul service.ModuleDescriptor, version string) ([]byte, error) {
+	endpoint, err := u.moduleURL(modul, version, "download")
+	if err != nil {
+		return nil, err
+	}
+	res, err := u.get(endpoint.String())
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", subject(modul), version, err)
+	}
+	res.Body.Close()
+	source := res.Header.Get("X-Terraform-Get")
+	if source == "" {
+		return nil, fmt.Errorf("%s %s: upstream download has no X-Terraform-Get header: %w", subject(modul), version, service.ErrBackendUnavailable)
+	}
+	archive, err := parseSource(endpoint, source)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", subject(modul), version, err)
+	}
+	res, err = u.get(archive.url)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: archive: %w", subject(modul), version, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxArchiveSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: archive: %w: %v", subject(modul), version, service.ErrBackendUnavailable, err)
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("%s %s: archive exceeds %d bytes", subject(modul), version, maxArchiveSize)
+	}
+	return archive.repackage(data, maxModuleSize)
+}
+
+// get fails with ErrNotFound for missing resources and ErrBackendUnavailable if the upstream cannot answer
+func (u *Upstream) get(target string) (*http.Response, error) {
+	res, err := u.Client.Get(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, service.ErrNotFound
+	}
+	return nil, fmt.Errorf("%w: %s answered %s", service.ErrBackendUnavailable, u.Hostname(), res.Status)
+}
+
+func (u *Upstream) getJSON(target string, v any) error {
+	res, err := u.get(target)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid response of %s: %v", service.ErrBackendUnavailable, u.Hostname(), err)
+	}
+	return nil
+}
+
+// archiveSource is an archive url of the go-getter address terraform would fetch the module from
+type archiveSource struct {
+	url    string
+	format string
+	// subdir of the module in the archive
+	subdir string
+	// stripRoot removes the single top level directory of github archives
+	stripRoot bool
+}
+
+// parseSource supports http archives and github repositories, the source may be relative to the download endpoint
+func parseSource(endpoint *url.URL, source string) (archiveSource, error) {
+	archive := archiveSource{}
+	forced, rest, ok := strings.Cut(source, "::")
+	if !ok || strings.Contains(forced, "/") {
+		forced, rest = "", source
+	}
+	// the module may live in a subdirectory of the archive, separated by //
+	if scheme, address, ok := strings.Cut(rest, "://"); ok {
+		address, archive.subdir, _ = strings.Cut(address, "//")
+		rest = scheme + "://" + address
+	} else {
+		rest, archive.subdir, _ = strings.Cut(rest, "//")
+	}
+	if i := strings.Index(archive.subdir, "?"); i >= 0 {
+		rest += archive.subdir[i:]
+		archive.subdir = archive.subdir[:i]
+	}
+
+	if strings.HasPrefix(rest, "github.com/") {
+		forced, rest = "git", "https://"+rest
+	}
+	u, err := endpoint.Parse(rest)
+	if err != nil {
+		return archive, fmt.Errorf("invalid module source %q: %w", source, service.ErrBackendUnavailable)
+	}
+	switch {
+	case forced == "git" && u.Host == "github.com":
+		repository := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+		ref := u.Query().Get("ref")
+		if ref == "" {
+			ref = "HEAD"
+		}
+		archive.url = fmt.Sprintf("https://codeload.github.com/%s/zip/%s", repository, url.PathEscape(ref))
+		archive.format = "zip"
+		archive.stripRoot = true
+		return archive, nil
+	case forced != "" && forced != "http" && forced != "https":
+		return archive, fmt.Errorf("module source %q cannot be cached, only http archives and github repositories are supported", source)
+	}
+	query := u.Query()
+	archive.format = query.Get("archive")
+	query.Del("archive")
+	u.RawQuery = query.Encode()
+	if archive.format == "" {
+		switch name := path.Base(u.Path); {
+		case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+			archive.format = "tar.gz"
+		default:
+			archive.format = "zip"
+		}
+	}
+	if archive.format != "zip" && archive.format != "tar.gz" && archive.format != "tgz" {
+		return archive, fmt.Errorf("module archive format %q cannot be cached, use zip or tar.gz", archive.format)
+	}
+	archive.url = u.String()
+	return archive, nil
+}
+
+// repackage converts the archive to a zip of the module directory, whose files may not exceed maxSize uncompressed
+func (a archiveSource) repackage(data []byte, maxSize int64) ([]byte, error) {
+	files := map[string][]byte{}
+	limit := &sizeLimit{max: maxSize, remaining: maxSize}
+	switch a.format {
+	case "zip":
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+		}
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+			}
+			files[f.Name], err = limit.read(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			if files[header.Name], err = limit.read(tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	prefix := ""
+	if a.stripRoot {
+		for name := range files {
+			root, _, _ := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+			prefix = root + "/"
+			break
+		}
+	}
+	if a.subdir != "" {
+		prefix += strings.Trim(a.subdir, "/") + "/"
+	}
+
+	names := []string{}
+	for name := range files {
+		if strings.HasPrefix(strings.TrimPrefix(name, "./"), prefix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no files in %q", service.ErrInvalidArchive, prefix)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := w.Create(strings.TrimPrefix(strings.TrimPrefix(name, "./"), prefix))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sizeLimit bounds the uncompressed size of all files of an archive
+type sizeLimit struct {
+	max       int64
+	remaining int64
+}
+
+func (l *sizeLimit) read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, l.remaining+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+	}
+	l.remaining -= int64(len(data))
+	if l.remaining < 0 {
+		return nil, fmt.Errorf("%w: files exceed %d bytes", service.ErrInvalidArchive, l.max)
+	}
+	return data, nil
+}
+
+func subject(modul service.ModuleDescriptor) string {
+	return fmt.Sprintf("module %s/%s/%s", modul.Namespace, modul.Name, modul.System)
+}
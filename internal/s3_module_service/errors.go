@@ -0,0 +1,44 @@
+package s3moduleservice
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aws/smithy-go"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+// mapS3Error wraps errors of the s3 client with the matching service error, subject names what was accessed
+func mapS3Error(err error, subject string) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey":
+			return fmt.Errorf("%s: %w", subject, service.ErrNotFound)
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return fmt.Errorf("%s: %w", subject, service.ErrAlreadyExists)
+		case "AccessDenied", "Forbidden":
+			return fmt.Errorf("%s: %w", subject, service.ErrForbidden)
+		case "NoSuchBucket", "ServiceUnavailable", "SlowDown", "InternalError":
+			return fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", service.ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+func moduleSubject(module service.ModuleDescriptor) string {
+	return fmt.Sprintf("module %s/%s/%s", module.Namespace, module.Name, module.System)
+}
+
+func versionSubject(module service.ModuleDescriptor, version string) string {
+	return fmt.Sprintf("%s %s", moduleSubject(module), version)
+}
@@ -0,0 +1,173 @@
+package s3moduleservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+const (
+	namespacesPrefix = "namespaces/"
+	settingsExt      = ".json"
+)
+
+var _ service.NamespaceStore = (*S3ModuleService)(nil)
+
+// cachedNamespace is the settings document of a namespace as it was when its object had the etag
+type cachedNamespace struct {
+	etag      string
+	namespace service.Namespace
+}
+
+func buildNamespaceKey(name string) string {
+	return namespacesPrefix + name + settingsExt
+}
+
+func namespaceSubject(name string) string {
+	return fmt.Sprintf("namespace %s", name)
+}
+
+// Namespaces lists the namespaces that have modules together with the namespaces that have settings
+func (s *S3ModuleService) Namespaces() ([]service.Namespace, error) {
+	ctx := context.Background()
+	settings, err := s.namespaceSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucketName),
+		Prefix:    aws.String(modulesPrefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, "namespaces")
+		}
+		for _, prefix := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(prefix.Prefix), modulesPrefix), "/")
+			if _, ok := settings[name]; !ok {
+				settings[name] = service.Namespace{Name: name}
+			}
+		}
+	}
+	namespaces := lo.Values(settings)
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+func (s *S3ModuleService) Namespace(name string) (service.Namespace, error) {
+	ctx := context.Background()
+	if err := service.ValidateNamespace(name); err != nil {
+		return service.Namespace{}, err
+	}
+	namespace, found, err := s.getNamespace(ctx, name)
+	if err != nil || found {
+		return namespace, err
+	}
+	// a namespace with modules but without settings
+	page, err := s.s3.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucketName),
+		Prefix:  aws.String(modulesPrefix + name + "/"),
+		MaxKeys: 1,
+	})
+	if err != nil {
+		return service.Namespace{}, mapS3Error(err, namespaceSubject(name))
+	}
+	if len(page.Contents) == 0 {
+		return service.Namespace{}, fmt.Errorf("%s: %w", namespaceSubject(name), service.ErrNotFound)
+	}
+	return service.Namespace{Name: name}, nil
+}
+
+func (s *S3ModuleService) PutNamespace(namespace service.Namespace) error {
+	if err := service.ValidateNamespace(namespace.Name); err != nil {
+		return err
+	}
+	encoded, err := service.EncodeNamespace(namespace)
+	if err != nil {
+		return err
+	}
+	_, err = s.s3.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(buildNamespaceKey(namespace.Name)),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+	})
+	return mapS3Error(err, namespaceSubject(namespace.Name))
+}
+
+// getNamespace reads the settings of a namespace, found is false if it has none
+func (s *S3ModuleService) getNamespace(ctx context.Context, name string) (service.Namespace, bool, error) {
+	resp, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(buildNamespaceKey(name)),
+	})
+	if err != nil {
+		err = mapS3Error(err, namespaceSubject(name))
+		if errors.Is(err, service.ErrNotFound) {
+			return service.Namespace{}, false, nil
+		}
+		return service.Namespace{}, false, err
+	}
+	defer resp.Body.Close()
+	namespace, err := service.DecodeNamespace(resp.Body)
+	return namespace, err == nil, err
+}
+
+// namespaceSettings reads the settings of all namespaces that have some, documents with an unchanged etag are taken from the cache
+func (s *S3ModuleService) namespaceSettings(ctx context.Context) (map[string]service.Namespace, error) {
+	settings := map[string]service.Namespace{}
+	s.mu.Lock()
+	cache := s.namespaces
+	s.mu.Unlock()
+	listed := map[string]cachedNamespace{}
+	paginator := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(namespacesPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, "namespaces")
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			name := strings.TrimSuffix(strings.TrimPrefix(key, namespacesPrefix), settingsExt)
+			if !strings.HasSuffix(key, settingsExt) || service.ValidateNamespace(name) != nil {
+				continue
+			}
+			etag := aws.ToString(obj.ETag)
+			if cached, ok := cache[name]; ok && etag != "" && cached.etag == etag {
+				settings[name] = cached.namespace
+				listed[name] = cached
+				continue
+			}
+			namespace, found, err := s.getNamespace(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				settings[name] = namespace
+				if etag != "" {
+					listed[name] = cachedNamespace{etag: etag, namespace: namespace}
+				}
+			}
+		}
+	}
+	// namespaces whose settings were removed drop out of the cache
+	s.mu.Lock()
+	s.namespaces = listed
+	s.mu.Unlock()
+	return settings, nil
+}
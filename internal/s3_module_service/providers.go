@@ -0,0 +1,154 @@
+package s3moduleservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+)
+
+const (
+	providersPrefix = "providers/namespaces/"
+	releaseName     = "release.json"
+)
+
+var _ providerservice.ProviderStore = (*S3ModuleService)(nil)
+
+func buildProviderKey(provider providerservice.ProviderDescriptor, version, file string) string {
+	return fmt.Sprintf("%s%s/%s/%s/%s", providersPrefix, provider.Namespace, provider.Type, version, file)
+}
+
+// ProviderReleases reads the release documents of all versions, versions without document are still being uploaded
+func (s *S3ModuleService) ProviderReleases(provider providerservice.ProviderDescriptor) ([]providerservice.Release, error) {
+	ctx := context.Background()
+	prefix := fmt.Sprintf("%s%s/%s/", providersPrefix, provider.Namespace, provider.Type)
+	paginator := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(prefix),
+	})
+	releases := []providerservice.Release{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, providerservice.ProviderSubject(provider))
+		}
+		for _, obj := range page.Contents {
+			version, file, ok := strings.Cut(strings.TrimPrefix(aws.ToString(obj.Key), prefix), "/")
+			if !ok || file != releaseName {
+				continue
+			}
+			release, err := s.ProviderRelease(provider, version)
+			if err != nil {
+				return nil, err
+			}
+			releases = append(releases, release)
+		}
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%s: %w", providerservice.ProviderSubject(provider), service.ErrNotFound)
+	}
+	providerservice.SortReleases(releases)
+	return releases, nil
+}
+
+func (s *S3ModuleService) ProviderRelease(provider providerservice.ProviderDescriptor, version string) (providerservice.Release, error) {
+	resp, err := s.s3.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(buildProviderKey(provider, version, releaseName)),
+	})
+	if err != nil {
+		return providerservice.Release{}, mapS3Error(err, providerservice.ReleaseSubject(provider, version))
+	}
+	defer resp.Body.Close()
+	return providerservice.DecodeRelease(resp.Body)
+}
+
+// ProviderFileUrl presigns the download of a file, the caller makes sure it belongs to a published release
+func (s *S3ModuleService) ProviderFileUrl(provider providerservice.ProviderDescriptor, version string, file string) (string, error) {
+	req, err := s.presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(buildProviderKey(provider, version, file)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// PutProviderRelease uploads the files first so a release document always points to existing files.
+// Files and document are only created if they do not exist yet, of concurrent uploads of a version one fails
+// before its files replace the ones the release document of the other one was made for
+func (s *S3ModuleService) PutProviderRelease(release providerservice.Release, files map[string][]byte) error {
+	ctx := context.Background()
+	provider := release.Descriptor()
+	subject := providerservice.ReleaseSubject(provider, release.Version)
+	for _, file := range release.Files() {
+		if _, ok := files[file]; !ok {
+			return fmt.Errorf("%s: file %s is missing", subject, file)
+		}
+	}
+	encoded, err := providerservice.EncodeRelease(release)
+	if err != nil {
+		return err
+	}
+
+	key := aws.String(buildProviderKey(provider, release.Version, releaseName))
+	_, err = s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucketName), Key: key})
+	if err == nil {
+		return fmt.Errorf("%s: %w", subject, service.ErrAlreadyExists)
+	}
+	if err = mapS3Error(err, subject); !errors.Is(err, service.ErrNotFound) {
+		return err
+	}
+
+	for _, file := range release.Files() {
+		if err := s.createProviderFile(ctx, buildProviderKey(provider, release.Version, file), files[file], subject); err != nil {
+			return err
+		}
+	}
+	_, err = s.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         key,
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+	}, s3.WithAPIOptions(smithyhttp.SetHeaderValue("If-None-Match", "*")))
+	return mapS3Error(err, subject)
+}
+
+// createProviderFile writes a file of a release if it does not exist. An existing file with the same content
+// is kept, so uploads that failed before their release document was written can be retried
+func (s *S3ModuleService) createProviderFile(ctx context.Context, key string, data []byte, subject string) error {
+	_, err := s.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}, s3.WithAPIOptions(smithyhttp.SetHeaderValue("If-None-Match", "*")))
+	if err = mapS3Error(err, subject); !errors.Is(err, service.ErrAlreadyExists) {
+		return err
+	}
+	resp, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return mapS3Error(err, subject)
+	}
+	defer resp.Body.Close()
+	existing, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, data) {
+		return fmt.Errorf("%s: file %s was uploaded with other content: %w", subject, path.Base(key), service.ErrAlreadyExists)
+	}
+	return nil
+}
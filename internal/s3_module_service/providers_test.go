@@ -0,0 +1,90 @@
+package s3moduleservice
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mxab/tf-registry/internal/module/service"
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderReleases(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, presignClient := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, presignClient)
+	provider := providerservice.ProviderDescriptor{Namespace: "acme", Type: "cloud"}
+
+	_, err := s3Service.ProviderReleases(provider)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	for _, version := range []string{"1.10.0", "1.2.0"} {
+		release, files := tft.NewProviderRelease("acme", "cloud", version, "linux_amd64", "darwin_arm64")
+		assert.NoError(t, s3Service.PutProviderRelease(release, files))
+	}
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.2.0", "linux_amd64")
+	assert.ErrorIs(t, s3Service.PutProviderRelease(release, files), service.ErrAlreadyExists)
+
+	releases, err := s3Service.ProviderReleases(provider)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.10.0"}, lo.Map(releases, func(r providerservice.Release, _ int) string { return r.Version }))
+
+	release, err = s3Service.ProviderRelease(provider, "1.10.0")
+	assert.NoError(t, err)
+	assert.Equal(t, releases[1], release)
+
+	result, err := s3Service.ProviderFileUrl(provider, "1.2.0", "terraform-provider-cloud_1.2.0_linux_amd64.zip")
+	assert.NoError(t, err)
+	u, err := url.Parse(result)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(u.Path, "/providers/namespaces/acme/cloud/1.2.0/terraform-provider-cloud_1.2.0_linux_amd64.zip"), u.Path)
+	assert.NotEqual(t, "", u.Query().Get("X-Amz-Signature"))
+}
+
+func TestPutProviderReleaseKeepsFilesOfOtherUploads(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, presignClient := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, presignClient)
+	provider := providerservice.ProviderDescriptor{Namespace: "acme", Type: "cloud"}
+	release, files := tft.NewProviderRelease("acme", "cloud", "1.0.0", "linux_amd64")
+	pkg := release.Packages[0].Filename
+	key := aws.String(buildProviderKey(provider, "1.0.0", pkg))
+
+	// a concurrent upload wrote its package first
+	_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucketName), Key: key, Body: strings.NewReader("other")})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, s3Service.PutProviderRelease(release, files), service.ErrAlreadyExists)
+	resp, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(bucketName), Key: key})
+	if assert.NoError(t, err) {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "other", string(data))
+	}
+	_, err = s3Service.ProviderRelease(provider, "1.0.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	// the files of a failed upload with the same content are reused
+	_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucketName), Key: key, Body: bytes.NewReader(files[pkg])})
+	assert.NoError(t, err)
+	assert.NoError(t, s3Service.PutProviderRelease(release, files))
+}
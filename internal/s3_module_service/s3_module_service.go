@@ -1,55 +1,300 @@
 package s3moduleservice
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/mxab/tf-registry/internal/module/archive"
 	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
 )
 
+const (
+	modulesPrefix = "modules/namespaces/"
+	archiveName   = "module.zip"
+	metadataName  = "metadata.json"
+)
+
+var _ service.ModuleService = (*S3ModuleService)(nil)
+
 type S3ModuleService struct {
 	s3            *s3.Client
 	presignClient *s3.PresignClient
 	bucketName    string
+	// latest caches the metadata of the latest version of every module, listings only fetch the documents whose etag changed
+	mu     sync.Mutex
+	latest map[service.ModuleDescriptor]cachedMetadata
+	// namespaces caches the settings documents of the namespaces, listings only fetch the documents whose etag changed
+	namespaces map[string]cachedNamespace
+}
+
+// versionObjects are the objects found for one version of a module
+type versionObjects struct {
+	archive      *types.Object
+	hasMetadata  bool
+	metadataETag string
+}
+
+// cachedMetadata is the metadata document of a version as it was when its object had the etag
+type cachedMetadata struct {
+	version  string
+	etag     string
+	metadata service.ModuleMetadata
 }
 
 func buildS3Key(module service.ModuleDescriptor, version string) string {
 
-	return fmt.Sprintf("modules/namespaces/%s/%s/%s/%s/module.zip", module.Namespace, module.Name, module.System, version)
+	return buildObjectKey(module, version, archiveName)
+}
+
+func buildMetadataKey(module service.ModuleDescriptor, version string) string {
+	return buildObjectKey(module, version, metadataName)
+}
+
+func buildObjectKey(module service.ModuleDescriptor, version, file string) string {
+	return fmt.Sprintf("%s%s/%s/%s/%s/%s", modulesPrefix, module.Namespace, module.Name, module.System, version, file)
+}
+
+// parseS3Key is the reverse of buildObjectKey, it returns false for keys that are not an archive or metadata document
+func parseS3Key(key string) (service.ModuleDescriptor, string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, modulesPrefix), "/")
+	if !strings.HasPrefix(key, modulesPrefix) || len(parts) != 5 || (parts[4] != archiveName && parts[4] != metadataName) {
+		return service.ModuleDescriptor{}, "", "", false
+	}
+	return service.ModuleDescriptor{
+		Namespace: parts[0],
+		Name:      parts[1],
+		System:    parts[2],
+	}, parts[3], parts[4], true
 }
 
 // implement the interface
-func (s *S3ModuleService) List(req *service.ListParams) (*service.ModuleResult, error) {
-	panic("implement me")
+func (s *S3ModuleService) List(req service.ListParams) (service.ModuleResult, error) {
+	modules, err := s.latestModules(context.Background(), req.Namespace)
+	if err != nil {
+		return service.ModuleResult{}, err
+	}
+	return service.Paginate(service.FilterModules(modules, req), req.Limit, req.Offset), nil
 }
-func (s *S3ModuleService) Seach(req *service.SearchParams) (*service.ModuleResult, error) {
-	panic("implement me")
+func (s *S3ModuleService) Search(req service.SearchParams) (service.ModuleResult, error) {
+	modules, err := s.latestModules(context.Background(), req.Namespace)
+	if err != nil {
+		return service.ModuleResult{}, err
+	}
+	return service.Paginate(service.SearchModules(modules, req), req.Limit, req.Offset), nil
 }
-func (s *S3ModuleService) Versions(modul service.ModuleDescriptor) ([]string, error) {
-	ctx := context.Background()
-	baseKey := fmt.Sprintf("modules/namespaces/%s/%s/%s/", modul.Namespace, modul.Name, modul.System)
-	resp, err := s.s3.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+
+// scan collects the archives and metadata documents below prefix grouped by module and version
+func (s *S3ModuleService) scan(ctx context.Context, prefix string) (map[service.ModuleDescriptor]map[string]*versionObjects, error) {
+	found := map[service.ModuleDescriptor]map[string]*versionObjects{}
+	paginator := s3.NewListObjectsV2Paginator(s.s3, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucketName),
-		Prefix: aws.String(baseKey),
+		Prefix: aws.String(prefix),
 	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, mapS3Error(err, "modules")
+		}
+		for i := range page.Contents {
+			obj := page.Contents[i]
+			descriptor, version, file, ok := parseS3Key(aws.ToString(obj.Key))
+			if !ok {
+				continue
+			}
+			if _, ok := found[descriptor]; !ok {
+				found[descriptor] = map[string]*versionObjects{}
+			}
+			if _, ok := found[descriptor][version]; !ok {
+				found[descriptor][version] = &versionObjects{}
+			}
+			if file == archiveName {
+				found[descriptor][version].archive = &obj
+			} else {
+				found[descriptor][version].hasMetadata = true
+				found[descriptor][version].metadataETag = aws.ToString(obj.ETag)
+			}
+		}
+	}
+	return found, nil
+}
+
+// publishedVersions returns the versions that have an archive, sorted ascending by semver precedence
+func publishedVersions(versions map[string]*versionObjects) []string {
+	published := lo.Filter(lo.Keys(versions), func(version string, _ int) bool {
+		return versions[version].archive != nil
+	})
+	service.SortVersions(published)
+	return published
+}
+
+// loadMetadata reads the metadata document of a version, archives uploaded before
+// metadata documents existed get their metadata derived from the archive object
+func (s *S3ModuleService) loadMetadata(ctx context.Context, descriptor service.ModuleDescriptor, version string, objects *versionObjects) (service.ModuleMetadata, error) {
+	if !objects.hasMetadata {
+		lastModified := aws.ToTime(objects.archive.LastModified).UTC()
+		return service.ModuleMetadata{
+			SchemaVersion: service.MetadataSchemaVersion,
+			Namespace:     descriptor.Namespace,
+			Name:          descriptor.Name,
+			System:        descriptor.System,
+			Version:       version,
+			Size:          objects.archive.Size,
+			PublishedAt:   lastModified,
+			UpdatedAt:     lastModified,
+		}, nil
+	}
+	return s.getMetadata(ctx, descriptor, version)
+}
+
+func (s *S3ModuleService) getMetadata(ctx context.Context, descriptor service.ModuleDescriptor, version string) (service.ModuleMetadata, error) {
+	resp, err := s.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(buildMetadataKey(descriptor, version)),
+	})
+	if err != nil {
+		return service.ModuleMetadata{}, mapS3Error(err, versionSubject(descriptor, version))
+	}
+	defer resp.Body.Close()
+	return service.DecodeMetadata(resp.Body)
+}
+
+// latestModules lists every module in the bucket, optionally limited to one namespace, with its latest version
+func (s *S3ModuleService) latestModules(ctx context.Context, namespace string) ([]service.Module, error) {
+	prefix := modulesPrefix
+	if namespace != "" {
+		prefix = fmt.Sprintf("%s%s/", modulesPrefix, namespace)
+	}
+
+	found, err := s.scan(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.namespaceSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := []service.Module{}
+	for descriptor, versions := range found {
+		latest, ok := service.LatestVersion(publishedVersions(versions))
+		if !ok {
+			continue
+		}
+		metadata, err := s.latestMetadata(ctx, descriptor, latest, versions[latest])
+		if err != nil {
+			return nil, err
+		}
+		module := metadata.Module()
+		module.Verified = settings[descriptor.Namespace].Verified
+		modules = append(modules, module)
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Id < modules[j].Id
+	})
+	return modules, nil
+}
+
+// latestMetadata loads the metadata of the latest version of a module unless the cached document has the same etag
+func (s *S3ModuleService) latestMetadata(ctx context.Context, descriptor service.ModuleDescriptor, version string, objects *versionObjects) (service.ModuleMetadata, error) {
+	if !objects.hasMetadata || objects.metadataETag == "" {
+		return s.loadMetadata(ctx, descriptor, version, objects)
+	}
+	s.mu.Lock()
+	cached, ok := s.latest[descriptor]
+	s.mu.Unlock()
+	if ok && cached.version == version && cached.etag == objects.metadataETag {
+		return cached.metadata, nil
+	}
+
+	metadata, err := s.getMetadata(ctx, descriptor, version)
+	if err != nil {
+		return service.ModuleMetadata{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest == nil {
+		s.latest = map[service.ModuleDescriptor]cachedMetadata{}
+	}
+	s.latest[descriptor] = cachedMetadata{version: version, etag: objects.metadataETag, metadata: metadata}
+	return metadata, nil
+}
+
+// moduleVersions returns the metadata of all published versions of a module
+func (s *S3ModuleService) moduleVersions(ctx context.Context, modul service.ModuleDescriptor) ([]service.ModuleMetadata, error) {
+	found, err := s.scan(ctx, fmt.Sprintf("%s%s/%s/%s/", modulesPrefix, modul.Namespace, modul.Name, modul.System))
 	if err != nil {
 		return nil, err
 	}
+	versions := found[modul]
+	published := publishedVersions(versions)
+	if len(published) == 0 {
+		return nil, fmt.Errorf("%s: %w", moduleSubject(modul), service.ErrNotFound)
+	}
 
-	versions := make([]string, 0, len(resp.Contents))
-	for _, obj := range resp.Contents {
-		key := strings.TrimSuffix(strings.TrimPrefix(*obj.Key, baseKey), "/module.zip")
-		versions = append(versions, key)
+	result := []service.ModuleMetadata{}
+	for _, version := range published {
+		metadata, err := s.loadMetadata(ctx, modul, version, versions[version])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, metadata)
 	}
-	return versions, nil
+	return result, nil
 }
-func (s *S3ModuleService) GetModuleDownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
+
+func (s *S3ModuleService) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	versions, err := s.moduleVersions(context.Background(), modul)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(versions, func(m service.ModuleMetadata, _ int) service.ModuleVersion {
+		return m.ModuleVersion()
+	}), nil
+}
+func (s *S3ModuleService) Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+	versions, err := s.moduleVersions(context.Background(), modul)
+	if err != nil {
+		return service.ModuleDetails{}, err
+	}
+	metadata, found := lo.Find(versions, func(m service.ModuleMetadata) bool {
+		return m.Version == version
+	})
+	if !found {
+		return service.ModuleDetails{}, fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrNotFound)
+	}
+	namespace, _, err := s.getNamespace(context.Background(), modul.Namespace)
+	if err != nil {
+		return service.ModuleDetails{}, err
+	}
+	details := metadata.ModuleDetails()
+	details.Module.Verified = namespace.Verified
+	return details, nil
+}
+
+func (s *S3ModuleService) DownloadUrl(modul service.ModuleDescriptor, version string) (string, error) {
 
 	ctx := context.Background()
+	_, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(buildS3Key(modul, version)),
+	})
+	if err != nil {
+		return "", mapS3Error(err, versionSubject(modul, version))
+	}
 	req, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
 		Key:    aws.String(buildS3Key(modul, version)),
@@ -61,15 +306,89 @@ func (s *S3ModuleService) GetModuleDownloadUrl(modul service.ModuleDescriptor, v
 	return req.URL, nil
 }
 
-// implment upload
-func (s *S3ModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader) error {
+// implment upload, the archive is written first so a metadata document always points to an existing archive.
+// Published versions are immutable, the archive is only created if it does not exist yet unless the upload is forced
+func (s *S3ModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader, options service.UploadOptions) error {
 	ctx := context.Background()
-	_, err := s.s3.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(buildS3Key(modul, version)),
-		Body:   content,
+
+	version, err := service.NormalizeVersion(version)
+	if err != nil {
+		return err
+	}
+
+	// the sdk needs a seekable body to sign the payload, request bodies are not
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	inspection, err := archive.Inspect(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", service.ErrInvalidArchive, err)
+	}
+
+	metadata := inspection.Metadata(modul, version, options.Publisher, time.Now().UTC())
+	if !options.PublishedAt.IsZero() {
+		metadata.PublishedAt = options.PublishedAt.UTC()
+	}
+
+	if options.Force {
+		previous, err := s.getMetadata(ctx, modul, version)
+		if err != nil && !errors.Is(err, service.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			metadata.Replaces(previous, options.Publisher)
+		}
+	}
+	if err := s.PutArchive(modul, version, data, options.Force); err != nil {
+		return err
+	}
+
+	encoded, err := service.EncodeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.s3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(buildMetadataKey(modul, version)),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
 	})
-	return err
+	return mapS3Error(err, versionSubject(modul, version))
+}
+
+// PutArchive stores the archive of a version, unless overwrite is set only if the version has no archive yet
+func (s *S3ModuleService) PutArchive(modul service.ModuleDescriptor, version string, data []byte, overwrite bool) error {
+	ctx := context.Background()
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(buildS3Key(modul, version)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/zip"),
+	}
+	if overwrite {
+		_, err := s.s3.PutObject(ctx, input)
+		return mapS3Error(err, versionSubject(modul, version))
+	}
+	return s.createArchive(ctx, modul, version, input)
+}
+
+// createArchive writes the archive only if there is none yet. The head request gives a clear error for the common case,
+// the If-None-Match condition makes concurrent uploads of the same version fail on the s3 side instead of overwriting each other
+func (s *S3ModuleService) createArchive(ctx context.Context, modul service.ModuleDescriptor, version string, input *s3.PutObjectInput) error {
+	_, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+	})
+	if err == nil {
+		return fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrAlreadyExists)
+	}
+	if err = mapS3Error(err, versionSubject(modul, version)); !errors.Is(err, service.ErrNotFound) {
+		return err
+	}
+
+	_, err = s.s3.PutObject(ctx, input, s3.WithAPIOptions(smithyhttp.SetHeaderValue("If-None-Match", "*")))
+	return mapS3Error(err, versionSubject(modul, version))
 }
 
 func NewS3ModuleService(s3Client *s3.Client, bucketName string, presignClient *s3.PresignClient) *S3ModuleService {
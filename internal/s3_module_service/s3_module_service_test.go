@@ -3,7 +3,11 @@ package s3moduleservice
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"testing"
 
@@ -11,7 +15,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/testcontainers/testcontainers-go"
@@ -105,7 +112,7 @@ func TestDownloadUrl(t *testing.T) {
 	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "aws", "aws", "3.0.0")
 
 	s3Service := NewS3ModuleService(s3Client, bucketName, presignClient)
-	result, err := s3Service.GetModuleDownloadUrl(service.ModuleDescriptor{
+	result, err := s3Service.DownloadUrl(service.ModuleDescriptor{
 		Namespace: "hashicorp",
 		Name:      "aws",
 		System:    "aws",
@@ -148,7 +155,7 @@ func TestListModuleVersions(t *testing.T) {
 	})
 
 	assert.NoError(t, err)
-	assert.Equal(t, []string{"3.0.0", "3.0.1", "3.0.2"}, result)
+	assert.Equal(t, []string{"3.0.0", "3.0.1", "3.0.2"}, lo.Map(result, func(v service.ModuleVersion, _ int) string { return v.Version }))
 }
 
 func uploadArtifact(t *testing.T, s3Client *s3.Client, ctx context.Context, bucketName, namespace, name, system, version string) {
@@ -177,12 +184,19 @@ func TestUpload(t *testing.T) {
 
 	ctx := context.Background()
 
+	data := tft.ModuleZip(t, map[string]string{
+		"main.tf":                "resource \"aws_s3_bucket\" \"this\" {}",
+		"README.md":              "# AWS\n\nCreates things on AWS.\n",
+		"modules/policy/main.tf": "module \"label\" {\n  source  = \"cloudposse/label/null\"\n  version = \"0.25.0\"\n}",
+		"variables.tf":           "variable \"bucket\" {\n  type = string\n}",
+		"examples/basic/main.tf": "module \"aws\" {\n  source = \"../..\"\n}",
+	})
 	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
 	err := s3Service.UploadModule(service.ModuleDescriptor{
 		Namespace: "hashicorp",
 		Name:      "aws",
 		System:    "aws",
-	}, "3.0.0", bytes.NewReader([]byte("module data")))
+	}, "3.0.0", bytes.NewReader(data), service.UploadOptions{})
 	assert.NoError(t, err)
 
 	_, err = s3Client.GetObject(ctx, &s3.GetObjectInput{
@@ -190,4 +204,415 @@ func TestUpload(t *testing.T) {
 		Key:    aws.String("modules/namespaces/hashicorp/aws/aws/3.0.0/module.zip"),
 	})
 	assert.NoError(t, err)
+
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String("modules/namespaces/hashicorp/aws/aws/3.0.0/metadata.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metadata, err := service.DecodeMetadata(resp.Body)
+	assert.NoError(t, err)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), metadata.SHA256)
+	assert.Equal(t, int64(len(data)), metadata.Size)
+	assert.Equal(t, "Creates things on AWS.", metadata.Description)
+	assert.False(t, metadata.PublishedAt.IsZero())
+	assert.Equal(t, []string{"aws"}, lo.Map(metadata.Root.Providers, func(p service.ProviderDependency, _ int) string { return p.Name }))
+	if assert.Len(t, metadata.Submodules, 1) {
+		assert.Equal(t, "modules/policy", metadata.Submodules[0].Path)
+		assert.Equal(t, []service.ModuleDependency{{Name: "label", Source: "cloudposse/label/null", Version: "0.25.0"}}, metadata.Submodules[0].Dependencies)
+	}
+
+	assert.Equal(t, []service.Input{{Name: "bucket", Type: "string", Required: true}}, metadata.Root.Inputs)
+	assert.Equal(t, "# AWS\n\nCreates things on AWS.\n", metadata.Root.Readme)
+	if assert.Len(t, metadata.Examples, 1) {
+		assert.Equal(t, "examples/basic", metadata.Examples[0].Path)
+	}
+
+	details, err := s3Service.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"}, "3.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.Root, details.Root)
+	assert.Equal(t, metadata.Examples, details.Examples)
+
+	versions, err := s3Service.Versions(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"})
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, metadata.Root, versions[0].Root)
+		assert.Equal(t, metadata.Submodules, versions[0].Submodules)
+	}
+
+	result, err := s3Service.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Modules, 1) {
+		assert.Equal(t, "Creates things on AWS.", result.Modules[0].Description)
+	}
+}
+
+func TestUploadRejectsInvalidArchive(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	err := s3Service.UploadModule(service.ModuleDescriptor{
+		Namespace: "hashicorp",
+		Name:      "aws",
+		System:    "aws",
+	}, "3.0.0", bytes.NewReader([]byte("module data")), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrInvalidArchive)
+
+	_, err = s3Service.Versions(service.ModuleDescriptor{Namespace: "hashicorp", Name: "aws", System: "aws"})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestParseS3Key(t *testing.T) {
+	descriptor, version, file, ok := parseS3Key(buildS3Key(service.ModuleDescriptor{
+		Namespace: "hashicorp",
+		Name:      "consul",
+		System:    "aws",
+	}, "0.1.0"))
+	assert.True(t, ok)
+	assert.Equal(t, service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}, descriptor)
+	assert.Equal(t, "0.1.0", version)
+	assert.Equal(t, archiveName, file)
+
+	_, _, file, ok = parseS3Key("modules/namespaces/hashicorp/consul/aws/0.1.0/metadata.json")
+	assert.True(t, ok)
+	assert.Equal(t, metadataName, file)
+
+	_, _, _, ok = parseS3Key("modules/namespaces/hashicorp/consul/aws/0.1.0/README.md")
+	assert.False(t, ok)
+	_, _, _, ok = parseS3Key("something/else/module.zip")
+	assert.False(t, ok)
+}
+
+func TestList(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.1.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.2.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "azurerm", "0.1.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "Azure", "network", "azurerm", "1.1.1")
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+
+	result, err := s3Service.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Azure/network/azurerm/1.1.1",
+		"hashicorp/consul/aws/0.2.0",
+		"hashicorp/consul/azurerm/0.1.0",
+	}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+	assert.Equal(t, service.ModuleResultMeta{Limit: 10, CurrentOffset: 0, NextOffset: 3, PrevOffset: 0}, result.Meta)
+
+	result, err = s3Service.List(service.ListParams{Limit: 10, Namespace: "hashicorp", Provider: "azurerm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/azurerm/0.1.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s3Service.List(service.ListParams{Limit: 10, Namespace: "hashicorp", Name: "consul"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.2.0", "hashicorp/consul/azurerm/0.1.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s3Service.List(service.ListParams{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.2.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+	assert.Equal(t, service.ModuleResultMeta{Limit: 1, CurrentOffset: 1, NextOffset: 2, PrevOffset: 0}, result.Meta)
+}
+
+func TestSearch(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.1.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "Azure", "network", "azurerm", "1.1.1")
+	uploadArtifact(t, s3Client, ctx, bucketName, "zoitech", "network", "aws", "0.0.3")
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+
+	result, err := s3Service.Search(service.SearchParams{Query: "network", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Azure/network/azurerm/1.1.1",
+		"zoitech/network/aws/0.0.3",
+	}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+
+	result, err = s3Service.Search(service.SearchParams{Query: "network", Provider: "aws", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zoitech/network/aws/0.0.3"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+}
+
+func TestMapS3Error(t *testing.T) {
+	assert.NoError(t, mapS3Error(nil, "module"))
+	assert.ErrorIs(t, mapS3Error(&smithy.GenericAPIError{Code: "NotFound"}, "module"), service.ErrNotFound)
+	assert.ErrorIs(t, mapS3Error(&smithy.GenericAPIError{Code: "NoSuchKey"}, "module"), service.ErrNotFound)
+	assert.ErrorIs(t, mapS3Error(&smithy.GenericAPIError{Code: "AccessDenied"}, "module"), service.ErrForbidden)
+	assert.ErrorIs(t, mapS3Error(&smithy.GenericAPIError{Code: "NoSuchBucket"}, "module"), service.ErrBackendUnavailable)
+	assert.ErrorIs(t, mapS3Error(&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "module"), service.ErrBackendUnavailable)
+	assert.EqualError(t, mapS3Error(errors.New("boom"), "module"), "boom")
+}
+
+func TestDownloadUrlNotFound(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, presignClient := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, presignClient)
+	_, err := s3Service.DownloadUrl(service.ModuleDescriptor{
+		Namespace: "hashicorp",
+		Name:      "aws",
+		System:    "aws",
+	}, "3.0.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	_, err = s3Service.Versions(service.ModuleDescriptor{
+		Namespace: "hashicorp",
+		Name:      "aws",
+		System:    "aws",
+	})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestVersionsAreSortedBySemver(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	for _, version := range []string{"1.10.0", "v1.2.0", "1.9.0", "2.0.0-beta.1"} {
+		err := s3Service.UploadModule(descriptor, version, bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{})
+		assert.NoError(t, err)
+	}
+	err := s3Service.UploadModule(descriptor, "latest", bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": ""})), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrInvalidVersion)
+
+	versions, err := s3Service.Versions(descriptor)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.9.0", "1.10.0", "2.0.0-beta.1"}, lo.Map(versions, func(v service.ModuleVersion, _ int) string { return v.Version }))
+
+	result, err := s3Service.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Modules, 1) {
+		assert.Equal(t, "1.10.0", result.Modules[0].Version)
+	}
+}
+
+func TestUploadPublishedVersionIsImmutable(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	first := tft.ModuleZip(t, map[string]string{"main.tf": ""})
+	err := s3Service.UploadModule(descriptor, "1.2.0", bytes.NewReader(first), service.UploadOptions{Publisher: "alice"})
+	assert.NoError(t, err)
+
+	second := tft.ModuleZip(t, map[string]string{"main.tf": "", "outputs.tf": ""})
+	err = s3Service.UploadModule(descriptor, "1.2.0", bytes.NewReader(second), service.UploadOptions{})
+	assert.ErrorIs(t, err, service.ErrAlreadyExists)
+
+	ctx := context.Background()
+	original, err := s3Service.getMetadata(ctx, descriptor, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(first)
+	assert.Equal(t, hex.EncodeToString(sum[:]), original.SHA256)
+
+	err = s3Service.UploadModule(descriptor, "1.2.0", bytes.NewReader(second), service.UploadOptions{Force: true, Publisher: "admin"})
+	assert.NoError(t, err)
+
+	replaced, err := s3Service.getMetadata(ctx, descriptor, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256(second)
+	assert.Equal(t, hex.EncodeToString(sum[:]), replaced.SHA256)
+	assert.Equal(t, "alice", replaced.Owner)
+	assert.True(t, original.PublishedAt.Equal(replaced.PublishedAt))
+	if assert.Len(t, replaced.Overwrites, 1) {
+		assert.Equal(t, "admin", replaced.Overwrites[0].By)
+		assert.Equal(t, original.SHA256, replaced.Overwrites[0].PreviousSHA256)
+	}
+}
+
+func TestListCachesLatestMetadata(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	descriptor := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	upload := func(version, readme string, force bool) {
+		data := tft.ModuleZip(t, map[string]string{"main.tf": "", "README.md": "# Consul\n\n" + readme + "\n"})
+		assert.NoError(t, s3Service.UploadModule(descriptor, version, bytes.NewReader(data), service.UploadOptions{Force: force}))
+	}
+	descriptions := func() []string {
+		result, err := s3Service.List(service.ListParams{Limit: 10})
+		assert.NoError(t, err)
+		return lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Version + " " + m.Description })
+	}
+
+	upload("1.0.0", "First release.", false)
+	assert.Equal(t, []string{"1.0.0 First release."}, descriptions())
+
+	// a document whose etag did not change is not fetched again
+	cached := s3Service.latest[descriptor]
+	cached.metadata.Description = "Cached."
+	s3Service.latest[descriptor] = cached
+	assert.Equal(t, []string{"1.0.0 Cached."}, descriptions())
+
+	// new versions and overwritten documents are fetched
+	upload("1.1.0", "Second release.", false)
+	assert.Equal(t, []string{"1.1.0 Second release."}, descriptions())
+	upload("1.1.0", "Replaced release.", true)
+	assert.Equal(t, []string{"1.1.0 Replaced release."}, descriptions())
+}
+
+func TestGet(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.1.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.2.0")
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	details, err := s3Service.Get(consul, "0.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "hashicorp/consul/aws/0.1.0", details.Module.Id)
+
+	_, err = s3Service.Get(consul, "9.9.9")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	_, err = s3Service.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"}, "0.1.0")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestNamespaces(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	uploadArtifact(t, s3Client, ctx, bucketName, "hashicorp", "consul", "aws", "0.1.0")
+	uploadArtifact(t, s3Client, ctx, bucketName, "zoitech", "network", "aws", "0.0.3")
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+
+	namespaces, err := s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "hashicorp"}, {Name: "zoitech"}}, namespaces)
+
+	_, err = s3Service.Namespace("acme")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	assert.NoError(t, s3Service.PutNamespace(service.Namespace{Name: "acme", Verified: true}))
+	assert.NoError(t, s3Service.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+
+	namespaces, err = s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Verified: true}, {Name: "hashicorp", Verified: true}, {Name: "zoitech"}}, namespaces)
+
+	namespace, err := s3Service.Namespace("zoitech")
+	assert.NoError(t, err)
+	assert.Equal(t, service.Namespace{Name: "zoitech"}, namespace)
+
+	result, err := s3Service.List(service.ListParams{Limit: 10, Verified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.1.0"}, lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id }))
+	assert.True(t, result.Modules[0].Verified)
+
+	details, err := s3Service.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}, "0.1.0")
+	assert.NoError(t, err)
+	assert.True(t, details.Module.Verified)
+}
+
+func TestNamespacesCacheSettings(t *testing.T) {
+	//skip if short
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	cleanup, s3Client, bucketName, _ := startMinio(t)
+	defer cleanup()
+
+	s3Service := NewS3ModuleService(s3Client, bucketName, nil)
+	assert.NoError(t, s3Service.PutNamespace(service.Namespace{Name: "acme", Verified: true}))
+	namespaces, err := s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Verified: true}}, namespaces)
+
+	// a document whose etag did not change is not fetched again
+	cached := s3Service.namespaces["acme"]
+	cached.namespace.Private = true
+	s3Service.namespaces["acme"] = cached
+	namespaces, err = s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Verified: true, Private: true}}, namespaces)
+
+	// changed documents are fetched and removed ones are dropped
+	assert.NoError(t, s3Service.PutNamespace(service.Namespace{Name: "acme", Proxied: true}))
+	namespaces, err = s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Proxied: true}}, namespaces)
+	_, err = s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(buildNamespaceKey("acme"))})
+	assert.NoError(t, err)
+	namespaces, err = s3Service.Namespaces()
+	assert.NoError(t, err)
+	assert.Empty(t, namespaces)
+	assert.Empty(t, s3Service.namespaces)
 }
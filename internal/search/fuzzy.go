@@ -0,0 +1,66 @@
+package search
+
+import (
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+)
+
+// allowedEdits is the number of typos tolerated in a query word, short words have to match exactly
+func allowedEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the levenshtein distance of two words counting swapped neighbours as one edit,
+// it stops counting once the distance exceeds the limit and returns limit+1
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+	beforePrevious := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		rowMin := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = lo.Min([]int{previous[j] + 1, current[j-1] + 1, previous[j-1] + cost})
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && beforePrevious[j-2]+1 < current[j] {
+				current[j] = beforePrevious[j-2] + 1
+			}
+			if current[j] < rowMin {
+				rowMin = current[j]
+			}
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+	if previous[len(rb)] > limit {
+		return limit + 1
+	}
+	return previous[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
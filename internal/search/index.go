@@ -0,0 +1,245 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+)
+
+// Document is the latest version of a module as it is indexed
+type Document struct {
+	Module service.Module
+	Readme string
+}
+
+type field int
+
+const (
+	fieldName field = iota
+	fieldNamespace
+	fieldProvider
+	fieldDescription
+	fieldReadme
+	fieldCount
+)
+
+// fieldWeights rank matches in the module address above matches in its texts
+var fieldWeights = [fieldCount]float64{4, 2, 2, 1.5, 1}
+
+const (
+	// prefixMatch and fuzzyMatch weigh words that only start with a query word or are close to it against exact matches
+	prefixMatch = 0.7
+	fuzzyMatch  = 0.4
+	// verifiedBoost and downloadsBoost rank modules of verified namespaces and popular modules higher
+	verifiedBoost  = 1.25
+	downloadsBoost = 0.1
+)
+
+// posting counts the occurrences of a word in each field of a document
+type posting [fieldCount]int
+
+// Index is an inverted index of modules, documents are replaced one at a time when a module gets a new version
+type Index struct {
+	mu        sync.RWMutex
+	documents map[string]Document
+	terms     map[string]map[string]*posting
+}
+
+func NewIndex() *Index {
+	return &Index{documents: map[string]Document{}, terms: map[string]map[string]*posting{}}
+}
+
+// key identifies the module of a document independent of its version
+func key(m service.Module) string {
+	return m.Namespace + "/" + m.Name + "/" + m.Provider
+}
+
+// Put adds the document or replaces the document of the same module
+func (i *Index) Put(doc Document) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.put(doc)
+}
+
+// Replace drops all documents and indexes the given ones
+func (i *Index) Replace(docs []Document) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.documents = map[string]Document{}
+	i.terms = map[string]map[string]*posting{}
+	for _, doc := range docs {
+		i.put(doc)
+	}
+}
+
+func (i *Index) Remove(modul service.ModuleDescriptor) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(modul.Namespace + "/" + modul.Name + "/" + modul.System)
+}
+
+// SetVerified updates the verification of the modules of a namespace
+func (i *Index) SetVerified(namespace string, verified bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for k, doc := range i.documents {
+		if doc.Module.Namespace == namespace {
+			doc.Module.Verified = verified
+			i.documents[k] = doc
+		}
+	}
+}
+
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.documents)
+}
+
+func (i *Index) put(doc Document) {
+	k := key(doc.Module)
+	i.remove(k)
+	i.documents[k] = doc
+
+	texts := [fieldCount]string{
+		fieldName:        doc.Module.Name,
+		fieldNamespace:   doc.Module.Namespace,
+		fieldProvider:    doc.Module.Provider,
+		fieldDescription: doc.Module.Description,
+		fieldReadme:      doc.Readme,
+	}
+	for f, text := range texts {
+		for _, term := range tokenize(text) {
+			postings, ok := i.terms[term]
+			if !ok {
+				postings = map[string]*posting{}
+				i.terms[term] = postings
+			}
+			p, ok := postings[k]
+			if !ok {
+				p = &posting{}
+				postings[k] = p
+			}
+			p[f]++
+		}
+	}
+}
+
+func (i *Index) remove(k string) {
+	if _, ok := i.documents[k]; !ok {
+		return
+	}
+	delete(i.documents, k)
+	for term, postings := range i.terms {
+		delete(postings, k)
+		if len(postings) == 0 {
+			delete(i.terms, term)
+		}
+	}
+}
+
+// tokenize splits a text into lower case words, everything but letters and digits separates words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Search ranks the modules matching every word of the query, an empty query matches all modules.
+// Words match exactly, as prefix of a word or with a few typos, the ranking prefers exact matches, rare words and matches in the module address
+func (i *Index) Search(params service.SearchParams) service.ModuleResult {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	scores := map[string]float64{}
+	for k, doc := range i.documents {
+		if (params.Namespace == "" || doc.Module.Namespace == params.Namespace) &&
+			(params.Provider == "" || doc.Module.Provider == params.Provider) &&
+			(!params.Verified || doc.Module.Verified) &&
+			!lo.Contains(params.ExcludedNamespaces, doc.Module.Namespace) {
+			scores[k] = 0
+		}
+	}
+
+	for _, word := range tokenize(params.Query) {
+		wordScores := map[string]float64{}
+		for term, quality := range i.matchingTerms(word) {
+			postings := i.terms[term]
+			idf := math.Log(1 + float64(len(i.documents))/float64(len(postings)))
+			for k, p := range postings {
+				if _, ok := scores[k]; !ok {
+					continue
+				}
+				wordScores[k] = math.Max(wordScores[k], quality*idf*p.weight())
+			}
+		}
+		for k := range scores {
+			score, ok := wordScores[k]
+			if !ok {
+				delete(scores, k)
+				continue
+			}
+			scores[k] += score
+		}
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for k := range scores {
+		scores[k] = (1 + scores[k]) * i.documents[k].boost()
+		ranked = append(ranked, k)
+	}
+	sort.Slice(ranked, func(a, b int) bool {
+		if scores[ranked[a]] != scores[ranked[b]] {
+			return scores[ranked[a]] > scores[ranked[b]]
+		}
+		return ranked[a] < ranked[b]
+	})
+
+	modules := make([]service.Module, 0, len(ranked))
+	for _, k := range ranked {
+		modules = append(modules, i.documents[k].Module)
+	}
+	return service.Paginate(modules, params.Limit, params.Offset)
+}
+
+// matchingTerms returns the indexed words a query word matches with the quality of the match
+func (i *Index) matchingTerms(word string) map[string]float64 {
+	matches := map[string]float64{}
+	maxEdits := allowedEdits(word)
+	for term := range i.terms {
+		switch {
+		case term == word:
+			matches[term] = 1
+		case utf8.RuneCountInString(word) >= 2 && strings.HasPrefix(term, word):
+			matches[term] = prefixMatch
+		case maxEdits > 0 && editDistance(word, term, maxEdits) <= maxEdits:
+			matches[term] = fuzzyMatch
+		}
+	}
+	return matches
+}
+
+// weight sums the field weights of the occurrences, repeated occurrences count less than the first
+func (p *posting) weight() float64 {
+	weight := 0.0
+	for f, count := range p {
+		if count > 0 {
+			weight += fieldWeights[f] * (1 + math.Log(float64(count)))
+		}
+	}
+	return weight
+}
+
+func (d Document) boost() float64 {
+	boost := 1 + downloadsBoost*math.Log1p(float64(d.Module.Downloads))
+	if d.Module.Verified {
+		boost *= verifiedBoost
+	}
+	return boost
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func document(namespace, name, provider, description string) Document {
+	return Document{Module: service.Module{
+		Id:          namespace + "/" + name + "/" + provider + "/1.0.0",
+		Namespace:   namespace,
+		Name:        name,
+		Provider:    provider,
+		Version:     "1.0.0",
+		Description: description,
+	}}
+}
+
+func ids(result service.ModuleResult) []string {
+	return lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id })
+}
+
+func testIndex() *Index {
+	index := NewIndex()
+	index.Replace([]Document{
+		document("hashicorp", "consul", "aws", "Deploys a Consul cluster on EC2 instances"),
+		document("hashicorp", "vault", "aws", "Highly available secret storage backed by consul"),
+		document("terraform-aws-modules", "vpc", "aws", "Creates VPC resources on AWS"),
+		document("Azure", "network", "azurerm", "Creates a virtual network in Azure"),
+		document("zoitech", "network", "aws", "A network with public and private subnets"),
+	})
+	return index
+}
+
+func TestSearchTokenizesQueryAndFields(t *testing.T) {
+	index := testIndex()
+
+	result := index.Search(service.SearchParams{Query: "Consul Cluster", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	// the namespace is split into words
+	result = index.Search(service.SearchParams{Query: "modules", Limit: 10})
+	assert.Equal(t, []string{"terraform-aws-modules/vpc/aws/1.0.0"}, ids(result))
+
+	result = index.Search(service.SearchParams{Query: "kubernetes", Limit: 10})
+	assert.Empty(t, result.Modules)
+}
+
+func TestSearchMatchesPrefixesAndTypos(t *testing.T) {
+	index := testIndex()
+
+	result := index.Search(service.SearchParams{Query: "netw", Limit: 10})
+	assert.ElementsMatch(t, []string{"Azure/network/azurerm/1.0.0", "zoitech/network/aws/1.0.0"}, ids(result))
+
+	result = index.Search(service.SearchParams{Query: "secert", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0"}, ids(result))
+
+	// short words have to match exactly or as prefix
+	result = index.Search(service.SearchParams{Query: "vpx", Limit: 10})
+	assert.Empty(t, result.Modules)
+}
+
+func TestSearchRanksByRelevance(t *testing.T) {
+	index := testIndex()
+
+	// a match in the name ranks above a match in the description
+	result := index.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0", "hashicorp/vault/aws/1.0.0"}, ids(result))
+
+	// an exact match ranks above a fuzzy match
+	index.Put(document("acme", "vaults", "aws", ""))
+	result = index.Search(service.SearchParams{Query: "vault", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0", "acme/vaults/aws/1.0.0"}, ids(result))
+}
+
+func TestSearchBoostsDownloadsAndVerifiedNamespaces(t *testing.T) {
+	index := testIndex()
+
+	result := index.Search(service.SearchParams{Query: "network", Limit: 10})
+	assert.Equal(t, []string{"Azure/network/azurerm/1.0.0", "zoitech/network/aws/1.0.0"}, ids(result))
+
+	popular := document("zoitech", "network", "aws", "A network with public and private subnets")
+	popular.Module.Downloads = 1000
+	index.Put(popular)
+	result = index.Search(service.SearchParams{Query: "network", Limit: 10})
+	assert.Equal(t, []string{"zoitech/network/aws/1.0.0", "Azure/network/azurerm/1.0.0"}, ids(result))
+
+	verified := document("Azure", "network", "azurerm", "Creates a virtual network in Azure")
+	verified.Module.Downloads = 1000
+	index.Put(verified)
+	index.SetVerified("Azure", true)
+	result = index.Search(service.SearchParams{Query: "network", Limit: 10})
+	assert.Equal(t, []string{"Azure/network/azurerm/1.0.0", "zoitech/network/aws/1.0.0"}, ids(result))
+
+	result = index.Search(service.SearchParams{Query: "network", Limit: 10, Verified: true})
+	assert.Equal(t, []string{"Azure/network/azurerm/1.0.0"}, ids(result))
+}
+
+func TestSearchFiltersAndPaginates(t *testing.T) {
+	index := testIndex()
+
+	result := index.Search(service.SearchParams{Query: "network", Provider: "aws", Limit: 10})
+	assert.Equal(t, []string{"zoitech/network/aws/1.0.0"}, ids(result))
+
+	result = index.Search(service.SearchParams{Query: "", Namespace: "hashicorp", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0", "hashicorp/vault/aws/1.0.0"}, ids(result))
+
+	result = index.Search(service.SearchParams{Query: "aws", Limit: 1, Offset: 1})
+	assert.Len(t, result.Modules, 1)
+	assert.Equal(t, service.ModuleResultMeta{Limit: 1, CurrentOffset: 1, NextOffset: 2, PrevOffset: 0}, result.Meta)
+}
+
+func TestPutReplacesTheDocumentOfTheModule(t *testing.T) {
+	index := testIndex()
+
+	updated := document("hashicorp", "consul", "aws", "Service mesh")
+	updated.Module.Id = "hashicorp/consul/aws/2.0.0"
+	updated.Readme = "# Consul\n\nRuns on Kubernetes"
+	index.Put(updated)
+	assert.Equal(t, 5, index.Len())
+
+	result := index.Search(service.SearchParams{Query: "cluster", Limit: 10})
+	assert.Empty(t, result.Modules)
+	result = index.Search(service.SearchParams{Query: "kubernetes mesh", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/consul/aws/2.0.0"}, ids(result))
+
+	index.Remove(service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"})
+	result = index.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0"}, ids(result))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("network", "network", 2))
+	assert.Equal(t, 1, editDistance("netwrk", "network", 2))
+	assert.Equal(t, 1, editDistance("secert", "secret", 2))
+	assert.Equal(t, 2, editDistance("scerte", "secret", 2))
+	assert.Equal(t, 3, editDistance("consul", "vault", 2))
+	assert.Equal(t, 1, editDistance("grün", "grun", 1))
+}
@@ -0,0 +1,225 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+var (
+	_ service.ModuleService  = (*IndexedModuleService)(nil)
+	_ service.ArchiveStore   = (*IndexedModuleService)(nil)
+	_ service.NamespaceStore = (*IndexedModuleService)(nil)
+)
+
+// IndexedModuleService answers searches from an index of the latest versions of the wrapped module service.
+// The index is built on the first search and updated on every upload,
+// it is rebuilt after the refresh interval to pick up uploads of other registry instances sharing the storage.
+// Searches are answered from the previous index while it is rebuilt
+type IndexedModuleService struct {
+	service.ModuleService
+	Index           *Index
+	RefreshInterval time.Duration
+
+	// building is held while the wrapped module service is scanned for the documents of the index
+	building sync.Mutex
+	mu       sync.Mutex
+	builtAt  time.Time
+	// changes are recorded while the index is rebuilt and applied to the rebuilt index, nil when it is not rebuilt
+	changes []func(*Index)
+	now     func() time.Time
+}
+
+func NewIndexedModuleService(modules service.ModuleService) *IndexedModuleService {
+	return &IndexedModuleService{
+		ModuleService:   modules,
+		Index:           NewIndex(),
+		RefreshInterval: 5 * time.Minute,
+		now:             time.Now,
+	}
+}
+
+func (s *IndexedModuleService) Search(params service.SearchParams) (service.ModuleResult, error) {
+	if err := s.ensureIndex(); err != nil {
+		return service.ModuleResult{}, err
+	}
+	return s.Index.Search(params), nil
+}
+
+// UploadModule indexes the latest version of the module after the upload, the index is rebuilt on the next search if that fails
+func (s *IndexedModuleService) UploadModule(modul service.ModuleDescriptor, version string, content io.Reader, options service.UploadOptions) error {
+	if err := s.ModuleService.UploadModule(modul, version, content, options); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	indexed := !s.builtAt.IsZero() || s.changes != nil
+	s.mu.Unlock()
+	if !indexed {
+		return nil
+	}
+	doc, err := s.document(modul)
+	if err != nil {
+		s.mu.Lock()
+		s.builtAt = time.Time{}
+		s.mu.Unlock()
+		return nil
+	}
+	s.apply(func(index *Index) { index.Put(doc) })
+	return nil
+}
+
+func (s *IndexedModuleService) OpenArchive(modul service.ModuleDescriptor, version string) (io.ReadSeekCloser, time.Time, error) {
+	store, ok := s.ModuleService.(service.ArchiveStore)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("module %s/%s/%s %s: archive is not served by the registry: %w", modul.Namespace, modul.Name, modul.System, version, service.ErrNotFound)
+	}
+	return store.OpenArchive(modul, version)
+}
+
+func (s *IndexedModuleService) Namespaces() ([]service.Namespace, error) {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Namespaces()
+}
+
+func (s *IndexedModuleService) Namespace(name string) (service.Namespace, error) {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return service.Namespace{}, err
+	}
+	return store.Namespace(name)
+}
+
+// PutNamespace updates the verification of the indexed modules of the namespace along with its settings
+func (s *IndexedModuleService) PutNamespace(namespace service.Namespace) error {
+	store, err := s.namespaceStore()
+	if err != nil {
+		return err
+	}
+	if err := store.PutNamespace(namespace); err != nil {
+		return err
+	}
+	s.apply(func(index *Index) { index.SetVerified(namespace.Name, namespace.Verified) })
+	return nil
+}
+
+// apply changes the index and records the change for the index that is being rebuilt
+func (s *IndexedModuleService) apply(change func(*Index)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(s.Index)
+	if s.changes != nil {
+		s.changes = append(s.changes, change)
+	}
+}
+
+func (s *IndexedModuleService) namespaceStore() (service.NamespaceStore, error) {
+	store, ok := s.ModuleService.(service.NamespaceStore)
+	if !ok {
+		return nil, fmt.Errorf("namespaces are not managed by the module storage: %w", service.ErrNotFound)
+	}
+	return store, nil
+}
+
+// ensureIndex builds the index if it was not built yet or is older than the refresh interval.
+// The module service is scanned without holding the lock, the documents replace the index at once when the scan is done
+func (s *IndexedModuleService) ensureIndex() error {
+	built, fresh := s.state()
+	if fresh {
+		return nil
+	}
+	if !built {
+		s.building.Lock()
+	} else if !s.building.TryLock() {
+		// another search rebuilds the index
+		return nil
+	}
+	defer s.building.Unlock()
+	if _, fresh := s.state(); fresh {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := s.now()
+	s.changes = []func(*Index){}
+	s.mu.Unlock()
+
+	docs, err := s.documents()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := s.changes
+	s.changes = nil
+	if err != nil {
+		return err
+	}
+	s.Index.Replace(docs)
+	// uploads and namespace changes during the scan may be missing in the documents
+	for _, change := range changes {
+		change(s.Index)
+	}
+	s.builtAt = now
+	return nil
+}
+
+// state tells if the index was built and if it is younger than the refresh interval
+func (s *IndexedModuleService) state() (built bool, fresh bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	built = !s.builtAt.IsZero()
+	return built, built && (s.RefreshInterval <= 0 || s.now().Sub(s.builtAt) < s.RefreshInterval)
+}
+
+// documents pages through the latest versions of all modules and loads their readmes
+func (s *IndexedModuleService) documents() ([]Document, error) {
+	docs := []Document{}
+	for offset := 0; ; {
+		result, err := s.ModuleService.List(service.ListParams{Limit: service.MaxLimit, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range result.Modules {
+			details, err := s.ModuleService.Get(service.ModuleDescriptor{Namespace: m.Namespace, Name: m.Name, System: m.Provider}, m.Version)
+			if errors.Is(err, service.ErrNotFound) {
+				// removed since it was listed
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, Document{Module: m, Readme: details.Root.Readme})
+		}
+		if len(result.Modules) == 0 || result.Meta.NextOffset <= offset {
+			return docs, nil
+		}
+		offset = result.Meta.NextOffset
+	}
+}
+
+// document loads the latest version of a module
+func (s *IndexedModuleService) document(modul service.ModuleDescriptor) (Document, error) {
+	versions, err := s.ModuleService.Versions(modul)
+	if err != nil {
+		return Document{}, err
+	}
+	numbers := make([]string, 0, len(versions))
+	for _, v := range versions {
+		numbers = append(numbers, v.Version)
+	}
+	latest, ok := service.LatestVersion(numbers)
+	if !ok {
+		return Document{}, fmt.Errorf("module %s/%s/%s: %w", modul.Namespace, modul.Name, modul.System, service.ErrNotFound)
+	}
+	details, err := s.ModuleService.Get(modul, latest)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{Module: details.Module, Readme: details.Root.Readme}, nil
+}
@@ -0,0 +1,146 @@
+package search
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	filesystemmoduleservice "github.com/mxab/tf-registry/internal/filesystem_module_service"
+	"github.com/mxab/tf-registry/internal/module/service"
+	tft "github.com/mxab/tf-registry/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func upload(t *testing.T, s service.ModuleService, modul service.ModuleDescriptor, version, readme string) {
+	t.Helper()
+	if err := s.UploadModule(modul, version, bytes.NewReader(tft.ModuleZip(t, map[string]string{"main.tf": "", "README.md": readme})), service.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexedModuleServiceIndexesUploads(t *testing.T) {
+	storage, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	vault := service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"}
+	upload(t, storage, consul, "1.0.0", "# Consul\n\nDeploys a Consul cluster\n\nUses autoscaling groups")
+
+	s := NewIndexedModuleService(storage)
+
+	// the index is built from the storage on the first search, readmes included
+	result, err := s.Search(service.SearchParams{Query: "autoscaling", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	// uploads replace the document of the module
+	upload(t, s, consul, "2.0.0", "# Consul\n\nDeploys a Consul service mesh")
+	upload(t, s, vault, "1.0.0", "# Vault\n\nSecret storage")
+
+	result, err = s.Search(service.SearchParams{Query: "autoscaling", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+
+	result, err = s.Search(service.SearchParams{Query: "mesh", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/2.0.0"}, ids(result))
+
+	result, err = s.Search(service.SearchParams{Query: "secret", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0"}, ids(result))
+	assert.Equal(t, 2, s.Index.Len())
+
+	// an upload of a prerelease does not replace the latest version
+	upload(t, s, consul, "3.0.0-beta", "# Consul\n\nBeta")
+	result, err = s.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/2.0.0"}, ids(result))
+
+	// archives are still served from the storage
+	archive, _, err := s.OpenArchive(vault, "1.0.0")
+	assert.NoError(t, err)
+	archive.Close()
+}
+
+func TestIndexedModuleServiceRefreshesTheIndex(t *testing.T) {
+	storage, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s := NewIndexedModuleService(storage)
+	s.now = func() time.Time { return now }
+
+	result, err := s.Search(service.SearchParams{Query: "vault", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+
+	// uploaded by another registry instance sharing the storage
+	upload(t, storage, service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"}, "1.0.0", "# Vault")
+
+	result, err = s.Search(service.SearchParams{Query: "vault", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+
+	now = now.Add(s.RefreshInterval)
+	result, err = s.Search(service.SearchParams{Query: "vault", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0"}, ids(result))
+}
+
+// blockingModuleService holds the first page of listings until it is released
+type blockingModuleService struct {
+	service.ModuleService
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingModuleService) List(params service.ListParams) (service.ModuleResult, error) {
+	result, err := s.ModuleService.List(params)
+	if s.listing != nil && params.Offset == 0 {
+		s.listing <- struct{}{}
+		<-s.release
+	}
+	return result, err
+}
+
+func TestIndexedModuleServiceSearchesWhileTheIndexIsRebuilt(t *testing.T) {
+	storage, err := filesystemmoduleservice.NewFilesystemModuleService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	upload(t, storage, consul, "1.0.0", "# Consul")
+	blocking := &blockingModuleService{ModuleService: storage}
+	now := time.Now()
+	s := NewIndexedModuleService(blocking)
+	s.now = func() time.Time { return now }
+
+	result, err := s.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	blocking.listing, blocking.release = make(chan struct{}), make(chan struct{})
+	now = now.Add(s.RefreshInterval)
+	rebuilt := make(chan error)
+	go func() {
+		_, err := s.Search(service.SearchParams{Query: "consul", Limit: 10})
+		rebuilt <- err
+	}()
+	<-blocking.listing
+
+	// the previous index answers searches while the storage is scanned
+	result, err = s.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	// uploads after the listing are kept in the rebuilt index
+	upload(t, s, service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"}, "1.0.0", "# Vault")
+	close(blocking.release)
+	assert.NoError(t, <-rebuilt)
+
+	result, err = s.Search(service.SearchParams{Query: "vault", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/vault/aws/1.0.0"}, ids(result))
+}
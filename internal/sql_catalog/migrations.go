@@ -0,0 +1,95 @@
+package sqlcatalog
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the migrations of the dialect, their file names start with the version they migrate to
+func (c *Catalog) loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(c.dialect.Migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	result := []migration{}
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version: %w", name, err)
+		}
+		content, err := fs.ReadFile(c.dialect.Migrations, file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, migration{version: version, name: name, sql: string(content)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	return result, nil
+}
+
+// Migrate applies all migrations that were not applied yet, each in its own transaction
+func (c *Catalog) Migrate(ctx context.Context) error {
+	pending, err := c.loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return c.mapError(err)
+	}
+	defer conn.Close()
+
+	if c.dialect.LockMigrations != nil {
+		unlock, err := c.dialect.LockMigrations(ctx, conn)
+		if err != nil {
+			return c.mapError(err)
+		}
+		defer unlock()
+	}
+
+	if _, err := conn.ExecContext(ctx, c.dialect.MigrationsTable); err != nil {
+		return c.mapError(err)
+	}
+
+	for _, m := range pending {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return c.mapError(err)
+		}
+		var applied int
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM schema_migrations WHERE version = $1", m.version).Scan(&applied); err != nil {
+			tx.Rollback()
+			return c.mapError(err)
+		}
+		if applied > 0 {
+			tx.Rollback()
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name); err != nil {
+			tx.Rollback()
+			return c.mapError(err)
+		}
+		if err := tx.Commit(); err != nil {
+			return c.mapError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package sqlcatalog
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/mxab/tf-registry/internal/catalog"
+	"github.com/mxab/tf-registry/internal/module/service"
+)
+
+var _ catalog.Catalog = (*Catalog)(nil)
+
+// Dialect adapts the queries of the catalog to a database, the queries use $1 style placeholders
+type Dialect struct {
+	// Migrations holds the schema of the database as migrations/<version>_<name>.sql files
+	Migrations fs.FS
+	// MigrationsTable creates the table recording the applied migrations if it does not exist
+	MigrationsTable string
+	// LockMigrations keeps other registry instances from migrating until unlock is called, nil if the transactions lock the database
+	LockMigrations func(ctx context.Context, conn *sql.Conn) (unlock func(), err error)
+	// Collate makes ORDER BY sort text bytewise like the other module services
+	Collate string
+	// ExcludedNamespaces is the condition keeping modules out of the namespaces passed as $4
+	ExcludedNamespaces string
+	// Excluded encodes the excluded namespaces for ExcludedNamespaces
+	Excluded func(namespaces []string) any
+	// Search returns the condition matching the query and its arguments, which are numbered from $5
+	Search func(query string) (string, []any)
+	// IndexLatest updates the search index after the latest version of a module changed, nil without index
+	IndexLatest func(ctx context.Context, tx *sql.Tx, moduleId int64, latest string) error
+	// MapError translates the errors of the driver, nil keeps them
+	MapError func(err error) error
+}
+
+// Catalog keeps the module metadata in the tables of the migrations of its dialect
+type Catalog struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func New(db *sql.DB, dialect Dialect) *Catalog {
+	return &Catalog{db: db, dialect: dialect}
+}
+
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+func (c *Catalog) mapError(err error) error {
+	if err == nil || c.dialect.MapError == nil {
+		return err
+	}
+	return c.dialect.MapError(err)
+}
+
+func moduleSubject(module service.ModuleDescriptor) string {
+	return fmt.Sprintf("module %s/%s/%s", module.Namespace, module.Name, module.System)
+}
+
+func versionSubject(module service.ModuleDescriptor, version string) string {
+	return fmt.Sprintf("%s %s", moduleSubject(module), version)
+}
+
+// EscapeLike makes the wildcards of a like pattern match literally with \ as escape character
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// latestModulesQuery joins every module with its latest version, filters are appended to the where clause
+func (c *Catalog) latestModulesQuery() string {
+	return `
+	FROM modules m
+	JOIN namespaces n ON n.name = m.namespace
+	JOIN module_versions v ON v.module_id = m.id AND v.version = m.latest_version
+	WHERE ($1 = '' OR m.namespace = $1) AND ($2 = '' OR m.system = $2) AND ($3 = false OR n.verified)
+		AND ` + c.dialect.ExcludedNamespaces
+}
+
+func (c *Catalog) List(params service.ListParams) (service.ModuleResult, error) {
+	return c.latestModules(params.Limit, params.Offset, "AND ($5 = '' OR m.name = $5)",
+		params.Namespace, params.Provider, params.Verified, c.dialect.Excluded(params.ExcludedNamespaces), params.Name)
+}
+
+func (c *Catalog) Search(params service.SearchParams) (service.ModuleResult, error) {
+	filter, args := c.dialect.Search(params.Query)
+	return c.latestModules(params.Limit, params.Offset, "AND "+filter,
+		append([]any{params.Namespace, params.Provider, params.Verified, c.dialect.Excluded(params.ExcludedNamespaces)}, args...)...)
+}
+
+// latestModules pages through the latest versions ordered like the other module services order by id
+func (c *Catalog) latestModules(limit, offset int, filter string, args ...any) (service.ModuleResult, error) {
+	ctx := context.Background()
+
+	var total int
+	if err := c.db.QueryRowContext(ctx, "SELECT count(*)"+c.latestModulesQuery()+" "+filter, args...).Scan(&total); err != nil {
+		return service.ModuleResult{}, c.mapError(err)
+	}
+	meta := service.PageMeta(total, limit, offset)
+
+	rows, err := c.db.QueryContext(ctx, "SELECT v.metadata, m.downloads, n.verified"+c.latestModulesQuery()+" "+filter+
+		fmt.Sprintf(` ORDER BY (m.namespace || '/' || m.name || '/' || m.system)%s LIMIT $%d OFFSET $%d`, c.dialect.Collate, len(args)+1, len(args)+2),
+		append(args, meta.Limit, meta.CurrentOffset)...)
+	if err != nil {
+		return service.ModuleResult{}, c.mapError(err)
+	}
+	defer rows.Close()
+
+	modules := []service.Module{}
+	for rows.Next() {
+		metadata, downloads, verified, err := c.scanMetadata(rows)
+		if err != nil {
+			return service.ModuleResult{}, err
+		}
+		module := metadata.Module()
+		module.Downloads = downloads
+		module.Verified = verified
+		modules = append(modules, module)
+	}
+	if err := rows.Err(); err != nil {
+		return service.ModuleResult{}, c.mapError(err)
+	}
+	return service.ModuleResult{Meta: meta, Modules: modules}, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMetadata reads a row of the metadata document, a download count and the verification of the namespace
+func (c *Catalog) scanMetadata(row scanner) (service.ModuleMetadata, int, bool, error) {
+	var document []byte
+	var downloads int
+	var verified bool
+	if err := row.Scan(&document, &downloads, &verified); err != nil {
+		return service.ModuleMetadata{}, 0, false, c.mapError(err)
+	}
+	metadata, err := service.DecodeMetadata(bytes.NewReader(document))
+	return metadata, downloads, verified, err
+}
+
+func (c *Catalog) versions(ctx context.Context, modul service.ModuleDescriptor) ([]service.ModuleMetadata, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT v.metadata, v.downloads, n.verified
+		FROM modules m
+		JOIN namespaces n ON n.name = m.namespace
+		JOIN module_versions v ON v.module_id = m.id
+		WHERE m.namespace = $1 AND m.name = $2 AND m.system = $3`,
+		modul.Namespace, modul.Name, modul.System)
+	if err != nil {
+		return nil, c.mapError(err)
+	}
+	defer rows.Close()
+
+	versions := map[string]service.ModuleMetadata{}
+	numbers := []string{}
+	for rows.Next() {
+		metadata, _, _, err := c.scanMetadata(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions[metadata.Version] = metadata
+		numbers = append(numbers, metadata.Version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, c.mapError(err)
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("%s: %w", moduleSubject(modul), service.ErrNotFound)
+	}
+
+	service.SortVersions(numbers)
+	result := []service.ModuleMetadata{}
+	for _, number := range numbers {
+		result = append(result, versions[number])
+	}
+	return result, nil
+}
+
+func (c *Catalog) Versions(modul service.ModuleDescriptor) ([]service.ModuleVersion, error) {
+	versions, err := c.versions(context.Background(), modul)
+	if err != nil {
+		return nil, err
+	}
+	result := []service.ModuleVersion{}
+	for _, metadata := range versions {
+		result = append(result, metadata.ModuleVersion())
+	}
+	return result, nil
+}
+
+func (c *Catalog) Get(modul service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+	metadata, downloads, verified, err := c.version(context.Background(), modul, version)
+	if err != nil {
+		return service.ModuleDetails{}, err
+	}
+	details := metadata.ModuleDetails()
+	details.Module.Downloads = downloads
+	details.Module.Verified = verified
+	return details, nil
+}
+
+func (c *Catalog) Metadata(modul service.ModuleDescriptor, version string) (service.ModuleMetadata, error) {
+	metadata, _, _, err := c.version(context.Background(), modul, version)
+	return metadata, err
+}
+
+// version returns the metadata of a version together with the downloads of the module and the verification of its namespace
+func (c *Catalog) version(ctx context.Context, modul service.ModuleDescriptor, version string) (service.ModuleMetadata, int, bool, error) {
+	metadata, downloads, verified, err := c.scanMetadata(c.db.QueryRowContext(ctx, `SELECT v.metadata, m.downloads, n.verified
+		FROM modules m
+		JOIN namespaces n ON n.name = m.namespace
+		JOIN module_versions v ON v.module_id = m.id
+		WHERE m.namespace = $1 AND m.name = $2 AND m.system = $3 AND v.version = $4`,
+		modul.Namespace, modul.Name, modul.System, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return metadata, 0, false, fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrNotFound)
+	}
+	return metadata, downloads, verified, err
+}
+
+// Publish adds the version in one transaction that locks the module row, or the database where rows cannot be locked,
+// so concurrent publishes of a module see each others versions when they update the latest version
+func (c *Catalog) Publish(metadata service.ModuleMetadata, replace bool) error {
+	ctx := context.Background()
+	modul := metadata.Descriptor()
+	document, err := service.EncodeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return c.mapError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO namespaces (name) VALUES ($1) ON CONFLICT DO NOTHING", modul.Namespace); err != nil {
+		return c.mapError(err)
+	}
+	var moduleId int64
+	if err := tx.QueryRowContext(ctx, `INSERT INTO modules (namespace, name, system, latest_version) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (namespace, name, system) DO UPDATE SET latest_version = modules.latest_version
+		RETURNING id`,
+		modul.Namespace, modul.Name, modul.System, metadata.Version).Scan(&moduleId); err != nil {
+		return c.mapError(err)
+	}
+
+	insert := `INSERT INTO module_versions (module_id, version, owner, description, sha256, size, published_at, updated_at, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	if replace {
+		insert += ` ON CONFLICT (module_id, version) DO UPDATE SET
+			owner = EXCLUDED.owner, description = EXCLUDED.description, sha256 = EXCLUDED.sha256, size = EXCLUDED.size,
+			published_at = EXCLUDED.published_at, updated_at = EXCLUDED.updated_at, metadata = EXCLUDED.metadata`
+	} else {
+		insert += " ON CONFLICT (module_id, version) DO NOTHING"
+	}
+	result, err := tx.ExecContext(ctx, insert, moduleId, metadata.Version, metadata.Owner, metadata.Description,
+		metadata.SHA256, metadata.Size, metadata.PublishedAt, metadata.UpdatedAt, string(document))
+	if err != nil {
+		return c.mapError(err)
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return c.mapError(err)
+	} else if inserted == 0 {
+		return fmt.Errorf("%s: %w", versionSubject(modul, metadata.Version), service.ErrAlreadyExists)
+	}
+
+	if err := c.updateLatestVersion(ctx, tx, moduleId); err != nil {
+		return err
+	}
+	return c.mapError(tx.Commit())
+}
+
+// updateLatestVersion picks the latest version by semver precedence, which the databases cannot order by
+func (c *Catalog) updateLatestVersion(ctx context.Context, tx *sql.Tx, moduleId int64) error {
+	rows, err := tx.QueryContext(ctx, "SELECT version FROM module_versions WHERE module_id = $1", moduleId)
+	if err != nil {
+		return c.mapError(err)
+	}
+	versions := []string{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return c.mapError(err)
+		}
+		versions = append(versions, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.mapError(err)
+	}
+
+	latest, _ := service.LatestVersion(versions)
+	if _, err := tx.ExecContext(ctx, "UPDATE modules SET latest_version = $1 WHERE id = $2", latest, moduleId); err != nil {
+		return c.mapError(err)
+	}
+	if c.dialect.IndexLatest == nil {
+		return nil
+	}
+	return c.mapError(c.dialect.IndexLatest(ctx, tx, moduleId, latest))
+}
+
+func (c *Catalog) Namespaces() ([]service.Namespace, error) {
+	ctx := context.Background()
+	rows, err := c.db.QueryContext(ctx, "SELECT name, verified, private, proxied FROM namespaces ORDER BY name"+c.dialect.Collate)
+	if err != nil {
+		return nil, c.mapError(err)
+	}
+	defer rows.Close()
+	namespaces := []service.Namespace{}
+	for rows.Next() {
+		namespace := service.Namespace{}
+		if err := rows.Scan(&namespace.Name, &namespace.Verified, &namespace.Private, &namespace.Proxied); err != nil {
+			return nil, c.mapError(err)
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, c.mapError(err)
+	}
+
+	bindings, err := c.bindings(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range namespaces {
+		namespaces[i].Bindings = bindings[namespaces[i].Name]
+	}
+	return namespaces, nil
+}
+
+func (c *Catalog) Namespace(name string) (service.Namespace, error) {
+	ctx := context.Background()
+	namespace := service.Namespace{}
+	err := c.db.QueryRowContext(ctx, "SELECT name, verified, private, proxied FROM namespaces WHERE name = $1", name).
+		Scan(&namespace.Name, &namespace.Verified, &namespace.Private, &namespace.Proxied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return namespace, fmt.Errorf("namespace %s: %w", name, service.ErrNotFound)
+	}
+	if err != nil {
+		return namespace, c.mapError(err)
+	}
+	bindings, err := c.bindings(ctx, name)
+	if err != nil {
+		return namespace, err
+	}
+	namespace.Bindings = bindings[name]
+	return namespace, nil
+}
+
+// bindings loads the bindings of a namespace or of all namespaces if name is empty
+func (c *Catalog) bindings(ctx context.Context, name string) (map[string][]service.Binding, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT namespace, role, member FROM namespace_bindings
+		WHERE $1 = '' OR namespace = $1 ORDER BY namespace, role, member`, name)
+	if err != nil {
+		return nil, c.mapError(err)
+	}
+	defer rows.Close()
+	bindings := map[string][]service.Binding{}
+	for rows.Next() {
+		var namespace string
+		binding := service.Binding{}
+		if err := rows.Scan(&namespace, &binding.Role, &binding.Member); err != nil {
+			return nil, c.mapError(err)
+		}
+		bindings[namespace] = append(bindings[namespace], binding)
+	}
+	return bindings, c.mapError(rows.Err())
+}
+
+// PutNamespace creates or updates the settings of a namespace and replaces its bindings, namespaces can be set up before modules are published in them
+func (c *Catalog) PutNamespace(namespace service.Namespace) error {
+	ctx := context.Background()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return c.mapError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO namespaces (name, verified, private, proxied) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET verified = EXCLUDED.verified, private = EXCLUDED.private, proxied = EXCLUDED.proxied`,
+		namespace.Name, namespace.Verified, namespace.Private, namespace.Proxied); err != nil {
+		return c.mapError(err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM namespace_bindings WHERE namespace = $1", namespace.Name); err != nil {
+		return c.mapError(err)
+	}
+	for _, binding := range namespace.Bindings {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO namespace_bindings (namespace, role, member) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING`, namespace.Name, binding.Role, binding.Member); err != nil {
+			return c.mapError(err)
+		}
+	}
+	return c.mapError(tx.Commit())
+}
+
+// RecordDownload counts the download for the version and the module as a whole
+func (c *Catalog) RecordDownload(modul service.ModuleDescriptor, version string) error {
+	ctx := context.Background()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return c.mapError(err)
+	}
+	defer tx.Rollback()
+
+	var moduleId int64
+	err = tx.QueryRowContext(ctx, `SELECT m.id
+		FROM modules m
+		JOIN module_versions v ON v.module_id = m.id
+		WHERE m.namespace = $1 AND m.name = $2 AND m.system = $3 AND v.version = $4`,
+		modul.Namespace, modul.Name, modul.System, version).Scan(&moduleId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", versionSubject(modul, version), service.ErrNotFound)
+	}
+	if err != nil {
+		return c.mapError(err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE module_versions SET downloads = downloads + 1 WHERE module_id = $1 AND version = $2", moduleId, version); err != nil {
+		return c.mapError(err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE modules SET downloads = downloads + 1 WHERE id = $1", moduleId); err != nil {
+		return c.mapError(err)
+	}
+	return c.mapError(tx.Commit())
+}
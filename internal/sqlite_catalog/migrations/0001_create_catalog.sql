@@ -0,0 +1,37 @@
+CREATE TABLE namespaces (
+    name       TEXT PRIMARY KEY,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE modules (
+    id             INTEGER PRIMARY KEY,
+    namespace      TEXT NOT NULL REFERENCES namespaces (name),
+    name           TEXT NOT NULL,
+    system         TEXT NOT NULL,
+    -- highest stable version by semver precedence, maintained by the registry on every publish
+    latest_version TEXT NOT NULL,
+    downloads      INTEGER NOT NULL DEFAULT 0,
+    created_at     TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (namespace, name, system)
+);
+
+CREATE INDEX modules_name_idx ON modules (name);
+CREATE INDEX modules_system_idx ON modules (system);
+
+CREATE TABLE module_versions (
+    module_id    INTEGER NOT NULL REFERENCES modules (id) ON DELETE CASCADE,
+    version      TEXT NOT NULL,
+    owner        TEXT NOT NULL,
+    description  TEXT NOT NULL,
+    sha256       TEXT NOT NULL,
+    size         INTEGER NOT NULL,
+    published_at TEXT NOT NULL,
+    updated_at   TEXT NOT NULL,
+    downloads    INTEGER NOT NULL DEFAULT 0,
+    -- the metadata document as stored next to the archive
+    metadata     TEXT NOT NULL,
+    PRIMARY KEY (module_id, version)
+);
+
+-- full text index of the description of the latest version of every module, the rowid is the id of the module
+CREATE VIRTUAL TABLE modules_fts USING fts5 (description, tokenize = 'unicode61 remove_diacritics 2');
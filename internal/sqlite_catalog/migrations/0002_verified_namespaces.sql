@@ -0,0 +1,2 @@
+-- admins mark namespaces verified, their modules are flagged and can be filtered for
+ALTER TABLE namespaces ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- proxied namespaces are resolved against the upstream registry of the server
+ALTER TABLE namespaces ADD COLUMN proxied BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,108 @@
+package sqlitecatalog
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/mxab/tf-registry/internal/catalog"
+	sqlcatalog "github.com/mxab/tf-registry/internal/sql_catalog"
+	"github.com/samber/lo"
+
+	// pure go sqlite driver, registers as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+var _ catalog.Catalog = (*SQLiteCatalog)(nil)
+
+// dialect matches descriptions with a fts5 index, transactions lock the whole database
+var dialect = sqlcatalog.Dialect{
+	Migrations: migrations,
+	MigrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	ExcludedNamespaces: "m.namespace NOT IN (SELECT value FROM json_each($4))",
+	Excluded:           excluded,
+	Search:             search,
+	IndexLatest:        indexLatest,
+}
+
+// SQLiteCatalog keeps the module metadata in a local sqlite database, see the migrations for the schema
+type SQLiteCatalog struct {
+	*sqlcatalog.Catalog
+	db *sql.DB
+}
+
+func NewSQLiteCatalog(db *sql.DB) *SQLiteCatalog {
+	return &SQLiteCatalog{Catalog: sqlcatalog.New(db, dialect), db: db}
+}
+
+// Open opens or creates the database file in WAL mode, so reads do not wait for writes, and migrates it to the current schema.
+// Transactions take the write lock when they begin, concurrent writers wait for it up to the busy timeout
+func Open(ctx context.Context, path string) (*SQLiteCatalog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := NewSQLiteCatalog(db)
+	if err := c.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// excluded passes the excluded namespaces as json array for json_each
+func excluded(namespaces []string) any {
+	data, _ := json.Marshal(append([]string{}, namespaces...))
+	return string(data)
+}
+
+// search matches the query in the namespace, name and provider and the words of the query in the description
+func search(query string) (string, []any) {
+	filter := `(m.namespace LIKE $5 ESCAPE '\' OR m.name LIKE $5 ESCAPE '\' OR m.system LIKE $5 ESCAPE '\'`
+	args := []any{"%" + sqlcatalog.EscapeLike(query) + "%"}
+	if match := ftsQuery(query); match != "" {
+		filter += " OR m.id IN (SELECT rowid FROM modules_fts WHERE modules_fts MATCH $6)"
+		args = append(args, match)
+	}
+	return filter + ")", args
+}
+
+// ftsQuery turns a search query into a fts5 query matching documents that contain all of its words as prefix
+func ftsQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(lo.Map(words, func(word string, _ int) string {
+		return `"` + word + `"*`
+	}), " ")
+}
+
+// indexLatest indexes the description of the latest version
+func indexLatest(ctx context.Context, tx *sql.Tx, moduleId int64, latest string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM modules_fts WHERE rowid = $1", moduleId); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO modules_fts (rowid, description)
+		SELECT module_id, description FROM module_versions WHERE module_id = $1 AND version = $2`, moduleId, latest)
+	return err
+}
@@ -0,0 +1,348 @@
+package sqlitecatalog
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxab/tf-registry/internal/module/service"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func openCatalog(t *testing.T) *SQLiteCatalog {
+	t.Helper()
+	c, err := Open(context.Background(), filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func publish(t *testing.T, c *SQLiteCatalog, namespace, name, system, version string) {
+	t.Helper()
+	publishDescribed(t, c, namespace, name, system, version, fmt.Sprintf("%s module for %s", name, system))
+}
+
+func publishDescribed(t *testing.T, c *SQLiteCatalog, namespace, name, system, version, description string) {
+	t.Helper()
+	now := time.Now().UTC()
+	err := c.Publish(service.ModuleMetadata{
+		Namespace:   namespace,
+		Name:        name,
+		System:      system,
+		Version:     version,
+		Description: description,
+		PublishedAt: now,
+		UpdatedAt:   now,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(result service.ModuleResult) []string {
+	return lo.Map(result.Modules, func(m service.Module, _ int) string { return m.Id })
+}
+
+func TestOpenUsesWriteAheadLog(t *testing.T) {
+	c := openCatalog(t)
+
+	var mode string
+	assert.NoError(t, c.db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	c, err := Open(context.Background(), path)
+	assert.NoError(t, err)
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+	assert.NoError(t, c.Migrate(context.Background()))
+	c.Close()
+
+	// reopening keeps the data
+	c, err = Open(context.Background(), path)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	pending, err := fs.Glob(migrations, "migrations/*.sql")
+	assert.NoError(t, err)
+	var applied int
+	assert.NoError(t, c.db.QueryRow("SELECT count(*) FROM schema_migrations").Scan(&applied))
+	assert.Equal(t, len(pending), applied)
+
+	result, err := c.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+}
+
+func TestList(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "0.1.0")
+	publish(t, c, "hashicorp", "consul", "aws", "0.10.0")
+	publish(t, c, "hashicorp", "consul", "aws", "0.9.0")
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0-rc1")
+	publish(t, c, "hashicorp", "consul", "azurerm", "0.1.0")
+	publish(t, c, "Azure", "network", "azurerm", "1.1.1")
+
+	result, err := c.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Azure/network/azurerm/1.1.1",
+		"hashicorp/consul/aws/0.10.0",
+		"hashicorp/consul/azurerm/0.1.0",
+	}, ids(result))
+	assert.Equal(t, service.ModuleResultMeta{Limit: 10, CurrentOffset: 0, NextOffset: 3, PrevOffset: 0}, result.Meta)
+
+	result, err = c.List(service.ListParams{Limit: 10, Namespace: "hashicorp", Provider: "azurerm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/azurerm/0.1.0"}, ids(result))
+
+	result, err = c.List(service.ListParams{Limit: 10, Namespace: "hashicorp", Name: "consul"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.10.0", "hashicorp/consul/azurerm/0.1.0"}, ids(result))
+
+	result, err = c.List(service.ListParams{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/0.10.0"}, ids(result))
+	assert.Equal(t, service.ModuleResultMeta{Limit: 1, CurrentOffset: 1, NextOffset: 2, PrevOffset: 0}, result.Meta)
+
+	namespaces, err := c.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "Azure"}, {Name: "hashicorp"}}, namespaces)
+}
+
+func TestSearch(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "0.1.0")
+	publish(t, c, "Azure", "network", "azurerm", "1.1.1")
+	publish(t, c, "zoitech", "network", "aws", "0.0.3")
+
+	result, err := c.Search(service.SearchParams{Query: "NETWORK", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Azure/network/azurerm/1.1.1", "zoitech/network/aws/0.0.3"}, ids(result))
+
+	result, err = c.Search(service.SearchParams{Query: "network", Provider: "aws", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"zoitech/network/aws/0.0.3"}, ids(result))
+
+	// like wildcards in the query match literally
+	result, err = c.Search(service.SearchParams{Query: "%", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+}
+
+func TestSearchMatchesWordsOfTheLatestDescription(t *testing.T) {
+	c := openCatalog(t)
+
+	publishDescribed(t, c, "hashicorp", "consul", "aws", "1.0.0", "Deploys a Consul cluster on EC2 instances")
+	publishDescribed(t, c, "hashicorp", "vault", "aws", "1.0.0", "Deploys a Vault cluster")
+	publishDescribed(t, c, "hashicorp", "vault", "aws", "2.0.0", "Highly available secret storage backed by Consul")
+
+	result, err := c.Search(service.SearchParams{Query: "cluster", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	// words match as prefix in any order and case
+	result, err = c.Search(service.SearchParams{Query: "ec2 INST", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	result, err = c.Search(service.SearchParams{Query: "consul", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0", "hashicorp/vault/aws/2.0.0"}, ids(result))
+
+	// fts syntax in the query is not interpreted
+	result, err = c.Search(service.SearchParams{Query: `"secret" OR NEAR(`, Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Modules)
+}
+
+func TestVersionsAndGet(t *testing.T) {
+	c := openCatalog(t)
+
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+	for _, version := range []string{"1.10.0", "1.2.0", "1.9.0"} {
+		publish(t, c, consul.Namespace, consul.Name, consul.System, version)
+	}
+
+	versions, err := c.Versions(consul)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.9.0", "1.10.0"}, lo.Map(versions, func(v service.ModuleVersion, _ int) string { return v.Version }))
+
+	_, err = c.Versions(service.ModuleDescriptor{Namespace: "hashicorp", Name: "vault", System: "aws"})
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	details, err := c.Get(consul, "1.9.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "hashicorp/consul/aws/1.9.0", details.Module.Id)
+	assert.Equal(t, "consul module for aws", details.Module.Description)
+
+	_, err = c.Get(consul, "9.9.9")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestPublishedVersionsAreOnlyReplacedOnRequest(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	replacement := service.ModuleMetadata{Namespace: "hashicorp", Name: "consul", System: "aws", Version: "1.0.0", SHA256: "abc", Description: "replaced"}
+	assert.ErrorIs(t, c.Publish(replacement, false), service.ErrAlreadyExists)
+	assert.NoError(t, c.Publish(replacement, true))
+
+	metadata, err := c.Metadata(consul, "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", metadata.SHA256)
+
+	// the search index follows the replaced description
+	result, err := c.Search(service.SearchParams{Query: "replaced", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+}
+
+func TestConcurrentPublishes(t *testing.T) {
+	c := openCatalog(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.Publish(service.ModuleMetadata{Namespace: "hashicorp", Name: "consul", System: "aws", Version: fmt.Sprintf("1.%d.0", i)}, false)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	result, err := c.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.9.0"}, ids(result))
+}
+
+func TestVerifiedNamespaces(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+	publish(t, c, "zoitech", "network", "aws", "0.0.3")
+
+	_, err := c.Namespace("acme")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+	// namespaces can be verified before they have modules
+	assert.NoError(t, c.PutNamespace(service.Namespace{Name: "acme", Verified: true}))
+	assert.NoError(t, c.PutNamespace(service.Namespace{Name: "hashicorp", Verified: true}))
+
+	namespace, err := c.Namespace("hashicorp")
+	assert.NoError(t, err)
+	assert.Equal(t, service.Namespace{Name: "hashicorp", Verified: true}, namespace)
+
+	namespaces, err := c.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{{Name: "acme", Verified: true}, {Name: "hashicorp", Verified: true}, {Name: "zoitech"}}, namespaces)
+
+	result, err := c.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, lo.Map(result.Modules, func(m service.Module, _ int) bool { return m.Verified }))
+
+	result, err = c.List(service.ListParams{Limit: 10, Verified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	result, err = c.Search(service.SearchParams{Query: "aws", Limit: 10, Verified: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	details, err := c.Get(service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}, "1.0.0")
+	assert.NoError(t, err)
+	assert.True(t, details.Module.Verified)
+
+	// publishing keeps the verification
+	publish(t, c, "hashicorp", "consul", "aws", "1.1.0")
+	namespace, err = c.Namespace("hashicorp")
+	assert.NoError(t, err)
+	assert.True(t, namespace.Verified)
+}
+
+func TestPrivateNamespaces(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+	publish(t, c, "acme", "network", "aws", "0.0.3")
+
+	private := service.Namespace{Name: "acme", Private: true, Bindings: []service.Binding{
+		{Role: service.RoleOwner, Member: "user:alice"},
+		{Role: service.RoleReader, Member: "group:platform"},
+	}}
+	assert.NoError(t, c.PutNamespace(private))
+	namespace, err := c.Namespace("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, private, namespace)
+
+	namespaces, err := c.Namespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []service.Namespace{private, {Name: "hashicorp"}}, namespaces)
+
+	result, err := c.List(service.ListParams{Limit: 10, ExcludedNamespaces: []string{"acme"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+	assert.Equal(t, 10, result.Meta.Limit)
+
+	result, err = c.Search(service.SearchParams{Query: "aws", Limit: 10, ExcludedNamespaces: []string{"acme"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hashicorp/consul/aws/1.0.0"}, ids(result))
+
+	// bindings are replaced as a whole
+	private.Bindings = private.Bindings[:1]
+	assert.NoError(t, c.PutNamespace(private))
+	namespace, err = c.Namespace("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, private, namespace)
+}
+
+func TestProxiedNamespaces(t *testing.T) {
+	c := openCatalog(t)
+
+	// namespaces are proxied before their modules are cached
+	proxied := service.Namespace{Name: "hashicorp", Proxied: true}
+	assert.NoError(t, c.PutNamespace(proxied))
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+
+	namespace, err := c.Namespace("hashicorp")
+	assert.NoError(t, err)
+	assert.Equal(t, proxied, namespace)
+}
+
+func TestRecordDownload(t *testing.T) {
+	c := openCatalog(t)
+
+	publish(t, c, "hashicorp", "consul", "aws", "1.0.0")
+	publish(t, c, "hashicorp", "consul", "aws", "1.1.0")
+	consul := service.ModuleDescriptor{Namespace: "hashicorp", Name: "consul", System: "aws"}
+
+	assert.NoError(t, c.RecordDownload(consul, "1.0.0"))
+	assert.NoError(t, c.RecordDownload(consul, "1.1.0"))
+	assert.NoError(t, c.RecordDownload(consul, "1.1.0"))
+	assert.ErrorIs(t, c.RecordDownload(consul, "9.9.9"), service.ErrNotFound)
+
+	details, err := c.Get(consul, "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, details.Module.Downloads)
+
+	result, err := c.List(service.ListParams{Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Modules, 1) {
+		assert.Equal(t, 3, result.Modules[0].Downloads)
+	}
+}
@@ -0,0 +1,63 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialsFile is the credentials.tfrc.json terraform login writes
+type credentialsFile struct {
+	Credentials map[string]struct {
+		Token string `json:"token"`
+	} `json:"credentials"`
+}
+
+// Token finds the token terraform would send to the registry host,
+// from the TF_TOKEN_<host> environment variable or the credentials.tfrc.json of terraform login. No token is not an error
+func Token(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry url %q: %w", host, err)
+	}
+	hostname := strings.ToLower(u.Hostname())
+	if hostname == "" {
+		return "", nil
+	}
+
+	if token := os.Getenv(tokenVariable(hostname)); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".terraform.d", "credentials.tfrc.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	credentials := credentialsFile{}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return "", fmt.Errorf("failed to parse terraform credentials: %w", err)
+	}
+	// the credentials block is keyed by the host including a non default port
+	for _, key := range []string{strings.ToLower(u.Host), hostname} {
+		if c, ok := credentials.Credentials[key]; ok {
+			return c.Token, nil
+		}
+	}
+	return "", nil
+}
+
+// tokenVariable encodes a hostname like terraform does for TF_TOKEN_<host>, dots become underscores and dashes double underscores
+func tokenVariable(hostname string) string {
+	return "TF_TOKEN_" + strings.NewReplacer("-", "__", ".", "_").Replace(hostname)
+}
@@ -0,0 +1,44 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	assert.NoError(t, os.MkdirAll(filepath.Join(home, ".terraform.d"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".terraform.d", "credentials.tfrc.json"), []byte(`{
+		"credentials": {
+			"registry.example.com": {"token": "from-file"},
+			"localhost:1323": {"token": "with-port"}
+		}
+	}`), 0o600))
+	t.Setenv("TF_TOKEN_my__registry_example_com", "from-env")
+
+	for host, expected := range map[string]string{
+		"https://registry.example.com":    "from-file",
+		"https://Registry.Example.com/":   "from-file",
+		"https://my-registry.example.com": "from-env",
+		"http://localhost:1323":           "with-port",
+		"http://localhost:8080":           "",
+		"https://unknown.example.com":     "",
+	} {
+		token, err := Token(host)
+		assert.NoError(t, err, host)
+		assert.Equal(t, expected, token, host)
+	}
+}
+
+func TestTokenWithoutCredentialsFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	token, err := Token("https://registry.example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", token)
+}
@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProviderFiles finds the files of a provider version in the dist directory of goreleaser,
+// the per platform zips, the manifest and the SHA256SUMS with its signature if the release was signed
+func ProviderFiles(dir, providerType, version string) ([]string, error) {
+	prefix := fmt.Sprintf("terraform-provider-%s_%s_", providerType, strings.TrimPrefix(version, "v"))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		switch suffix := strings.TrimPrefix(name, prefix); {
+		case strings.HasSuffix(suffix, ".zip"), suffix == "manifest.json", suffix == "SHA256SUMS", suffix == "SHA256SUMS.sig":
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files of terraform-provider-%s %s in %s", providerType, version, dir)
+	}
+	return files, nil
+}
+
+// UploadProviderDir uploads the release files of the dist directory to registry host, the token is sent as bearer token unless it is empty
+func UploadProviderDir(dir, host, token, namespace, providerType, version string, protocols, platforms []string) error {
+	files, err := ProviderFiles(dir, providerType, version)
+	if err != nil {
+		return err
+	}
+	return UploadProvider(files, host, token, namespace, providerType, version, protocols, platforms)
+}
+
+// upload to registry host with the /providers/namespace/type/version/upload endpoint
+func UploadProvider(files []string, host, token, namespace, providerType, version string, protocols, platforms []string) error {
+	uploadUrl := fmt.Sprintf("%s/v1/providers/%s/%s/%s/upload", host, namespace, providerType, version)
+
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for _, file := range files {
+		if err := addFormFile(w, file); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteField("protocols", strings.Join(protocols, ",")); err != nil {
+		return err
+	}
+	if err := w.WriteField("platforms", strings.Join(platforms, ",")); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, uploadUrl, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("failed to upload provider, %s\n%s", res.Status, string(body))
+	}
+	return nil
+}
+
+func addFormFile(w *multipart.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := w.CreateFormFile("files", filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
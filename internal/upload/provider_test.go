@@ -0,0 +1,36 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderFilesPicksReleaseFilesOfDist(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"terraform-provider-cloud_1.0.0_linux_amd64.zip",
+		"terraform-provider-cloud_1.0.0_manifest.json",
+		"terraform-provider-cloud_1.0.0_SHA256SUMS",
+		"terraform-provider-cloud_1.0.0_SHA256SUMS.sig",
+		"terraform-provider-cloud_0.9.0_linux_amd64.zip",
+		"config.yaml",
+		"metadata.json",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644))
+	}
+
+	files, err := ProviderFiles(dir, "cloud", "v1.0.0")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "terraform-provider-cloud_1.0.0_linux_amd64.zip"),
+		filepath.Join(dir, "terraform-provider-cloud_1.0.0_manifest.json"),
+		filepath.Join(dir, "terraform-provider-cloud_1.0.0_SHA256SUMS"),
+		filepath.Join(dir, "terraform-provider-cloud_1.0.0_SHA256SUMS.sig"),
+	}, files)
+
+	_, err = ProviderFiles(dir, "cloud", "2.0.0")
+	assert.ErrorContains(t, err, "no files of terraform-provider-cloud 2.0.0")
+}
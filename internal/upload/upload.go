@@ -9,15 +9,15 @@ import (
 	"path/filepath"
 )
 
-// create archtive and upload to registry host
-func UploadDir(dir, host, namespace, name, system, version string) error {
+// create archtive and upload to registry host, the token is sent as bearer token unless it is empty
+func UploadDir(dir, host, token, namespace, name, system, version string) error {
 	file, cleanup, err := Zip(dir)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
 
-	err = Upload(file, host, namespace, name, system, version)
+	err = Upload(file, host, token, namespace, name, system, version)
 	if err != nil {
 		return err
 	}
@@ -26,7 +26,7 @@ func UploadDir(dir, host, namespace, name, system, version string) error {
 }
 
 // upload to registry host with the /modules/namespaces/name/provider/version/upload endpoint
-func Upload(file *os.File, host string, namespace, name, system, version string) error {
+func Upload(file *os.File, host, token string, namespace, name, system, version string) error {
 	uploadUrl := fmt.Sprintf("%s/v1/modules/%s/%s/%s/%s/upload", host, namespace, name, system, version)
 
 	//open the file
@@ -34,11 +34,21 @@ func Upload(file *os.File, host string, namespace, name, system, version string)
 	if err != nil {
 		return err
 	}
-	res, err := http.Post(uploadUrl, "application/zip", file)
+	defer file.Close()
+	req, err := http.NewRequest(http.MethodPost, uploadUrl, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
-	if res.StatusCode != http.StatusOK {
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
 		body, err := io.ReadAll(res.Body)
 
 		if err != nil {
@@ -77,8 +87,12 @@ func Zip(dir string) (*os.File, func() error, error) {
 			return err
 		}
 		defer f.Close()
-		// create a new file in the zip
-		fw, err := w.Create(path)
+		// create a new file in the zip, relative to the module root
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fw, err := w.Create(filepath.ToSlash(rel))
 		if err != nil {
 			return err
 		}
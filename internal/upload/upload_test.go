@@ -1,9 +1,11 @@
 package upload
 
 import (
+	"archive/zip"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 
 	"testing"
 
@@ -26,8 +28,47 @@ func TestUploadDir(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	// call upload dir
-	err = UploadDir(dir, svr.URL, "test", "test", "test", "test")
+	err = UploadDir(dir, svr.URL, "", "test", "test", "test", "test")
 
 	assert.NoError(t, err)
 
 }
+
+func TestZipStoresPathsRelativeToDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "modules", "sub"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(""), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "modules", "sub", "main.tf"), []byte(""), 0o644))
+
+	file, cleanup, err := Zip(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	r, err := zip.OpenReader(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	names := []string{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"main.tf", "modules/sub/main.tf"}, names)
+}
+
+func TestUploadDirSendsToken(t *testing.T) {
+	authorization := ""
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer svr.Close()
+
+	err := UploadDir(t.TempDir(), svr.URL, "secret", "test", "test", "test", "1.0.0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+}
@@ -2,7 +2,9 @@ package test
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/mxab/tf-registry/internal/module/service"
 	"github.com/samber/lo"
@@ -74,6 +76,15 @@ func (m *MockModuleService) List(params service.ListParams) (service.ModuleResul
 	offset := lo.Clamp(params.Offset, 0, len(m.modules))
 
 	filteredModules := lo.Filter(m.modules, func(module service.Module, index int) bool {
+		if params.Name != "" && module.Name != params.Name {
+			return false
+		}
+		if params.Verified && !module.Verified {
+			return false
+		}
+		if lo.Contains(params.ExcludedNamespaces, module.Namespace) {
+			return false
+		}
 		return (params.Provider != "" && module.Provider == params.Provider) ||
 			(params.Namespace != "" && module.Namespace == params.Namespace) ||
 			(params.Namespace == "" && params.Provider == "")
@@ -102,6 +113,12 @@ func (m *MockModuleService) Search(params service.SearchParams) (service.ModuleR
 	offset := lo.Clamp(params.Offset, 0, len(m.modules))
 
 	filteredModules := lo.Filter(m.modules, func(module service.Module, index int) bool {
+		if params.Verified && !module.Verified {
+			return false
+		}
+		if lo.Contains(params.ExcludedNamespaces, module.Namespace) {
+			return false
+		}
 		return module.Id == params.Query ||
 			module.Owner == params.Query ||
 			module.Namespace == params.Query ||
@@ -130,7 +147,7 @@ func (m *MockModuleService) Search(params service.SearchParams) (service.ModuleR
 }
 
 // Versions
-func (m *MockModuleService) Versions(params service.ModuleDescriptor) ([]string, error) {
+func (m *MockModuleService) Versions(params service.ModuleDescriptor) ([]service.ModuleVersion, error) {
 
 	modules := lo.Filter(m.modules, func(module service.Module, index int) bool {
 		return module.Namespace == params.Namespace &&
@@ -138,12 +155,41 @@ func (m *MockModuleService) Versions(params service.ModuleDescriptor) ([]string,
 			module.Provider == params.System
 	})
 
-	return lo.Map(modules, func(module service.Module, _ int) string {
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("module %s/%s/%s: %w", params.Namespace, params.Name, params.System, service.ErrNotFound)
+	}
+
+	versions := lo.Map(modules, func(module service.Module, _ int) string {
 		return module.Version
+	})
+	service.SortVersions(versions)
+
+	return lo.Map(versions, func(version string, _ int) service.ModuleVersion {
+		return service.ModuleVersion{
+			Version: version,
+		}
 	}), nil
 
 }
 
+// Get
+func (m *MockModuleService) Get(params service.ModuleDescriptor, version string) (service.ModuleDetails, error) {
+
+	module, found := lo.Find(m.modules, func(module service.Module) bool {
+		return module.Namespace == params.Namespace &&
+			module.Name == params.Name &&
+			module.Provider == params.System &&
+			module.Version == version
+	})
+	if !found {
+		return service.ModuleDetails{}, fmt.Errorf("module %s/%s/%s %s: %w", params.Namespace, params.Name, params.System, version, service.ErrNotFound)
+	}
+
+	return service.ModuleDetails{
+		Module: module,
+	}, nil
+}
+
 // 	DownloadUrl(ModuleDescriptor, string) (string, error)
 
 func (m *MockModuleService) DownloadUrl(params service.ModuleDescriptor, version string) (string, error) {
@@ -156,7 +202,7 @@ func (m *MockModuleService) DownloadUrl(params service.ModuleDescriptor, version
 	})
 
 	if len(modules) == 0 {
-		return "", errors.New("module not found")
+		return "", fmt.Errorf("module %s/%s/%s %s: %w", params.Namespace, params.Name, params.System, version, service.ErrNotFound)
 	}
 	// return error if modules[0].Source is empty
 	if modules[0].Source == "" {
@@ -166,8 +212,8 @@ func (m *MockModuleService) DownloadUrl(params service.ModuleDescriptor, version
 	return modules[0].Source, nil
 }
 
-// UploadModule
-func (m *MockModuleService) UploadModule(params service.ModuleDescriptor, version string, data io.Reader) error {
+// UploadModule, published versions are immutable unless the upload is forced
+func (m *MockModuleService) UploadModule(params service.ModuleDescriptor, version string, data io.Reader, options service.UploadOptions) error {
 
 	// check if module exists
 	modules := lo.Filter(m.modules, func(module service.Module, index int) bool {
@@ -177,9 +223,22 @@ func (m *MockModuleService) UploadModule(params service.ModuleDescriptor, versio
 			module.Version == version
 	})
 
-	if len(modules) == 0 {
-		return errors.New("module not found")
+	if len(modules) > 0 && !options.Force {
+		return fmt.Errorf("module %s/%s/%s %s: %w", params.Namespace, params.Name, params.System, version, service.ErrAlreadyExists)
+	}
+	if len(modules) > 0 {
+		return nil
 	}
 
+	m.modules = append(m.modules, service.Module{
+		Id:          fmt.Sprintf("%s/%s/%s/%s", params.Namespace, params.Name, params.System, version),
+		Owner:       options.Publisher,
+		Namespace:   params.Namespace,
+		Name:        params.Name,
+		Version:     version,
+		Provider:    params.System,
+		Source:      fmt.Sprintf("https://example.com/%s/%s/%s/%s/module.zip", params.Namespace, params.Name, params.System, version),
+		PublishedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	})
 	return nil
 }
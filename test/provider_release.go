@@ -0,0 +1,79 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	providerservice "github.com/mxab/tf-registry/internal/provider/service"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// NewProviderRelease builds a release with a package for each os_arch platform and its files, the signature is not a valid signature
+func NewProviderRelease(namespace, providerType, version string, platforms ...string) (providerservice.Release, map[string][]byte) {
+	release := providerservice.Release{
+		SchemaVersion: providerservice.ReleaseSchemaVersion,
+		Namespace:     namespace,
+		Type:          providerType,
+		Version:       version,
+		Protocols:     []string{"5.0"},
+		SigningKeys:   []providerservice.SigningKey{{KeyID: "51852D87348FFC4C", ASCIIArmor: "-----BEGIN PGP PUBLIC KEY BLOCK-----"}},
+		Publisher:     "admin",
+		PublishedAt:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	files := map[string][]byte{}
+	shasums := new(strings.Builder)
+	for _, platform := range platforms {
+		os, arch, _ := strings.Cut(platform, "_")
+		filename := release.PackageFilename(os, arch)
+		files[filename] = []byte("package " + filename)
+		sum := sha256.Sum256(files[filename])
+		release.Packages = append(release.Packages, providerservice.Package{OS: os, Arch: arch, Filename: filename, SHA256: hex.EncodeToString(sum[:])})
+		fmt.Fprintf(shasums, "%s  %s\n", hex.EncodeToString(sum[:]), filename)
+	}
+	files[release.ShasumsFilename()] = []byte(shasums.String())
+	files[release.SignatureFilename()] = []byte("signature")
+	return release, files
+}
+
+// NewProviderUpload builds the files goreleaser creates for a provider, a zip with the provider binary for each os_arch platform and the manifest
+func NewProviderUpload(providerType, version string, platforms ...string) map[string][]byte {
+	prefix := fmt.Sprintf("terraform-provider-%s_%s_", providerType, version)
+	files := map[string][]byte{
+		prefix + "manifest.json": []byte(`{"version": 1, "metadata": {"protocol_versions": ["5.0"]}}`),
+	}
+	for _, platform := range platforms {
+		buf := new(bytes.Buffer)
+		w := zip.NewWriter(buf)
+		f, _ := w.Create(fmt.Sprintf("terraform-provider-%s_v%s", providerType, version))
+		_, _ = f.Write([]byte("binary " + platform))
+		_ = w.Close()
+		files[prefix+platform+".zip"] = buf.Bytes()
+	}
+	return files
+}
+
+// NewGPGKey generates an ascii armored private key without passphrase
+func NewGPGKey() ([]byte, error) {
+	entity, err := openpgp.NewEntity("tf-registry", "test", "test@example.com", nil)
+	if err != nil {
+		return nil, err
+	}
+	armored := new(bytes.Buffer)
+	w, err := armor.Encode(armored, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = entity.SerializePrivate(w, nil); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return armored.Bytes(), nil
+}